@@ -0,0 +1,40 @@
+package pulseaudio
+
+import (
+	"context"
+	"fmt"
+)
+
+// NewAutoClient connects to the PulseAudio server with the native protocol and returns the
+// connected Client. If none of the configured servers can be dialed or authentication fails,
+// it falls back to a CliClient which controls the current default sink reported by pactl.
+// ctx only bounds the connection attempts and the pactl probe: the native client keeps running
+// and reconnecting until it is closed.
+func NewAutoClient(ctx context.Context, opts Opts, clientOpts ...ClientOpt) (VolumeController, error) {
+	c := NewClient(opts, clientOpts...)
+	c.attempts = make(chan error, 1)
+	// Close cancels the context of Run
+	go func() { _ = c.Run(context.Background()) }()
+
+	// every configured server gets one attempt
+	var nativeErr error
+	for i := 0; i < len(c.addrs); i++ {
+		select {
+		case nativeErr = <-c.attempts:
+		case <-ctx.Done():
+			c.Close()
+			return nil, ctx.Err()
+		}
+		if nativeErr == nil {
+			return c, nil
+		}
+	}
+	c.Close()
+	c.logger.Errorf("native protocol unavailable, falling back to pactl: %v", nativeErr)
+
+	// probe pactl, the default sink itself is looked up on every call
+	if _, err := runDefaultSink(ctx); err != nil {
+		return nil, fmt.Errorf("PulseAudio error: native protocol failed (%v) and pactl failed: %w", nativeErr, err)
+	}
+	return NewCliClient("", c.logger), nil
+}
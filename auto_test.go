@@ -0,0 +1,73 @@
+package pulseaudio
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakePactl installs a pactl script printing output for every command.
+func fakePactl(t *testing.T, output string) {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "pactl")
+	require.NoError(t, os.WriteFile(p, []byte("#!/bin/sh\ncat <<'EOF'\n"+output+"\nEOF\n"), 0755))
+	old := pactlPath
+	pactlPath = p
+	t.Cleanup(func() { pactlPath = old })
+}
+
+func TestNewAutoClientNative(t *testing.T) {
+	srv := newFakeServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+
+	vc, err := NewAutoClient(ctx, Opts{Addr: srv.uri(), Cookie: fakeCookie(t), RequestTimeout: time.Second})
+	require.NoError(t, err)
+	c, ok := vc.(*Client)
+	require.True(t, ok, "expected the native client, got %T", vc)
+	defer c.Close()
+	// the client outlives the context of the connection attempts
+	cancel()
+	vol, err := c.Volume(context.Background())
+	require.NoError(t, err)
+	assert.InDelta(t, 0.5, vol, 0.001)
+}
+
+func TestNewAutoClientFallback(t *testing.T) {
+	dir := t.TempDir()
+	writeInfo := func(defaultSink string) {
+		info := "Server String: /run/user/1000/pulse/native\nDefault Sink: " + defaultSink + "\nDefault Source: alsa_input.zone1\n"
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "info"), []byte(info), 0644))
+	}
+	writeInfo("alsa_output.zone1")
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "sinks"), []byte(testSinks), 0644))
+	script := "#!/bin/sh\nif [ \"$1\" = info ]; then cat " + filepath.Join(dir, "info") + "; else cat " + filepath.Join(dir, "sinks") + "; fi\n"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "pactl"), []byte(script), 0755))
+	old := pactlPath
+	pactlPath = filepath.Join(dir, "pactl")
+	t.Cleanup(func() { pactlPath = old })
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	missing := "unix://" + filepath.Join(t.TempDir(), "missing")
+	vc, err := NewAutoClient(ctx, Opts{Addr: missing, Cookie: fakeCookie(t)}, WithReconnectInterval(time.Millisecond))
+	require.NoError(t, err)
+	_, ok := vc.(*CliClient)
+	require.True(t, ok, "expected the pactl client, got %T", vc)
+	vol, err := vc.Volume(ctx)
+	require.NoError(t, err)
+	assert.InDelta(t, 0.7, vol, 0.001)
+
+	// the default sink is looked up on every call
+	writeInfo("missing")
+	_, err = vc.Volume(ctx)
+	assert.ErrorIs(t, err, ErrSinkNotFound)
+
+	pactlPath = filepath.Join(t.TempDir(), "missing")
+	_, err = NewAutoClient(ctx, Opts{Addr: missing, Cookie: fakeCookie(t)}, WithReconnectInterval(time.Millisecond))
+	assert.Error(t, err)
+}
@@ -0,0 +1,70 @@
+package pulseaudio
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Bluetooth cards offer one A2DP sink profile per codec, e.g. a2dp-sink-aac or
+// a2dp-sink-aptx_hd. Older versions only have a single SBC profile called
+// a2dp-sink (a2dp_sink before PulseAudio 14).
+const (
+	a2dpSinkProfile       = "a2dp-sink"
+	legacyA2dpSinkProfile = "a2dp_sink"
+	defaultA2dpCodec      = "sbc"
+)
+
+// bluetoothCodec returns the codec selected by an A2DP sink profile.
+func bluetoothCodec(profile string) (string, bool) {
+	switch {
+	case profile == a2dpSinkProfile, profile == legacyA2dpSinkProfile:
+		return defaultA2dpCodec, true
+	case strings.HasPrefix(profile, a2dpSinkProfile+"-"):
+		return strings.TrimPrefix(profile, a2dpSinkProfile+"-"), true
+	}
+	return "", false
+}
+
+// BluetoothCodecs returns the codecs of the available A2DP sink profiles of the
+// named card, sorted by name.
+func (c *Client) BluetoothCodecs(ctx context.Context, cardName string) ([]string, error) {
+	card, err := c.GetCardByName(ctx, cardName)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	var codecs []string
+	for _, profile := range card.Profiles {
+		codec, ok := bluetoothCodec(profile.Name)
+		if !ok || profile.Available == 0 || seen[codec] {
+			continue
+		}
+		seen[codec] = true
+		codecs = append(codecs, codec)
+	}
+	sort.Strings(codecs)
+	return codecs, nil
+}
+
+// SetBluetoothCodec switches the named card to the A2DP sink profile using codec.
+func (c *Client) SetBluetoothCodec(ctx context.Context, cardName, codec string) error {
+	card, err := c.GetCardByName(ctx, cardName)
+	if err != nil {
+		return err
+	}
+	var selected *Profile
+	for _, profile := range card.Profiles {
+		if name, ok := bluetoothCodec(profile.Name); ok && name == codec {
+			// prefer the codec specific profile over the legacy one
+			if selected == nil || profile.Name != a2dpSinkProfile && profile.Name != legacyA2dpSinkProfile {
+				selected = profile
+			}
+		}
+	}
+	if selected == nil {
+		return fmt.Errorf("PulseAudio error: card %s does not support bluetooth codec %s", cardName, codec)
+	}
+	return c.SetCardProfileByIndex(ctx, card.Index, selected)
+}
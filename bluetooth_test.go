@@ -0,0 +1,69 @@
+package pulseaudio
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func fakeBluetoothCard() Card {
+	profiles := map[string]*Profile{}
+	for _, p := range []*Profile{
+		{Name: "a2dp-sink", Description: "High Fidelity Playback (A2DP Sink)", Nsinks: 1, Priority: 40, Available: 1},
+		{Name: "a2dp-sink-sbc", Description: "High Fidelity Playback (A2DP Sink, codec SBC)", Nsinks: 1, Priority: 40, Available: 1},
+		{Name: "a2dp-sink-aac", Description: "High Fidelity Playback (A2DP Sink, codec AAC)", Nsinks: 1, Priority: 50, Available: 1},
+		{Name: "a2dp-sink-aptx", Description: "High Fidelity Playback (A2DP Sink, codec aptX)", Nsinks: 1, Priority: 60, Available: 1},
+		{Name: "a2dp-sink-ldac", Description: "High Fidelity Playback (A2DP Sink, codec LDAC)", Nsinks: 1, Priority: 70, Available: 0},
+		{Name: "headset-head-unit", Description: "Headset Head Unit (HSP/HFP)", Nsinks: 1, Nsources: 1, Priority: 30, Available: 1},
+		{Name: "off", Description: "Off", Available: 1},
+	} {
+		profiles[p.Name] = p
+	}
+	return Card{
+		Index:         4,
+		Name:          "bluez_card.00_11_22_33_44_55",
+		Driver:        "module-bluez5-device.c",
+		Profiles:      profiles,
+		ActiveProfile: profiles["a2dp-sink-sbc"],
+		PropList:      map[string]string{"device.description": "Headphones"},
+	}
+}
+
+func TestBluetoothCodec(t *testing.T) {
+	for profile, codec := range map[string]string{
+		"a2dp-sink":         "sbc",
+		"a2dp_sink":         "sbc",
+		"a2dp-sink-aptx_hd": "aptx_hd",
+		"headset-head-unit": "",
+		"a2dp-source":       "",
+	} {
+		got, ok := bluetoothCodec(profile)
+		assert.Equal(t, codec != "", ok, profile)
+		assert.Equal(t, codec, got, profile)
+	}
+}
+
+func TestBluetoothCodecs(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.cards = append(srv.cards, fakeBluetoothCard())
+	c := newFakeClient(t, srv)
+	ctx := context.Background()
+	name := "bluez_card.00_11_22_33_44_55"
+
+	codecs, err := c.BluetoothCodecs(ctx, name)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"aac", "aptx", "sbc"}, codecs)
+
+	require.NoError(t, c.SetBluetoothCodec(ctx, name, "aptx"))
+	assert.Equal(t, "a2dp-sink-aptx", srv.card(name).ActiveProfile.Name)
+	require.NoError(t, c.SetBluetoothCodec(ctx, name, "sbc"))
+	assert.Equal(t, "a2dp-sink-sbc", srv.card(name).ActiveProfile.Name)
+
+	err = c.SetBluetoothCodec(ctx, name, "ldac")
+	assert.True(t, errors.Is(err, ErrProfileUnavailable), "unexpected error: %v", err)
+	assert.Error(t, c.SetBluetoothCodec(ctx, name, "opus"))
+	assert.Error(t, c.SetBluetoothCodec(ctx, "missing", "sbc"))
+}
@@ -11,9 +11,14 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 )
 
 var ErrSinkNotFound = errs.New("sink not found in output")
+var ErrSourceNotFound = errs.New("source not found in output")
+
+// pactlPath is the pactl binary run by CliClient.
+var pactlPath = "/usr/bin/pactl"
 
 type Logger interface {
 	Info(msg string)
@@ -21,6 +26,21 @@ type Logger interface {
 	Errorf(msg string, args ...interface{})
 }
 
+// DebugLogger is a Logger which also receives verbose diagnostics, e.g. a trace of every
+// request and reply exchanged with the server. Loggers which don't implement it get no
+// debug output.
+type DebugLogger interface {
+	Logger
+	Debugf(msg string, args ...interface{})
+}
+
+// debugf passes a diagnostic message to logger if it is a DebugLogger.
+func debugf(logger Logger, msg string, args ...interface{}) {
+	if l, ok := logger.(DebugLogger); ok {
+		l.Debugf(msg, args...)
+	}
+}
+
 type discardLogger struct{}
 
 func (d discardLogger) Info(_ string) {}
@@ -29,13 +49,22 @@ func (d discardLogger) Infof(_ string, _ ...interface{}) {}
 
 func (d discardLogger) Errorf(_ string, _ ...interface{}) {}
 
-var _ Logger = discardLogger{}
+func (d discardLogger) Debugf(_ string, _ ...interface{}) {}
+
+var _ DebugLogger = discardLogger{}
 
 type CliClient struct {
 	defaultSink string
 	logger      Logger
+
+	jsonMu     sync.Mutex
+	jsonProbed bool
+	json       bool
 }
 
+// NewCliClient returns a VolumeController which controls defaultSink by running pactl. If
+// defaultSink is empty, the default sink of the server is looked up on every call, so that the
+// client follows changes of the default sink.
 func NewCliClient(defaultSink string, logger Logger) *CliClient {
 	return &CliClient{
 		defaultSink: defaultSink,
@@ -43,90 +72,240 @@ func NewCliClient(defaultSink string, logger Logger) *CliClient {
 	}
 }
 
-func (cli *CliClient) SetVolume(volume float32) error {
-	ctx := context.Background()
-	sinks, err := runListSinks(ctx, cli.logger)
+var _ VolumeController = (*CliClient)(nil)
+
+// sinkName returns the name of the sink the volume methods control.
+func (cli *CliClient) sinkName(ctx context.Context) (string, error) {
+	if cli.defaultSink != "" {
+		return cli.defaultSink, nil
+	}
+	name, err := runDefaultSink(ctx)
+	if err != nil {
+		return "", fmt.Errorf("could not get the default sink: %w", err)
+	}
+	return name, nil
+}
+
+func (cli *CliClient) SetVolume(ctx context.Context, volume float32) error {
+	name, err := cli.sinkName(ctx)
+	if err != nil {
+		return err
+	}
+	sinks, err := runListSinks(ctx, cli.logger, cli.supportsJSON(ctx))
 	if err != nil {
 		return fmt.Errorf("could not get sinks info: %w", err)
 	}
 	for _, s := range sinks {
-		if s.Name == cli.defaultSink {
-			return runSetVolume(ctx, s.Index, uint32(volume*100))
+		if s.Name == name {
+			return runSetVolume(ctx, cli.logger, s.Index, pactlPercent(volume))
 		}
 	}
 	return ErrSinkNotFound
 }
 
-func (cli *CliClient) SetMute(mute bool) error {
-	ctx := context.Background()
-	sinks, err := runListSinks(ctx, cli.logger)
+func (cli *CliClient) SetMute(ctx context.Context, mute bool) error {
+	name, err := cli.sinkName(ctx)
+	if err != nil {
+		return err
+	}
+	sinks, err := runListSinks(ctx, cli.logger, cli.supportsJSON(ctx))
 	if err != nil {
 		return fmt.Errorf("could not get sinks info: %w", err)
 	}
 	for _, s := range sinks {
-		if s.Name == cli.defaultSink {
-			return runSetMute(ctx, s.Index, mute)
+		if s.Name == name {
+			return runSetMute(ctx, cli.logger, s.Index, mute)
 		}
 	}
 	return ErrSinkNotFound
 }
 
-func (cli *CliClient) Volume() (float32, error) {
-	sinks, err := runListSinks(context.Background(), cli.logger)
+func (cli *CliClient) Volume(ctx context.Context) (float32, error) {
+	name, err := cli.sinkName(ctx)
+	if err != nil {
+		return 0.0, err
+	}
+	sinks, err := runListSinks(ctx, cli.logger, cli.supportsJSON(ctx))
 	if err != nil {
 		return 0.0, fmt.Errorf("could not get sinks info: %w", err)
 	}
 	for _, s := range sinks {
-		if s.Name == cli.defaultSink {
-			if len(s.CVolume) == 0 {
-				return 0.0, nil
-			}
-			return float32(s.CVolume[0]) / 100, nil
+		if s.Name == name {
+			// pactl reports percentages
+			return float32(s.CVolume.Avg()) / 100, nil
 		}
 	}
 	return 0.0, ErrSinkNotFound
 }
 
-func (cli *CliClient) Mute() (bool, error) {
-	sinks, err := runListSinks(context.Background(), cli.logger)
+func (cli *CliClient) Mute(ctx context.Context) (bool, error) {
+	name, err := cli.sinkName(ctx)
+	if err != nil {
+		return false, err
+	}
+	sinks, err := runListSinks(ctx, cli.logger, cli.supportsJSON(ctx))
 	if err != nil {
 		return false, fmt.Errorf("could not get sinks info: %w", err)
 	}
 	for _, s := range sinks {
-		if s.Name == cli.defaultSink {
+		if s.Name == name {
 			return s.Muted, nil
 		}
 	}
 	return false, ErrSinkNotFound
 }
 
+// ToggleMute reverses the mute status of the default sink and returns the new status.
+func (cli *CliClient) ToggleMute(ctx context.Context) (bool, error) {
+	muted, err := cli.Mute(ctx)
+	if err != nil {
+		return false, err
+	}
+	return !muted, cli.SetMute(ctx, !muted)
+}
+
+// findSource looks up a source by name in the output of pactl list sources.
+func (cli *CliClient) findSource(ctx context.Context, sourceName string) (*Source, error) {
+	sources, err := runListSources(ctx, cli.logger, cli.supportsJSON(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("could not get sources info: %w", err)
+	}
+	for _, s := range sources {
+		if s.Name == sourceName {
+			return s, nil
+		}
+	}
+	return nil, ErrSourceNotFound
+}
+
+// SourceVolume returns the volume of the named source as a number from 0 to 1 (or more if boosted).
+func (cli *CliClient) SourceVolume(ctx context.Context, sourceName string) (float32, error) {
+	s, err := cli.findSource(ctx, sourceName)
+	if err != nil {
+		return 0.0, err
+	}
+	return float32(s.CVolume.Avg()) / 100, nil
+}
+
+// SetSourceVolume changes the volume of the named source.
+func (cli *CliClient) SetSourceVolume(ctx context.Context, sourceName string, volume float32) error {
+	s, err := cli.findSource(ctx, sourceName)
+	if err != nil {
+		return err
+	}
+	return runSetSourceVolume(ctx, cli.logger, s.Index, pactlPercent(volume))
+}
+
+// SourceMute returns the mute status of the named source.
+func (cli *CliClient) SourceMute(ctx context.Context, sourceName string) (bool, error) {
+	s, err := cli.findSource(ctx, sourceName)
+	if err != nil {
+		return false, err
+	}
+	return s.Muted, nil
+}
+
+// supportsJSON reports whether pactl can list sinks and sources as JSON. The result is cached once
+// the probe succeeds, so a probe cut short by ctx is retried by the next call.
+func (cli *CliClient) supportsJSON(ctx context.Context) bool {
+	cli.jsonMu.Lock()
+	defer cli.jsonMu.Unlock()
+	if cli.jsonProbed {
+		return cli.json
+	}
+	ok, err := runSupportsJSON(ctx)
+	if err != nil {
+		cli.logger.Errorf("could not probe pactl version: %v", err)
+		return false
+	}
+	cli.json, cli.jsonProbed = ok, true
+	return ok
+}
+
 var beginSinkRegex = regexp.MustCompile(`^Sink #(\d+)`)
+var beginSourceRegex = regexp.MustCompile(`^Source #(\d+)`)
 var volumeRegex = regexp.MustCompile(`\d+ / +(\d+)% +/ +-?(?:\d+.\d+|inf) dB`)
 
-func runListSinks(ctx context.Context, logger Logger) ([]*Sink, error) {
-	cmd := exec.CommandContext(ctx, "/usr/bin/pactl", "list", "sinks")
+func runListSinks(ctx context.Context, logger Logger, json bool) ([]*Sink, error) {
+	args := []string{"list", "sinks"}
+	if json {
+		args = append([]string{"--format=json"}, args...)
+	}
+	cmd := exec.CommandContext(ctx, pactlPath, args...)
 	out, err := cmd.Output()
 	if err != nil {
 		return nil, fmt.Errorf("error executing command: %w", err)
 	}
+	if json {
+		return parseSinksJSON(bytes.NewBuffer(out))
+	}
 	return parseSinks(bytes.NewBuffer(out), logger)
 }
 
-func runSetVolume(ctx context.Context, sink uint32, vol uint32) error {
-	args := []string{"set-sink-volume", fmt.Sprintf("%d", sink), fmt.Sprintf("%d%%", vol)}
-	fmt.Println(args)
-	cmd := exec.CommandContext(ctx, "/usr/bin/pactl", args...)
-	_, err := cmd.Output()
+// runDefaultSink returns the name of the default sink reported by pactl info.
+func runDefaultSink(ctx context.Context) (string, error) {
+	cmd := exec.CommandContext(ctx, pactlPath, "info")
+	out, err := cmd.Output()
 	if err != nil {
-		return fmt.Errorf("error executing command: %w", err)
+		return "", fmt.Errorf("error executing command: %w", err)
 	}
-	return nil
+	scan := bufio.NewScanner(bytes.NewReader(out))
+	for scan.Scan() {
+		token, _, reminder := readToken(scan.Text(), false)
+		if token == "Default Sink" {
+			name, _, _ := readToken(reminder, true)
+			return name, nil
+		}
+	}
+	return "", ErrSinkNotFound
+}
+
+func runListSources(ctx context.Context, logger Logger, json bool) ([]*Source, error) {
+	args := []string{"list", "sources"}
+	if json {
+		args = append([]string{"--format=json"}, args...)
+	}
+	cmd := exec.CommandContext(ctx, pactlPath, args...)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("error executing command: %w", err)
+	}
+	if json {
+		return parseSourcesJSON(bytes.NewBuffer(out))
+	}
+	return parseSources(bytes.NewBuffer(out), logger)
 }
 
-func runSetMute(ctx context.Context, sink uint32, mute bool) error {
+// pactlPercent converts a volume (1 is 100%) to the percentage passed to pactl. Negative volumes
+// are clamped to 0 instead of wrapping around.
+func pactlPercent(volume float32) uint32 {
+	if volume < 0 {
+		return 0
+	}
+	return uint32(volume * 100)
+}
+
+func runSetVolume(ctx context.Context, logger Logger, sink uint32, vol uint32) error {
+	args := []string{"set-sink-volume", fmt.Sprintf("%d", sink), fmt.Sprintf("%d%%", vol)}
+	debugf(logger, "running pactl %s", strings.Join(args, " "))
+	return runPactl(ctx, args...)
+}
+
+func runSetSourceVolume(ctx context.Context, logger Logger, source uint32, vol uint32) error {
+	args := []string{"set-source-volume", fmt.Sprintf("%d", source), fmt.Sprintf("%d%%", vol)}
+	debugf(logger, "running pactl %s", strings.Join(args, " "))
+	return runPactl(ctx, args...)
+}
+
+func runSetMute(ctx context.Context, logger Logger, sink uint32, mute bool) error {
 	args := []string{"set-sink-mute", fmt.Sprintf("%d", sink), fmt.Sprintf("%v", mute)}
-	fmt.Println(args)
-	cmd := exec.CommandContext(ctx, "/usr/bin/pactl", args...)
+	debugf(logger, "running pactl %s", strings.Join(args, " "))
+	return runPactl(ctx, args...)
+}
+
+// runPactl runs a pactl command which doesn't produce output.
+func runPactl(ctx context.Context, args ...string) error {
+	cmd := exec.CommandContext(ctx, pactlPath, args...)
 	_, err := cmd.Output()
 	if err != nil {
 		return fmt.Errorf("error executing command: %w", err)
@@ -135,11 +314,78 @@ func runSetMute(ctx context.Context, sink uint32, mute bool) error {
 }
 
 func parseSinks(r io.Reader, logger Logger) ([]*Sink, error) {
-	scan := bufio.NewScanner(r)
 	var sinks []*Sink
 	var sink *Sink
+	err := parseList(r, beginSinkRegex, logger, func(index uint32) {
+		sink = &Sink{Index: index}
+		sinks = append(sinks, sink)
+	}, func(token, reminder string) error {
+		switch token {
+		case "Volume":
+			cvolume, err := parseVolume(reminder)
+			if err != nil {
+				return err
+			}
+			sink.CVolume = cvolume
+		case "Mute":
+			token, _, _ := readToken(reminder, true)
+			sink.Muted = token == "yes"
+		case "Name":
+			token, _, _ := readToken(reminder, true)
+			sink.Name = token
+		case "Flags":
+			sink.Flags = parseSinkFlags(reminder)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("sink scanner error: %w", err)
+	}
+	return sinks, nil
+}
 
-ScanLine:
+// parseSources reads the output of pactl list sources.
+func parseSources(r io.Reader, logger Logger) ([]*Source, error) {
+	var sources []*Source
+	var source *Source
+	err := parseList(r, beginSourceRegex, logger, func(index uint32) {
+		source = &Source{Index: index}
+		sources = append(sources, source)
+	}, func(token, reminder string) error {
+		switch token {
+		case "Volume":
+			cvolume, err := parseVolume(reminder)
+			if err != nil {
+				return err
+			}
+			source.CVolume = cvolume
+		case "Mute":
+			token, _, _ := readToken(reminder, true)
+			source.Muted = token == "yes"
+		case "Name":
+			token, _, _ := readToken(reminder, true)
+			source.Name = token
+		case "Monitor of Sink":
+			token, _, _ := readToken(reminder, true)
+			if token != "n/a" {
+				source.MonitorSinkName = token
+			}
+		case "Flags":
+			source.Flags = parseSourceFlags(reminder)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("source scanner error: %w", err)
+	}
+	return sources, nil
+}
+
+// parseList reads a pactl listing. start is called for every line matching begin and field for
+// every property of the current entry.
+func parseList(r io.Reader, begin *regexp.Regexp, logger Logger, start func(index uint32), field func(token, reminder string) error) error {
+	scan := bufio.NewScanner(r)
+	started := false
 	for scan.Scan() {
 		line := scan.Text()
 
@@ -147,60 +393,47 @@ ScanLine:
 		token, indent, reminder := readToken(line, false)
 		switch indent {
 		case 0:
-			parts := beginSinkRegex.FindStringSubmatch(token)
+			parts := begin.FindStringSubmatch(token)
 			if len(parts) != 2 {
 				continue
 			}
-			if sink != nil {
-				sinks = append(sinks, sink)
-			}
-			sink = &Sink{}
 			idx, err := strconv.Atoi(parts[1])
 			if err != nil {
-				logger.Errorf("unexpected sink index format: %s", parts[1])
+				logger.Errorf("unexpected index format: %s", parts[1])
 			}
-			sink.Index = uint32(idx)
-			continue
+			start(uint32(idx))
+			started = true
 		case 1:
-			if sink == nil {
+			if !started {
 				// ignore
 				continue
 			}
-			switch token {
-			case "Volume":
-				parts := volumeRegex.FindAllStringSubmatch(reminder, -1)
-				if len(parts) < 2 {
-					return sinks, fmt.Errorf("invalid volume line: %s", reminder)
-				}
-				for i := 0; i < len(parts); i++ {
-					if len(parts[i]) < 2 {
-						continue
-					}
-					vol, err := strconv.Atoi(parts[i][1])
-					if err != nil {
-						return sinks, fmt.Errorf("invalid base volume format (%s): %w", parts[1], err)
-					}
-					sink.CVolume = append(sink.CVolume, uint32(vol))
-				}
-			case "Mute":
-				token, _, _ := readToken(reminder, true)
-				sink.Muted = token == "yes"
-			case "Name":
-				token, _, _ := readToken(reminder, true)
-				sink.Name = token
-			default:
-				continue ScanLine
+			if err := field(token, reminder); err != nil {
+				return err
 			}
 		}
 	}
-	if sink != nil {
-		sinks = append(sinks, sink)
+	return scan.Err()
+}
+
+// parseVolume reads the volume percentages of all channels.
+func parseVolume(reminder string) (CVolume, error) {
+	parts := volumeRegex.FindAllStringSubmatch(reminder, -1)
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("invalid volume line: %s", reminder)
 	}
-	err := scan.Err()
-	if err != nil {
-		return nil, fmt.Errorf("sink scanner error: %w", err)
+	var cvolume CVolume
+	for i := 0; i < len(parts); i++ {
+		if len(parts[i]) < 2 {
+			continue
+		}
+		vol, err := strconv.Atoi(parts[i][1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid base volume format (%s): %w", parts[i][0], err)
+		}
+		cvolume = append(cvolume, uint32(vol))
 	}
-	return sinks, nil
+	return cvolume, nil
 }
 
 func readToken(line string, isText bool) (string, int, string) {
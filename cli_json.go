@@ -0,0 +1,124 @@
+package pulseaudio
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// minJSONPactlVersion is the first pactl version supporting --format=json.
+const minJSONPactlVersion = 16
+
+var pactlVersionRegex = regexp.MustCompile(`^pactl (\d+)\.`)
+
+// runSupportsJSON probes pactl --version to find out whether pactl can produce JSON output.
+func runSupportsJSON(ctx context.Context) (bool, error) {
+	cmd := exec.CommandContext(ctx, pactlPath, "--version")
+	out, err := cmd.Output()
+	if err != nil {
+		return false, fmt.Errorf("error executing command: %w", err)
+	}
+	line, _, _ := bufio.NewReader(bytes.NewReader(out)).ReadLine()
+	parts := pactlVersionRegex.FindSubmatch(line)
+	if len(parts) != 2 {
+		return false, nil
+	}
+	major, err := strconv.Atoi(string(parts[1]))
+	if err != nil {
+		return false, nil
+	}
+	return major >= minJSONPactlVersion, nil
+}
+
+// jsonVolume is a single channel volume as printed by pactl --format=json.
+type jsonVolume struct {
+	Value        uint32 `json:"value"`
+	ValuePercent string `json:"value_percent"`
+}
+
+// jsonDevice holds the fields shared by sinks and sources in pactl --format=json output.
+type jsonDevice struct {
+	Index         uint32                `json:"index"`
+	Name          string                `json:"name"`
+	Mute          bool                  `json:"mute"`
+	ChannelMap    string                `json:"channel_map"`
+	Volume        map[string]jsonVolume `json:"volume"`
+	Flags         []string              `json:"flags"`
+	MonitorOfSink string                `json:"monitor_of_sink"`
+}
+
+// cvolume returns the volume percentages in channel map order.
+func (d *jsonDevice) cvolume() (CVolume, error) {
+	var cvolume CVolume
+	for _, ch := range strings.Split(d.ChannelMap, ",") {
+		v, ok := d.Volume[ch]
+		if !ok {
+			continue
+		}
+		pct, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(v.ValuePercent), "%"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid volume percentage (%s): %w", v.ValuePercent, err)
+		}
+		cvolume = append(cvolume, uint32(pct))
+	}
+	return cvolume, nil
+}
+
+// parseSinksJSON reads the output of pactl --format=json list sinks.
+func parseSinksJSON(r io.Reader) ([]*Sink, error) {
+	var devices []jsonDevice
+	if err := json.NewDecoder(r).Decode(&devices); err != nil {
+		return nil, fmt.Errorf("sink decoder error: %w", err)
+	}
+	sinks := make([]*Sink, 0, len(devices))
+	for i := range devices {
+		d := &devices[i]
+		cvolume, err := d.cvolume()
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, &Sink{
+			Index:   d.Index,
+			Name:    d.Name,
+			Muted:   d.Mute,
+			CVolume: cvolume,
+			Flags:   parseSinkFlags(strings.Join(d.Flags, " ")),
+		})
+	}
+	return sinks, nil
+}
+
+// parseSourcesJSON reads the output of pactl --format=json list sources.
+func parseSourcesJSON(r io.Reader) ([]*Source, error) {
+	var devices []jsonDevice
+	if err := json.NewDecoder(r).Decode(&devices); err != nil {
+		return nil, fmt.Errorf("source decoder error: %w", err)
+	}
+	sources := make([]*Source, 0, len(devices))
+	for i := range devices {
+		d := &devices[i]
+		cvolume, err := d.cvolume()
+		if err != nil {
+			return nil, err
+		}
+		s := &Source{
+			Index:   d.Index,
+			Name:    d.Name,
+			Muted:   d.Mute,
+			CVolume: cvolume,
+			Flags:   parseSourceFlags(strings.Join(d.Flags, " ")),
+		}
+		if d.MonitorOfSink != "n/a" {
+			s.MonitorSinkName = d.MonitorOfSink
+		}
+		sources = append(sources, s)
+	}
+	return sources, nil
+}
@@ -0,0 +1,103 @@
+package pulseaudio
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSinksJSON(t *testing.T) {
+	sinks, err := parseSinksJSON(bytes.NewBufferString(testSinksJSON))
+	require.NoError(t, err)
+	if assert.Len(t, sinks, 2) {
+		assert.Equal(t, uint32(0), sinks[0].Index)
+		assert.Equal(t, "null", sinks[0].Name)
+		assert.Equal(t, CVolume{74, 74}, sinks[0].CVolume)
+		assert.True(t, sinks[0].Muted)
+		assert.Equal(t, SinkDecibelVolume|SinkLatency, sinks[0].Flags)
+		assert.Equal(t, "alsa_output.zone1", sinks[1].Name)
+		assert.Equal(t, CVolume{70, 60}, sinks[1].CVolume)
+		assert.False(t, sinks[1].Muted)
+	}
+}
+
+func TestParseSourcesJSON(t *testing.T) {
+	sources, err := parseSourcesJSON(bytes.NewBufferString(testSourcesJSON))
+	require.NoError(t, err)
+	if assert.Len(t, sources, 2) {
+		assert.Equal(t, "null.monitor", sources[0].Name)
+		assert.Equal(t, "null", sources[0].MonitorSinkName)
+		assert.Equal(t, "alsa_input.zone1", sources[1].Name)
+		assert.Equal(t, "", sources[1].MonitorSinkName)
+		assert.Equal(t, CVolume{45, 45}, sources[1].CVolume)
+		assert.True(t, sources[1].Muted)
+		assert.Equal(t, SourceHardware|SourceDecibelVolume|SourceLatency, sources[1].Flags)
+	}
+}
+
+func TestCliClientJSON(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "sinks.json"), []byte(testSinksJSON), 0644))
+	script := "#!/bin/sh\n" +
+		"case \"$*\" in\n" +
+		"--version) printf 'pactl 16.1\\nCompiled with libpulse 16.1.0\\n' ;;\n" +
+		"'--format=json list sinks') cat " + filepath.Join(dir, "sinks.json") + " ;;\n" +
+		"*) exit 1 ;;\n" +
+		"esac\n"
+	p := filepath.Join(dir, "pactl")
+	require.NoError(t, os.WriteFile(p, []byte(script), 0755))
+	old := pactlPath
+	pactlPath = p
+	t.Cleanup(func() { pactlPath = old })
+
+	cli := NewCliClient("alsa_output.zone1", logger{})
+	vol, err := cli.Volume(context.Background())
+	require.NoError(t, err)
+	// the average of 70% and 60%
+	assert.InDelta(t, 0.65, vol, 0.001)
+}
+
+func TestSupportsJSON(t *testing.T) {
+	for _, tc := range []struct {
+		version string
+		want    bool
+	}{
+		{"pactl 16.1\nCompiled with libpulse 16.1.0", true},
+		{"pactl 17.0", true},
+		{"pactl 15.0\nCompiled with libpulse 15.0.0", false},
+		{"pactl 13.99.1", false},
+		{"garbage", false},
+	} {
+		fakePactl(t, tc.version)
+		ok, err := runSupportsJSON(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, tc.want, ok, tc.version)
+	}
+}
+
+const testSinksJSON = `[{"index":0,"state":"IDLE","name":"null","description":"Null Output","driver":"module-null-sink.c",` +
+	`"sample_specification":"s16le 2ch 44100Hz","channel_map":"front-left,front-right","owner_module":0,"mute":true,` +
+	`"volume":{"front-left":{"value":48497,"value_percent":"74%","db":"-7.85 dB"},"front-right":{"value":48497,"value_percent":"74%","db":"-7.85 dB"}},` +
+	`"balance":0.000000,"base_volume":{"value":65536,"value_percent":"100%","db":"0.00 dB"},"monitor_source":"null.monitor",` +
+	`"latency":{"actual":0.000000,"configured":0.000000},"flags":["DECIBEL_VOLUME","LATENCY"],"properties":{"device.class":"abstract"},` +
+	`"ports":[],"active_port":null,"formats":["pcm"]},` +
+	`{"index":1,"state":"RUNNING","name":"alsa_output.zone1","description":"PCM2902C Audio CODEC","driver":"module-alsa-card.c",` +
+	`"sample_specification":"s16le 2ch 48000Hz","channel_map":"front-left,front-right","owner_module":7,"mute":false,` +
+	`"volume":{"front-right":{"value":39322,"value_percent":"60%","db":"-13.31 dB"},"front-left":{"value":45875,"value_percent":"70%","db":"-9.29 dB"}},` +
+	`"balance":-0.142857,"base_volume":{"value":65536,"value_percent":"100%","db":"0.00 dB"},"monitor_source":"alsa_output.zone1.monitor",` +
+	`"latency":{"actual":1234.000000,"configured":2000.000000},"flags":["HARDWARE","DECIBEL_VOLUME","LATENCY"],"properties":{},` +
+	`"ports":[],"active_port":null,"formats":["pcm"]}]`
+
+const testSourcesJSON = `[{"index":0,"state":"SUSPENDED","name":"null.monitor","description":"Monitor of Null Output",` +
+	`"channel_map":"front-left,front-right","mute":false,` +
+	`"volume":{"front-left":{"value":65536,"value_percent":"100%","db":"0.00 dB"},"front-right":{"value":65536,"value_percent":"100%","db":"0.00 dB"}},` +
+	`"monitor_of_sink":"null","flags":["DECIBEL_VOLUME","LATENCY"]},` +
+	`{"index":3,"state":"RUNNING","name":"alsa_input.zone1","description":"PCM2902C Audio CODEC",` +
+	`"channel_map":"front-left,front-right","mute":true,` +
+	`"volume":{"front-left":{"value":29491,"value_percent":"45%","db":"-20.81 dB"},"front-right":{"value":29491,"value_percent":"45%","db":"-20.81 dB"}},` +
+	`"monitor_of_sink":"n/a","flags":["HARDWARE","DECIBEL_VOLUME","LATENCY"]}]`
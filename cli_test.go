@@ -2,8 +2,12 @@ package pulseaudio
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
@@ -17,12 +21,14 @@ func TestParseSinks(t *testing.T) {
 		assert.Equal(t, "null", sinks[0].Name)
 		assert.Equal(t, uint32(74), sinks[0].CVolume[0])
 		assert.Equal(t, true, sinks[0].Muted)
+		assert.Equal(t, SinkDecibelVolume|SinkLatency, sinks[0].Flags)
 		assert.Equal(t, "alsa_output.zone1", sinks[1].Name)
 		assert.Equal(t, uint32(70), sinks[1].CVolume[0])
 		assert.Equal(t, uint32(70), sinks[1].CVolume[1])
 		assert.Equal(t, uint32(70), sinks[1].CVolume[2])
 		assert.Equal(t, uint32(70), sinks[1].CVolume[3])
 		assert.Equal(t, false, sinks[1].Muted)
+		assert.Equal(t, "HARDWARE DECIBEL_VOLUME LATENCY", sinks[1].Flags.String())
 		assert.Equal(t, "test", sinks[2].Name)
 		assert.Equal(t, uint32(0), sinks[2].CVolume[0])
 	}
@@ -136,3 +142,143 @@ func (l logger) Infof(msg string, args ...interface{}) {
 func (l logger) Errorf(msg string, args ...interface{}) {
 	fmt.Printf("ERR: "+msg+"\n", args)
 }
+
+func TestCliClientVolume(t *testing.T) {
+	fakePactl(t, testSinks)
+	var vc VolumeController = NewCliClient("alsa_output.zone1", logger{})
+	ctx := context.Background()
+
+	vol, err := vc.Volume(ctx)
+	require.NoError(t, err)
+	assert.InDelta(t, 0.7, vol, 0.001)
+	muted, err := vc.Mute(ctx)
+	require.NoError(t, err)
+	assert.False(t, muted)
+
+	_, err = NewCliClient("missing", logger{}).Volume(ctx)
+	assert.ErrorIs(t, err, ErrSinkNotFound)
+}
+
+func TestParseSources(t *testing.T) {
+	sources, err := parseSources(bytes.NewBufferString(testSources), logger{})
+	require.NoError(t, err)
+	if assert.Len(t, sources, 3) {
+		assert.Equal(t, uint32(0), sources[0].Index)
+		assert.Equal(t, "null.monitor", sources[0].Name)
+		assert.Equal(t, "null", sources[0].MonitorSinkName)
+		assert.Equal(t, uint32(100), sources[0].CVolume[0])
+		assert.Equal(t, false, sources[0].Muted)
+		assert.Equal(t, uint32(3), sources[1].Index)
+		assert.Equal(t, "alsa_input.zone1", sources[1].Name)
+		assert.Equal(t, "", sources[1].MonitorSinkName)
+		assert.Equal(t, CVolume{45, 45}, sources[1].CVolume)
+		assert.Equal(t, true, sources[1].Muted)
+		assert.Equal(t, SourceHardware|SourceHwMuteCtrl|SourceHwVolumeCtrl|SourceDecibelVolume|SourceLatency, sources[1].Flags)
+		assert.Equal(t, uint32(5), sources[2].Index)
+		assert.Equal(t, "alsa_input.mic", sources[2].Name)
+		assert.Equal(t, CVolume{80}, sources[2].CVolume)
+	}
+}
+
+func TestCliClientSourceVolume(t *testing.T) {
+	fakePactl(t, testSources)
+	cli := NewCliClient("null", logger{})
+	ctx := context.Background()
+
+	vol, err := cli.SourceVolume(ctx, "alsa_input.zone1")
+	require.NoError(t, err)
+	assert.InDelta(t, 0.45, vol, 0.001)
+	muted, err := cli.SourceMute(ctx, "alsa_input.zone1")
+	require.NoError(t, err)
+	assert.True(t, muted)
+	assert.NoError(t, cli.SetSourceVolume(ctx, "alsa_input.zone1", 0.5))
+
+	_, err = cli.SourceVolume(ctx, "missing")
+	assert.ErrorIs(t, err, ErrSourceNotFound)
+}
+
+const testSources = `
+Source #0
+	State: SUSPENDED
+	Name: null.monitor
+	Description: Monitor of Null Output
+	Driver: module-null-sink.c
+	Sample Specification: s16le 2ch 44100Hz
+	Channel Map: front-left,front-right
+	Owner Module: 0
+	Mute: no
+	Volume: front-left: 65536 / 100% / 0.00 dB,   front-right: 65536 / 100% / 0.00 dB
+	        balance 0.00
+	Base Volume: 65536 / 100% / 0.00 dB
+	Monitor of Sink: null
+	Latency: 0 usec, configured 2000000 usec
+	Flags: DECIBEL_VOLUME LATENCY
+	Properties:
+		device.description = "Monitor of Null Output"
+		device.class = "monitor"
+	Formats:
+		pcm
+
+Source #3
+	State: RUNNING
+	Name: alsa_input.zone1
+	Description: PCM2902C Audio CODEC
+	Driver: module-alsa-card.c
+	Sample Specification: s16le 2ch 48000Hz
+	Channel Map: front-left,front-right
+	Owner Module: 7
+	Mute: yes
+	Volume: front-left: 29491 / 45% / -20.81 dB,   front-right: 29491 / 45% / -20.81 dB
+	        balance 0.00
+	Base Volume: 65536 / 100% / 0.00 dB
+	Monitor of Sink: n/a
+	Latency: 1234 usec, configured 2000 usec
+	Flags: HARDWARE HW_MUTE_CTRL HW_VOLUME_CTRL DECIBEL_VOLUME LATENCY
+	Properties:
+		device.description = "PCM2902C Audio CODEC"
+	Formats:
+		pcm
+
+Source #5
+	State: IDLE
+	Name: alsa_input.mic
+	Description: USB Microphone
+	Driver: module-alsa-card.c
+	Sample Specification: s16le 1ch 48000Hz
+	Channel Map: mono
+	Owner Module: 9
+	Mute: no
+	Volume: mono: 52429 / 80% / -5.81 dB
+	        balance 0.00
+	Base Volume: 65536 / 100% / 0.00 dB
+	Monitor of Sink: n/a
+	Latency: 0 usec, configured 2000 usec
+	Flags: HARDWARE HW_MUTE_CTRL HW_VOLUME_CTRL DECIBEL_VOLUME LATENCY
+	Properties:
+		device.description = "USB Microphone"
+	Formats:
+		pcm
+`
+
+func TestCliClientContext(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "pactl")
+	require.NoError(t, os.WriteFile(p, []byte("#!/bin/sh\nexec sleep 10\n"), 0755))
+	old := pactlPath
+	pactlPath = p
+	t.Cleanup(func() { pactlPath = old })
+
+	cli := NewCliClient("alsa_output.zone1", logger{})
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := cli.Volume(ctx)
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), 5*time.Second)
+	assert.False(t, cli.jsonProbed, "a cancelled probe must not be cached")
+}
+
+func TestPactlPercent(t *testing.T) {
+	assert.Equal(t, uint32(50), pactlPercent(0.5))
+	assert.Equal(t, uint32(150), pactlPercent(1.5))
+	assert.Equal(t, uint32(0), pactlPercent(-0.2))
+}
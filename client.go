@@ -19,6 +19,10 @@
 // Changing the default audio output.
 //
 // Notifications on config updates.
+//
+// Recording audio from sources.
+//
+// Playing audio on sinks.
 package pulseaudio
 
 import (
@@ -29,42 +33,122 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math/rand"
 	"net"
 	"os"
+	"os/exec"
 	"os/user"
 	"path"
+	"path/filepath"
 	"regexp"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
 const version = 32
 
-var defaultAddr = fmt.Sprintf("unix:///run/user/%d/pulse/native", os.Getuid())
+// defaultAddr returns the socket of the server of the current user, which lives in the
+// runtime directory: $XDG_RUNTIME_DIR if set, /run/user/<uid> otherwise.
+func defaultAddr() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return "unix://" + filepath.Join(dir, "pulse", "native")
+	}
+	return fmt.Sprintf("unix:///run/user/%d/pulse/native", os.Getuid())
+}
 
 type frame struct {
-	buff *bytes.Buffer
-	err  error
+	channel uint32
+	flags   uint32
+	blockID uint32 // high word of the offset, which names the block in shared memory release and revoke frames
+	fds     []int  // file descriptors sent along with the frame
+	buff    *bytes.Buffer
+	err     error
+}
+
+// framePool recycles the buffers of frames which are consumed by the frame handler itself
+// (events, stream data, errors). Replies are handed to the caller and are not recycled.
+var framePool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+// maxPooledFrameSize keeps buffers of exceptionally large frames out of the pool.
+const maxPooledFrameSize = 64 * 1024
+
+func getFrameBuffer() *bytes.Buffer {
+	b := framePool.Get().(*bytes.Buffer)
+	b.Reset()
+	return b
 }
 
+func putFrameBuffer(b *bytes.Buffer) {
+	if b == nil || b.Cap() > maxPooledFrameSize {
+		return
+	}
+	framePool.Put(b)
+}
+
+// controlChannel is the frame channel used for commands; any other channel carries stream data.
+const controlChannel = 0xffffffff
+
 type request struct {
+	id       uint64
 	data     []byte
 	response chan<- frame
+	stream   stream // registered under the channel in the reply, before the reply is delivered
+	memblock bool   // data is a complete stream data frame
+
+	// shmPool is registered with the server by this request, which carries its file descriptor
+	shmPool *shmPool
 }
 
 var (
 	ErrClientClosed        = errors.New("pulseaudio client was closed")
+	ErrConnectionLost      = errors.New("pulseaudio connection lost")
 	ErrClientDisabled      = errors.New("client disabled")
 	ErrCouldNotSendRequest = errors.New("could not send packet")
+	ErrMalformedFrame      = errors.New("malformed pulseaudio frame")
 )
 
 type Error struct {
-	Cmd  string
-	Code uint32
+	Cmd       string
+	Code      ErrorCode
+	RequestID uint64
 }
 
 func (err *Error) Error() string {
-	return fmt.Sprintf("pulse audio error: %s -> %s", err.Cmd, errorCodes[err.Code])
+	return fmt.Sprintf("pulse audio error: %s req #%d -> %s", err.Cmd, err.RequestID, err.Code.Error())
+}
+
+// Is makes errors.Is match the error code, e.g. errors.Is(err, ErrNoSuchEntity).
+func (err *Error) Is(target error) bool {
+	code, ok := target.(ErrorCode)
+	return ok && err.Code == code
+}
+
+type requestIDKey struct{}
+
+// ContextWithRequestID returns a context which makes the client use id for every request made
+// with it instead of an automatically assigned one. The id is reported in errors and logs, so
+// requests belonging to one operation can be correlated.
+func ContextWithRequestID(ctx context.Context, id uint64) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFromContext returns the request id stored with ContextWithRequestID.
+func RequestIDFromContext(ctx context.Context) (uint64, bool) {
+	id, ok := ctx.Value(requestIDKey{}).(uint64)
+	return id, ok
+}
+
+type noTimeoutKey struct{}
+
+// withoutRequestTimeout returns a context which exempts requests from Opts.RequestTimeout, for
+// requests which are answered once something has happened on the server rather than right away.
+func withoutRequestTimeout(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noTimeoutKey{}, true)
 }
 
 // ClientOpt defines a client modifier routine
@@ -76,17 +160,229 @@ func WithDialTimeout(timeout time.Duration) ClientOpt {
 	}
 }
 
+// WithDialer replaces dialing the configured server address with dial, e.g. to tunnel the
+// connection over SSH or to connect to an in-memory server in tests. The address is still used
+// in log messages. Every connection attempt calls dial once.
+func WithDialer(dial func(ctx context.Context) (net.Conn, error)) ClientOpt {
+	return func(client *Client) {
+		client.dial = dial
+	}
+}
+
+// WithReconnectInterval sets the delay between connection attempts made by Run.
+func WithReconnectInterval(interval time.Duration) ClientOpt {
+	return func(client *Client) {
+		client.reconnectInterval = interval
+	}
+}
+
+// WithReconnectBackoff makes the connection loop wait exponentially longer after every
+// failed attempt, starting at initial and capped at max. Each delay is randomly varied by
+// up to the jitter fraction (e.g. 0.2 for ±20%) so that many clients don't reconnect in step.
+// The delay is reset once a connection has been established.
+func WithReconnectBackoff(initial, max time.Duration, jitter float64) ClientOpt {
+	return func(client *Client) {
+		client.backoff = &backoff{initial: initial, max: max, jitter: jitter}
+	}
+}
+
+// WithNoReconnect makes Run and Connect give up instead of reconnecting: the connection loop
+// returns the error once the connection is lost or every configured server failed once, so that
+// an external supervisor can own the restart policy.
+func WithNoReconnect() ClientOpt {
+	return func(client *Client) {
+		client.noReconnect = true
+	}
+}
+
+// WithFrameRecorder writes every frame received from the server to w, in the wire format.
+// The recording can be read back with a ReplayReader.
+func WithFrameRecorder(w io.Writer) ClientOpt {
+	return func(client *Client) {
+		client.recorder = w
+	}
+}
+
+// WithClientProperties sets properties the client announces to the server, e.g. media.role.
+// They are merged over the defaults; an empty value removes a default property.
+func WithClientProperties(props map[string]string) ClientOpt {
+	return func(client *Client) {
+		if client.clientProps == nil {
+			client.clientProps = make(map[string]string)
+		}
+		for k, v := range props {
+			client.clientProps[k] = v
+		}
+	}
+}
+
+// WithApplicationName sets the application name shown in mixers instead of the binary name.
+func WithApplicationName(name string) ClientOpt {
+	return WithClientProperties(map[string]string{"application.name": name})
+}
+
+// WithAllowBoost controls whether volumes above 100% can be set. If boosting isn't allowed, higher
+// volumes passed to SetVolume, SetSinkVolume, AdjustVolume and RampVolume are capped at 100%.
+// Boosting is allowed by default.
+func WithAllowBoost(allow bool) ClientOpt {
+	return func(client *Client) {
+		client.allowBoost = allow
+	}
+}
+
+// WithServerInfoCacheTTL makes the client reuse the result of ServerInfo for up to ttl, saving a
+// round trip on every volume and mute operation which needs the default sink. The cache is dropped
+// whenever the server reports a change, so the client subscribes to server events. A ttl of 0,
+// the default, disables the cache.
+func WithServerInfoCacheTTL(ttl time.Duration) ClientOpt {
+	return func(client *Client) {
+		client.serverInfoTTL = ttl
+	}
+}
+
+// WithAutospawn makes the client start the PulseAudio daemon with "pulseaudio --start" if the
+// socket of a local server is missing on the first connection attempt, like libpulse does on
+// desktops. Remote servers are never spawned.
+func WithAutospawn(enabled bool) ClientOpt {
+	return func(client *Client) {
+		client.autospawn = enabled
+	}
+}
+
+// WithAutospawnBinary sets the daemon binary run by WithAutospawn (default "pulseaudio").
+func WithAutospawnBinary(path string) ClientOpt {
+	return func(client *Client) {
+		client.autospawnBinary = path
+	}
+}
+
+// WithMaxFrameSize sets the largest frame the client sends or accepts from the server (default
+// 16 MiB, like libpulse). Smaller limits save memory on embedded targets, larger ones allow
+// streaming with big memory blocks. The size is clamped to the range from 64 KiB to 256 MiB.
+func WithMaxFrameSize(size uint32) ClientOpt {
+	return func(client *Client) {
+		switch {
+		case size < frameSizeMinAllow:
+			size = frameSizeMinAllow
+		case size > frameSizeMaxLimit:
+			size = frameSizeMaxLimit
+		}
+		client.maxFrameSize = size
+	}
+}
+
+// WithKeepAlive makes the client ping the server every interval once connected and reconnect if
+// the server doesn't answer within the interval, so that connections which died silently (e.g.
+// dropped by a NAT gateway) are noticed before the next request runs into its timeout. It also
+// enables TCP keep-alive probes with the same interval.
+func WithKeepAlive(interval time.Duration) ClientOpt {
+	return func(client *Client) {
+		client.keepAlive = interval
+		client.dialer.KeepAlive = interval
+	}
+}
+
+// WithRetryOnTransientEntity makes the volume and mute operations on the default sink read the
+// server info again and retry after backoff if they fail with ErrNoSuchEntity, up to attempts
+// times in total. This smooths over the moment while the server switches devices, e.g. when
+// headphones are unplugged, in which the default sink may name a sink which no longer exists.
+func WithRetryOnTransientEntity(attempts int, backoff time.Duration) ClientOpt {
+	return func(client *Client) {
+		client.retryAttempts = attempts
+		client.retryBackoff = backoff
+	}
+}
+
+// WithMaxVolume caps the volume which can be reached with AdjustVolume (1 is 100%).
+func WithMaxVolume(max float32) ClientOpt {
+	return func(client *Client) {
+		client.maxVolume = max
+	}
+}
+
 // Client maintains a connection to the PulseAudio server.
+//
+// A Client is safe for concurrent use by multiple goroutines. Requests are queued to the
+// goroutine serving the connection, which sends them one at a time and matches the replies by
+// tag; the state of the connection itself is only touched by that goroutine.
 type Client struct {
+	requestID   uint64 // accessed atomically; kept first for 64-bit alignment
 	conn        net.Conn
 	err         error
 	clientIndex int
 	requests    chan request
-	updates     chan struct{}
 	dialer      net.Dialer
 	logger      Logger
 	cancel      context.CancelFunc
 	opts        Opts
+	addrs       []serverAddr
+	preferred   int
+	maxVolume   float32
+	allowBoost  bool
+	adjustMu    sync.Mutex
+	streamsMu   sync.Mutex
+	streams     map[uint32]stream
+
+	// reconnectInterval is the delay between connection attempts made by Run
+	reconnectInterval time.Duration
+	// backoff replaces the fixed reconnect interval if set
+	backoff *backoff
+	// noReconnect ends the connection loop on the first lost connection or failed round of attempts
+	noReconnect bool
+	// recorder receives a copy of every frame read from the connection
+	recorder io.Writer
+	// attempts receives the outcome of connection attempts if set
+	attempts chan error
+	// autoRescue moves streams of removed sinks to the default sink
+	autoRescue bool
+	// rescue tracks the sinks of sink inputs if autoRescue is set
+	rescue *rescuer
+	// serverVersion is the protocol version of the server, accessed atomically
+	serverVersion uint32
+	// clientProps override the default properties sent by setName
+	clientProps map[string]string
+	// envSink and envSource are the devices selected with PULSE_SINK and PULSE_SOURCE
+	envSink, envSource string
+	// dial replaces dialing the server address if set
+	dial func(ctx context.Context) (net.Conn, error)
+	// sharedMemory enables negotiating shared memory transfer on local connections
+	sharedMemory bool
+	// shm is the shared memory of the current connection, if negotiated
+	shm *shmState
+	// keepAlive is the interval between pings checking the connection, disabled if zero
+	keepAlive time.Duration
+	// maxFrameSize is the largest frame sent or received
+	maxFrameSize uint32
+	// x11Cookie enables reading the cookie from the X11 root window
+	x11Cookie bool
+	// autospawn starts the daemon with autospawnBinary if the local server isn't running
+	autospawn       bool
+	autospawnBinary string
+	// retryAttempts and retryBackoff make default sink operations retry on ErrNoSuchEntity
+	retryAttempts int
+	retryBackoff  time.Duration
+
+	// serverInfoTTL enables caching ServerInfo if positive
+	serverInfoTTL time.Duration
+	serverInfoMu  sync.Mutex
+	serverInfo    *Server
+	serverInfoAt  time.Time
+	// serverInfoGen counts invalidations, so that replies requested before one aren't cached
+	serverInfoGen uint64
+
+	// errMu guards err, the error reported by Err
+	errMu sync.Mutex
+
+	done      chan struct{} // closed by Close
+	closeOnce sync.Once
+	cancelMu  sync.Mutex
+
+	subscribersMu sync.Mutex
+	subscribers   []chan struct{}
+	closed        bool
+
+	// eventHandlers receive every subscription event in detail, guarded by subscribersMu
+	eventHandlers []*eventHandler
 }
 
 // Opts wraps all available config options
@@ -101,24 +397,89 @@ type Opts struct {
 
 var addrRegex = regexp.MustCompile(`^([a-z]+)://(.*)`)
 
+// serverAddr is a single dialable PulseAudio server address.
+type serverAddr struct {
+	protocol string
+	addr     string
+}
+
+func (a serverAddr) String() string {
+	return a.protocol + "://" + a.addr
+}
+
+// parseAddrs splits a whitespace separated list of server addresses (the same format
+// PULSE_SERVER uses) into dialable addresses. Entries without a protocol prefix are
+// treated as unix sockets.
+func parseAddrs(list string) []serverAddr {
+	var addrs []serverAddr
+	for _, field := range strings.Fields(list) {
+		matches := addrRegex.FindStringSubmatch(field)
+		if len(matches) != 3 {
+			// unix socket is the default
+			addrs = append(addrs, serverAddr{protocol: "unix", addr: field})
+			continue
+		}
+		addr := matches[2]
+		if matches[1] == "tcp" {
+			addr = normalizeTCPAddr(addr)
+		}
+		addrs = append(addrs, serverAddr{protocol: matches[1], addr: addr})
+	}
+	return addrs
+}
+
+// defaultTCPPort is the port of the native protocol module.
+const defaultTCPPort = "4713"
+
+// normalizeTCPAddr adds the default port to a host given without one and brackets IPv6
+// literals, so that e.g. "::1" becomes "[::1]:4713".
+func normalizeTCPAddr(addr string) string {
+	if host, port, err := net.SplitHostPort(addr); err == nil {
+		return net.JoinHostPort(host, port)
+	}
+	// no port: either a plain host, a bare IPv6 literal or a bracketed one
+	host := strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+	return net.JoinHostPort(host, defaultTCPPort)
+}
+
+const (
+	defaultMaxVolume         = 1.5
+	defaultReconnectInterval = 5 * time.Second
+	defaultAutospawnBinary   = "pulseaudio"
+)
+
 // NewClient establishes a connection to the PulseAudio server.
-func NewClient(opts Opts) *Client {
+func NewClient(opts Opts, clientOpts ...ClientOpt) *Client {
 	c := &Client{
-		requests: make(chan request, 16),
-		updates:  make(chan struct{}, 1),
-		opts:     opts,
+		requests:  make(chan request, 16),
+		done:      make(chan struct{}),
+		opts:      opts,
+		maxVolume: defaultMaxVolume,
+
+		allowBoost:        true,
+		maxFrameSize:      frameSizeMaxAllow,
+		autospawnBinary:   defaultAutospawnBinary,
+		reconnectInterval: defaultReconnectInterval,
 	}
 	if c.opts.Addr == "" {
-		c.opts.Addr = defaultAddr
+		c.opts.Addr = os.Getenv("PULSE_SERVER")
+	}
+	// like libpulse, target the devices named in the environment instead of the server defaults
+	c.envSink = os.Getenv("PULSE_SINK")
+	c.envSource = os.Getenv("PULSE_SOURCE")
+	if c.opts.Addr == "" {
+		c.opts.Addr = defaultAddr()
 	}
 
-	matches := addrRegex.FindStringSubmatch(c.opts.Addr)
-	if len(matches) != 3 {
-		// unix socket is the default
-		c.opts.Protocol = "unix"
-	} else {
-		c.opts.Protocol = matches[1]
-		c.opts.Addr = matches[2]
+	// the address may hold a list of servers which are tried in order
+	c.addrs = parseAddrs(c.opts.Addr)
+	if len(c.addrs) == 0 {
+		c.addrs = parseAddrs(defaultAddr())
+	}
+	c.opts.Protocol = c.addrs[0].protocol
+	c.opts.Addr = c.addrs[0].addr
+	if c.opts.Cookie == "" {
+		c.opts.Cookie = os.Getenv("PULSE_COOKIE")
 	}
 	if c.opts.Cookie == "" {
 		// try homedir
@@ -131,164 +492,523 @@ func NewClient(opts Opts) *Client {
 	if c.logger == nil {
 		c.logger = discardLogger{}
 	}
+	for _, opt := range clientOpts {
+		opt(c)
+	}
+	if c.autoRescue {
+		c.startRescuer()
+	}
 	return c
 }
 
+// Connect starts the connection loop in the background; wg is done once it has stopped.
+// It is a variant of Run for callers which manage their own WaitGroup.
 func (c *Client) Connect(ctx context.Context, interval time.Duration, wg *sync.WaitGroup) {
-	ctx, c.cancel = context.WithCancel(ctx)
+	ctx = c.withCancel(ctx)
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
+		_ = c.run(ctx, interval)
+	}()
+}
 
-		c.logger.Info("starting pulseaudio connection loop")
-		// start connecting whenever we are ready
-		var timer *time.Timer
-		for {
-			err := c.connect(ctx, c.logger, wg)
-			if err != nil {
-				c.logger.Errorf("pulseaudio connection error: %v", err)
+// Run connects to the server and keeps reconnecting until ctx is cancelled or the client is
+// closed. It blocks until all goroutines of the connection have stopped and returns the error
+// which ended the loop: ctx.Err() or ErrClientClosed, or the connection error with
+// WithNoReconnect.
+func (c *Client) Run(ctx context.Context) error {
+	ctx = c.withCancel(ctx)
+	return c.run(ctx, c.reconnectInterval)
+}
+
+// Dial connects to the server once and returns when the connection is established and
+// authenticated, for short-lived tools which don't need the reconnect loop of Run. Every
+// configured server is tried once, in order. The connection is served in the background until
+// Close is called; it is not re-established if it's lost, so later requests fail.
+// If ctx ends before the connection is up, the attempt is aborted and ctx.Err() returned.
+func (c *Client) Dial(ctx context.Context) error {
+	connCtx := c.withCancel(context.Background())
+	c.attempts = make(chan error, len(c.addrs))
+	stopped := make(chan struct{})
+	// abort stops the attempt without closing the client, so that Dial can be retried
+	abort := func() {
+		c.cancelMu.Lock()
+		c.cancel()
+		c.cancelMu.Unlock()
+		<-stopped
+	}
+	go func() {
+		defer close(stopped)
+		var wg sync.WaitGroup
+		defer wg.Wait()
+		for idx := range c.addrs {
+			established, err := c.connect(connCtx, idx, c.logger, &wg)
+			if established {
+				if err != nil {
+					c.logger.Errorf("pulseaudio connection error: %v", err)
+				}
+				select {
+				case <-c.done:
+					c.setErr(ErrClientClosed)
+				default:
+					if err == nil {
+						err = ErrConnectionLost
+					}
+					c.setErr(err)
+				}
+				return
+			}
+			c.setErr(err)
+			c.reportAttempt(err)
+		}
+	}()
+
+	var errs multiError
+	for range c.addrs {
+		select {
+		case err := <-c.attempts:
+			if err == nil {
+				return nil
 			}
-			c.logger.Infof("reconnecting pulseaudio connection loop in %s", interval)
-			if timer == nil {
-				timer = time.NewTimer(interval)
+			errs = append(errs, err)
+		case <-ctx.Done():
+			abort()
+			return ctx.Err()
+		}
+	}
+	abort()
+	return fmt.Errorf("PulseAudio error: could not connect: %w", errs)
+}
+
+// withCancel derives the context of the connection loop, which is cancelled by Close.
+func (c *Client) withCancel(ctx context.Context) context.Context {
+	c.cancelMu.Lock()
+	defer c.cancelMu.Unlock()
+	ctx, c.cancel = context.WithCancel(ctx)
+	select {
+	case <-c.done:
+		// closed before the loop was started
+		c.cancel()
+	default:
+	}
+	return ctx
+}
+
+func (c *Client) run(ctx context.Context, interval time.Duration) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	c.logger.Info("starting pulseaudio connection loop")
+	// start connecting whenever we are ready
+	var timer *time.Timer
+	idx := c.preferred
+	failures := 0
+	// the daemon is spawned at most once, before the first connection
+	spawned := !c.autospawn
+	for {
+		established, err := c.connect(ctx, idx, c.logger, &wg)
+		if err != nil {
+			c.logger.Errorf("pulseaudio connection error: %v", err)
+			c.setErr(err)
+		}
+		if !established && !spawned && c.addrs[idx].protocol == "unix" && serverMissing(err) {
+			spawned = true
+			if err := c.spawnDaemon(ctx); err != nil {
+				c.logger.Errorf("could not start pulseaudio: %v", err)
 			} else {
-				timer.Reset(interval)
+				// the daemon is ready once the start command returns
+				continue
+			}
+		}
+		if established {
+			spawned = true
+			// retry the address which worked last time first
+			idx = c.preferred
+			failures = 0
+		} else {
+			c.reportAttempt(err)
+			// fail over to the next configured server
+			idx = (idx + 1) % len(c.addrs)
+			failures++
+		}
+		if c.noReconnect && (established || failures == len(c.addrs)) && ctx.Err() == nil {
+			if err == nil {
+				err = ErrConnectionLost
 			}
+			c.logger.Info("stopping pulseaudio connection loop, reconnecting is disabled")
+			c.setErr(err)
+			return err
+		}
+		delay := interval
+		if c.backoff != nil {
+			delay = c.backoff.delay(failures)
+		}
+		c.logger.Infof("reconnecting pulseaudio connection loop in %s", delay)
+		if timer == nil {
+			timer = time.NewTimer(delay)
+		} else {
+			timer.Reset(delay)
+		}
+		select {
+		case <-ctx.Done():
+			c.logger.Info("stopping pulseaudio connection loop")
 			select {
-			case <-ctx.Done():
-				c.logger.Info("stopping pulseaudio connection loop")
-				return
-			case <-timer.C:
-				continue
+			case <-c.done:
+				c.setErr(ErrClientClosed)
+				return ErrClientClosed
+			default:
+				c.setErr(ctx.Err())
+				return ctx.Err()
 			}
+		case <-timer.C:
+			continue
 		}
-	}()
+	}
+}
+
+// Err returns nil while the client is connected or before it first tries to connect. Otherwise
+// it returns why it isn't: the error which ended the last connection or connection attempt,
+// ErrClientClosed after Close, or the context error once the connection loop was stopped by its
+// context. A client whose connection loop has stopped stays disconnected, so a supervisor can
+// use Err to decide whether to restart it.
+func (c *Client) Err() error {
+	c.errMu.Lock()
+	defer c.errMu.Unlock()
+	return c.err
+}
+
+func (c *Client) setErr(err error) {
+	c.errMu.Lock()
+	defer c.errMu.Unlock()
+	c.err = err
+}
+
+// reportAttempt passes the outcome of a connection attempt to the attempts channel, if any.
+// A nil error means the connection was established and authenticated.
+func (c *Client) reportAttempt(err error) {
+	if c.attempts == nil {
+		return
+	}
+	select {
+	case c.attempts <- err:
+	default:
+	}
+}
+
+// backoff computes capped exponential reconnect delays with jitter.
+type backoff struct {
+	initial, max time.Duration
+	jitter       float64
+}
+
+// delay returns the time to wait after the given number of consecutive failures.
+func (b *backoff) delay(failures int) time.Duration {
+	d := b.initial
+	for i := 1; i < failures && d < b.max; i++ {
+		d *= 2
+	}
+	if d > b.max {
+		d = b.max
+	}
+	if b.jitter > 0 {
+		d += time.Duration((rand.Float64()*2 - 1) * b.jitter * float64(d))
+	}
+	return d
+}
+
+// serverMissing reports whether dialing failed because no server listens on the socket.
+func serverMissing(err error) bool {
+	return errors.Is(err, syscall.ENOENT) || errors.Is(err, syscall.ECONNREFUSED)
 }
 
-func (c *Client) init(ctx context.Context) error {
-	err := c.auth(ctx, c.opts.Cookie)
+// spawnDaemon starts the PulseAudio daemon for WithAutospawn.
+func (c *Client) spawnDaemon(ctx context.Context) error {
+	c.logger.Infof("starting pulseaudio daemon with %s --start", c.autospawnBinary)
+	out, err := exec.CommandContext(ctx, c.autospawnBinary, "--start").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+func (c *Client) init(ctx context.Context, out chan<- request) error {
+	// changes made while disconnected were not reported
+	c.dropServerInfo()
+	err := c.auth(ctx, out, c.opts.Cookie)
 	if err != nil {
 		return fmt.Errorf("authentication failure: %w", err)
 	}
 
-	err = c.setName(ctx)
+	if c.shm != nil && c.shm.memfd {
+		// without a pool playback data is still copied through the socket
+		if err := c.registerShmPool(ctx, out); err != nil {
+			c.logger.Errorf("could not register shared memory pool: %v", err)
+		}
+	}
+
+	err = c.setName(ctx, out)
 	if err != nil {
 		return fmt.Errorf("could not send app identification data to server: %w", err)
 	}
+
+	// auto rescue, the server info cache and subscribers from earlier connections rely on server events
+	if c.autoRescue || c.serverInfoTTL > 0 || c.hasSubscribers() {
+		_, err = c.roundTrip(ctx, out, nil, commandSubscribe, uint32Tag, uint32(subscriptionMaskAll))
+		if err != nil {
+			return fmt.Errorf("could not subscribe to server events: %w", err)
+		}
+	}
 	return nil
 }
 
-func (c *Client) connect(ctx context.Context, logger Logger, wg *sync.WaitGroup) error {
-	logger.Infof("dialing pulseaudio server %s://%s", c.opts.Protocol, c.opts.Addr)
+// connect dials the server at addrs[idx] and serves requests until the connection fails.
+// The returned flag reports whether the connection was established and initialized.
+func (c *Client) connect(ctx context.Context, idx int, logger Logger, wg *sync.WaitGroup) (bool, error) {
+	addr := c.addrs[idx]
+	logger.Infof("dialing pulseaudio server %s", addr)
 	var err error
-	c.conn, err = c.dialer.DialContext(ctx, c.opts.Protocol, c.opts.Addr)
+	if c.dial != nil {
+		c.conn, err = c.dial(ctx)
+	} else {
+		c.conn, err = c.dialer.DialContext(ctx, addr.protocol, addr.addr)
+	}
 	if err != nil {
-		return fmt.Errorf("could not dial pulseaudio server %s: %w", c.opts.Addr, err)
+		return false, fmt.Errorf("could not dial pulseaudio server %s: %w", addr.addr, err)
 	}
-	defer func() { _ = c.conn.Close() }()
 
-	// buffer init requests for processing
-	initCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
-	err = c.init(initCtx)
-	cancel()
-	if err != nil {
-		return fmt.Errorf("error during init: %w", err)
+	c.shm = nil
+	if c.sharedMemory {
+		c.shm = newShmState(c.conn)
 	}
+
 	// start receive loop
 	recv := c.receive(ctx, wg)
-
-	pending := make(map[uint32]request)
-	// cleanup pending
 	defer func() {
-		for _, p := range pending {
-			p.response <- frame{
-				buff: nil,
-				err:  ErrClientClosed,
-			}
+		// unblock the receive loop so that it can exit
+		_ = c.conn.Close()
+		for range recv {
+		}
+		if c.shm != nil {
+			c.shm.close()
 		}
 	}()
+
+	pending := make(map[uint32]request)
+	// init requests go through a dedicated queue so that no queued client request
+	// reaches the server before the connection is authenticated
+	setup := make(chan request, 4)
+	initCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	handled := make(chan error, 1)
+	go func() {
+		err := c.handleFrames(recv, setup, pending, logger)
+		// abort init requests which will not be answered anymore
+		cancel()
+		handled <- err
+	}()
+	err = c.init(initCtx, setup)
+	close(setup)
+	if handlerErr := <-handled; err == nil && handlerErr != nil {
+		err = handlerErr
+	}
+	if err != nil {
+		failPending(pending, ErrConnectionLost)
+		return false, fmt.Errorf("error during init: %w", err)
+	}
+	c.preferred = idx
+	c.setErr(nil)
+	c.reportAttempt(nil)
+	c.notifyConnected()
+	if c.keepAlive > 0 {
+		stop := make(chan struct{})
+		defer close(stop)
+		wg.Add(1)
+		go c.keepConnAlive(ctx, c.conn, stop, wg)
+	}
+
 	err = c.handleFrames(recv, c.requests, pending, logger)
+	// cleanup pending; the handler returns without an error only if the client was closed
 	if err != nil {
-		return fmt.Errorf("frame handler error: %w", err)
+		failPending(pending, ErrConnectionLost)
+		c.failStreams(ErrConnectionLost)
+		if ctx.Err() != nil {
+			// the connection was shut down on purpose
+			return true, nil
+		}
+		return true, fmt.Errorf("frame handler error: %w", err)
 	}
-	return nil
+	failPending(pending, ErrClientClosed)
+	c.failStreams(ErrClientClosed)
+	return true, nil
 }
 
-const frameSizeMaxAllow = 1024 * 1024 * 16
+// keepConnAlive pings the server every keepAlive interval until stop is closed. If a ping isn't
+// answered in time the connection is closed, which makes the connection loop reconnect.
+func (c *Client) keepConnAlive(ctx context.Context, conn net.Conn, stop <-chan struct{}, wg *sync.WaitGroup) {
+	defer wg.Done()
+	ticker := time.NewTicker(c.keepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		pingCtx, cancel := context.WithTimeout(ctx, c.keepAlive)
+		err := c.Ping(pingCtx)
+		cancel()
+		var serverErr *Error
+		if err == nil || errors.As(err, &serverErr) {
+			// any answer shows that the connection is alive
+			continue
+		}
+		select {
+		case <-stop:
+			// the connection is already gone
+			return
+		default:
+		}
+		c.logger.Errorf("pulseaudio keep-alive failed, reconnecting: %v", err)
+		_ = conn.Close()
+		return
+	}
+}
+
+const (
+	// frameSizeMaxAllow is the default frame size limit
+	frameSizeMaxAllow = 1024 * 1024 * 16
+	// frameSizeMinAllow and frameSizeMaxLimit bound the limit set with WithMaxFrameSize
+	frameSizeMinAllow = 1024 * 64
+	frameSizeMaxLimit = 1024 * 1024 * 256
+)
 
 func (c *Client) receive(ctx context.Context, wg *sync.WaitGroup) <-chan frame {
 	// the channel will be closed when the goroutine exits
 	recv := make(chan frame)
-	wg.Add(1)
+	conn := c.conn
+	shm := c.shm
+	stopped := make(chan struct{})
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		// a blocked read only returns once the connection is closed, so close it as soon as
+		// ctx is cancelled; the read error then ends the loop and the frame handler
+		select {
+		case <-ctx.Done():
+			_ = conn.Close()
+		case <-stopped:
+		}
+	}()
 	go func() {
 		defer wg.Done()
 		defer close(recv)
+		defer close(stopped)
 		for {
 			if ctx.Err() != nil {
 				// context cancelled
 				return
 			}
-			var b bytes.Buffer
-			_, err := io.CopyN(&b, c.conn, 4)
+			b := getFrameBuffer()
+			var fds []int
+			var err error
+			if shm != nil {
+				// frames setting up shared memory carry file descriptors
+				fds, err = readHeaderFDs(shm.conn, b)
+			} else {
+				_, err = io.CopyN(b, conn, 4)
+			}
 			if err != nil {
 				recv <- frame{
-					buff: &b,
+					buff: b,
 					err:  fmt.Errorf("could not read header from connection: %w", err),
 				}
 				return
 			}
 			n := binary.BigEndian.Uint32(b.Bytes())
-			if n > frameSizeMaxAllow {
+			if n > c.maxFrameSize {
+				closeFDs(fds)
 				recv <- frame{
-					buff: &b,
-					err:  fmt.Errorf("response size %d is too long (only %d allowed)", n, frameSizeMaxAllow),
+					buff: b,
+					err:  fmt.Errorf("response size %d is too long (only %d allowed)", n, c.maxFrameSize),
 				}
-				_, _ = io.CopyN(io.Discard, c.conn, int64(n))
+				_, _ = io.CopyN(io.Discard, conn, int64(n))
 				return
 			}
-			// the rest of the header
-			b.Grow(int(n) + 20)
-			if _, err = io.CopyN(&b, c.conn, int64(n)+16); err != nil {
+			// the rest of the header; the extra room is for the final read which detects the end of the frame
+			b.Grow(int(n) + 16 + bytes.MinRead)
+			if _, err = io.CopyN(b, conn, int64(n)+16); err != nil {
+				closeFDs(fds)
 				recv <- frame{
-					buff: &b,
+					buff: b,
 					err:  fmt.Errorf("could not read data from connection: %w", err),
 				}
 				return
 			}
-			b.Next(20) // skip the header
+			c.record(b.Bytes())
+			header := b.Next(20)
 			recv <- frame{
-				buff: &b,
+				channel: binary.BigEndian.Uint32(header[4:]),
+				flags:   binary.BigEndian.Uint32(header[16:]),
+				blockID: binary.BigEndian.Uint32(header[8:]),
+				fds:     fds,
+				buff:    b,
 			}
 		}
 	}()
 	return recv
 }
 
+// writeRequest writes a request to the connection, through the shared memory of the connection
+// if negotiated.
+func (c *Client) writeRequest(p request) error {
+	if c.shm != nil {
+		return c.shm.write(p)
+	}
+	_, err := c.conn.Write(p.data)
+	return err
+}
+
 func (c *Client) handleFrames(in <-chan frame, out <-chan request, pending map[uint32]request, logger Logger) error {
 	tag := uint32(0)
 	for {
 		select {
+		case <-c.done:
+			logger.Info("client closed; aborting frame handler routine")
+			c.failQueued(out)
+			return nil
 		case p, ok := <-out: // Outgoing request
 			if !ok {
-				// Client was closed
+				// the queue was closed (the setup queue is closed after init)
 				logger.Info("outgoing frames channel closed; aborting frame handler routine")
 				return nil
 			}
-			// check if request has valid format
-			if len(p.data) < 26 {
-				p.response <- frame{err: fmt.Errorf("request too short; minimum is 26 bytes")}
-				continue
-			}
+			if !p.memblock {
+				// check if request has valid format
+				if len(p.data) < 26 {
+					p.response <- frame{err: fmt.Errorf("request too short; minimum is 26 bytes")}
+					continue
+				}
 
-			tag = nextAvailableTag(tag, pending)
+				tag = nextAvailableTag(tag, pending)
 
-			binary.BigEndian.PutUint32(p.data, uint32(len(p.data))-20)
-			binary.BigEndian.PutUint32(p.data[26:], tag) // fix tag
-			_, err := c.conn.Write(p.data)
+				binary.BigEndian.PutUint32(p.data, uint32(len(p.data))-20)
+				binary.BigEndian.PutUint32(p.data[26:], tag) // fix tag
+			}
+			err := c.writeRequest(p)
 			if err != nil {
-				p.response <- frame{err: fmt.Errorf("couldn't send request: %s", err)}
+				// the connection is unusable, so none of the pending requests will be answered
+				p.response <- frame{err: fmt.Errorf("%w: couldn't send request #%d: %v", ErrConnectionLost, p.id, err)}
+				failPending(pending, ErrConnectionLost)
 				return fmt.Errorf("could not write to connection: %w", err)
 			}
+			if p.memblock {
+				// stream data is not answered by the server
+				p.response <- frame{}
+				continue
+			}
+			debugf(logger, "sent %s req #%d with tag %d (%d bytes)", command(binary.BigEndian.Uint32(p.data[21:])), p.id, tag, len(p.data))
 			pending[tag] = p
 
 		case incoming, ok := <-in: // Incoming request
@@ -301,6 +1021,24 @@ func (c *Client) handleFrames(in <-chan frame, out <-chan request, pending map[u
 				// this is a circuit breaker
 				return fmt.Errorf("error reading incoming frame: %w", incoming.err)
 			}
+			if incoming.flags&frameFlagShmMask != 0 {
+				if err := c.handleShmFrame(incoming, logger); err != nil {
+					return err
+				}
+				continue
+			}
+			if incoming.channel != controlChannel {
+				// memblock with stream data
+				closeFDs(incoming.fds)
+				if c.shm != nil && c.shm.srbPending {
+					// the ring buffer of the declined srbchannel
+					c.shm.srbPending = false
+				} else {
+					c.streamData(incoming.channel, incoming.buff.Bytes())
+				}
+				putFrameBuffer(incoming.buff)
+				continue
+			}
 			var tag uint32
 			var rsp command
 			err := bread(incoming.buff, uint32Tag, &rsp, uint32Tag, &tag)
@@ -309,11 +1047,18 @@ func (c *Client) handleFrames(in <-chan frame, out <-chan request, pending map[u
 				// we will reset the connection
 				return fmt.Errorf("received invalid pulseaudio request: %w", err)
 			}
-			if rsp == commandSubscribeEvent && tag == 0xffffffff {
-				select {
-				case c.updates <- struct{}{}:
-				default:
-				}
+			if rsp == commandRegisterMemfdShmid || rsp == commandEnableSrbchannel {
+				// sent by the server on its own, the srbchannel offer with a tag of its choice
+				c.handleShmCommand(rsp, incoming, logger)
+				putFrameBuffer(incoming.buff)
+				continue
+			}
+			closeFDs(incoming.fds)
+			if tag == 0xffffffff {
+				// commands sent by the server on its own
+				debugf(logger, "received %s from the server (%d bytes)", rsp, incoming.buff.Len())
+				c.handleServerCommand(rsp, incoming.buff, logger)
+				putFrameBuffer(incoming.buff)
 				continue
 			}
 			p, ok := pending[tag]
@@ -321,27 +1066,44 @@ func (c *Client) handleFrames(in <-chan frame, out <-chan request, pending map[u
 				return fmt.Errorf("no pending requests for tag %d (%s)", tag, rsp)
 			}
 			delete(pending, tag)
+			debugf(logger, "received %s to %s req #%d with tag %d (%d bytes)",
+				rsp, command(binary.BigEndian.Uint32(p.data[21:])), p.id, tag, incoming.buff.Len())
 			switch rsp {
 			case commandError:
 				var code uint32
 				err = bread(incoming.buff, uint32Tag, &code)
+				cmd := command(binary.BigEndian.Uint32(p.data[21:]))
+				putFrameBuffer(incoming.buff)
 				if err != nil {
-					logger.Errorf("could not interpret error frame: %v", err)
+					// without a code the error would read as ErrCodeOK, which hides what went wrong
+					logger.Errorf("could not interpret error frame for %s req #%d: %v", cmd, p.id, err)
+					p.response <- frame{err: fmt.Errorf("%w: error reply to %s req #%d has no error code: %v", ErrMalformedFrame, cmd, p.id, err)}
+					continue
 				}
-				cmd := command(binary.BigEndian.Uint32(p.data[21:]))
-				incoming.err = &Error{Cmd: cmd.String(), Code: code}
-				p.response <- incoming
+				p.response <- frame{err: &Error{Cmd: cmd.String(), Code: ErrorCode(code), RequestID: p.id}}
 				continue
 			case commandReply:
+				if p.stream != nil && incoming.buff.Len() >= 5 {
+					// the reply starts with the channel assigned to the new stream
+					c.registerStream(binary.BigEndian.Uint32(incoming.buff.Bytes()[1:]), p.stream)
+				}
 				p.response <- incoming
 				continue
 			default:
-				p.response <- frame{err: fmt.Errorf("expected reply (2) or error (0) but got: %s", rsp)}
+				p.response <- frame{err: fmt.Errorf("expected reply (2) or error (0) to req #%d but got: %s", p.id, rsp)}
 			}
 		}
 	}
 }
 
+// failPending answers all pending requests with err and removes them from the map.
+func failPending(pending map[uint32]request, err error) {
+	for tag, p := range pending {
+		p.response <- frame{err: err}
+		delete(pending, tag)
+	}
+}
+
 func nextAvailableTag(tag uint32, pending map[uint32]request) uint32 {
 	// Find an unused tag
 	for {
@@ -360,6 +1122,12 @@ func (c *Client) request(ctx context.Context, cmd command, args ...interface{})
 	if c == nil {
 		return nil, ErrClientDisabled
 	}
+	return c.roundTrip(ctx, c.requests, nil, cmd, args...)
+}
+
+// roundTrip sends a request through the out queue and waits for the response.
+// If st is set, the stream is registered under the channel returned by the server.
+func (c *Client) roundTrip(ctx context.Context, out chan<- request, st stream, cmd command, args ...interface{}) (*bytes.Buffer, error) {
 	var b bytes.Buffer
 	args = append([]interface{}{uint32(0), // dummy length -- we'll overwrite at the end when we know our final length
 		uint32(0xffffffff),   // channel
@@ -372,19 +1140,26 @@ func (c *Client) request(ctx context.Context, cmd command, args ...interface{})
 	if err != nil {
 		return nil, err
 	}
-	if b.Len() > frameSizeMaxAllow {
-		return nil, fmt.Errorf("request size %d is too long (only %d allowed)", b.Len(), frameSizeMaxAllow)
+	if b.Len() > int(c.maxFrameSize) {
+		return nil, fmt.Errorf("request size %d is too long (only %d allowed)", b.Len(), c.maxFrameSize)
 	}
-	resp := make(chan frame)
+	// buffered so that a late reply never blocks the frame handler
+	resp := make(chan frame, 1)
 
-	if c.opts.RequestTimeout > 0 {
+	if noTimeout, _ := ctx.Value(noTimeoutKey{}).(bool); c.opts.RequestTimeout > 0 && !noTimeout {
 		var cancel context.CancelFunc
 		ctx, cancel = context.WithTimeout(ctx, c.opts.RequestTimeout)
 		defer cancel()
 	}
-	err = c.sendRequest(ctx, request{
+	id, ok := RequestIDFromContext(ctx)
+	if !ok {
+		id = atomic.AddUint64(&c.requestID, 1)
+	}
+	err = c.sendRequest(ctx, out, request{
+		id:       id,
 		data:     b.Bytes(),
 		response: resp,
+		stream:   st,
 	})
 	if err != nil {
 		return nil, err
@@ -395,24 +1170,56 @@ func (c *Client) request(ctx context.Context, cmd command, args ...interface{})
 		return response.buff, response.err
 	case <-ctx.Done():
 		return nil, ctx.Err()
+	case <-c.done:
+		return nil, ErrClientClosed
 	}
 }
 
-func (c *Client) sendRequest(ctx context.Context, req request) error {
+func (c *Client) sendRequest(ctx context.Context, out chan<- request, req request) error {
+	// don't queue anything once the client is closed, even if there is room in the queue
 	select {
-	case c.requests <- req:
+	case <-c.done:
+		return ErrClientClosed
+	default:
+	}
+	select {
+	case out <- req:
 		return nil
 	case <-ctx.Done():
 		return ctx.Err()
+	case <-c.done:
+		return ErrClientClosed
 	default:
 		return ErrCouldNotSendRequest
 	}
 }
 
-func (c *Client) auth(ctx context.Context, cookiePath string) error {
+// failQueued answers the requests which are still waiting in the queue with ErrClientClosed.
+func (c *Client) failQueued(out <-chan request) {
+	for {
+		select {
+		case p, ok := <-out:
+			if !ok {
+				return
+			}
+			p.response <- frame{err: ErrClientClosed}
+		default:
+			return
+		}
+	}
+}
+
+func (c *Client) auth(ctx context.Context, out chan<- request, cookiePath string) error {
 	const protocolVersionMask = 0x0000FFFF
 	cookie, err := ioutil.ReadFile(cookiePath)
-	if err != nil {
+	if err != nil && c.x11Cookie {
+		var x11Err error
+		cookie, x11Err = runX11Cookie(ctx)
+		if x11Err != nil {
+			return fmt.Errorf("%v; no X11 cookie either: %w", err, x11Err)
+		}
+		cookiePath = "X11 root window"
+	} else if err != nil {
 		return err
 	}
 	const cookieLength = 256
@@ -420,25 +1227,40 @@ func (c *Client) auth(ctx context.Context, cookiePath string) error {
 		return fmt.Errorf("pulseaudio client cookie has incorrect length %d: expected %d (path %#v)",
 			len(cookie), cookieLength, cookiePath)
 	}
-	b, err := c.request(ctx, commandAuth,
-		uint32Tag, uint32(version),
+	flags := uint32(0)
+	if c.shm != nil {
+		flags = protocolFlagShm | protocolFlagMemfd
+	}
+	b, err := c.roundTrip(ctx, out, nil, commandAuth,
+		uint32Tag, uint32(version)|flags,
 		arbitraryTag, uint32(len(cookie)), cookie)
 	if err != nil {
 		return err
 	}
 	var serverVersion uint32
-	err = bread(b, uint32Tag, &serverVersion)
+	err = decodeReply(commandAuth, b, uint32Tag, &serverVersion)
 	if err != nil {
 		return err
 	}
+	if c.shm != nil {
+		// the server sets the flags of the transfer methods it agrees to
+		c.shm.memfd = serverVersion&protocolFlagShm != 0 && serverVersion&protocolFlagMemfd != 0
+	}
 	serverVersion &= protocolVersionMask
 	if serverVersion < version {
 		return fmt.Errorf("pulseaudio server supports version %d but minimum required is %d", serverVersion, version)
 	}
+	atomic.StoreUint32(&c.serverVersion, serverVersion)
 	return nil
 }
 
-func (c *Client) setName(ctx context.Context) error {
+// ServerProtocolVersion returns the native protocol version of the server the client is connected
+// to. It is 0 until the first connection has been established.
+func (c *Client) ServerProtocolVersion() uint32 {
+	return atomic.LoadUint32(&c.serverVersion)
+}
+
+func (c *Client) setName(ctx context.Context, out chan<- request) error {
 	props := map[string]string{
 		"application.name":           path.Base(os.Args[0]),
 		"application.process.id":     fmt.Sprintf("%d", os.Getpid()),
@@ -452,12 +1274,15 @@ func (c *Client) setName(ctx context.Context) error {
 	if hostname, err := os.Hostname(); err == nil {
 		props["application.process.host"] = hostname
 	}
-	b, err := c.request(ctx, commandSetClientName, props)
+	for k, v := range c.clientProps {
+		props[k] = v
+	}
+	b, err := c.roundTrip(ctx, out, nil, commandSetClientName, props)
 	if err != nil {
 		return err
 	}
 	var clientIndex uint32
-	err = bread(b, uint32Tag, &clientIndex)
+	err = decodeReply(commandSetClientName, b, uint32Tag, &clientIndex)
 	if err != nil {
 		return err
 	}
@@ -465,11 +1290,17 @@ func (c *Client) setName(ctx context.Context) error {
 	return nil
 }
 
+// Close stops the connection loop. Requests in flight fail with ErrClientClosed.
+// It is safe to call Close more than once and from several goroutines.
 func (c *Client) Close() {
-	close(c.requests)
-	close(c.updates)
-	// stop main connection loop (this also disconnects current connection)
-	if c.cancel != nil {
-		c.cancel()
-	}
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.closeSubscribers()
+		// stop main connection loop (this also disconnects current connection)
+		c.cancelMu.Lock()
+		if c.cancel != nil {
+			c.cancel()
+		}
+		c.cancelMu.Unlock()
+	})
 }
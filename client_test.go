@@ -1,7 +1,638 @@
 package pulseaudio
 
-import "testing"
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
 
 func TestOpts(t *testing.T) {
 
 }
+
+func TestDefaultAddr(t *testing.T) {
+	t.Setenv("PULSE_SERVER", "")
+	t.Setenv("XDG_RUNTIME_DIR", "/tmp/xdg-runtime")
+	assert.Equal(t, "unix:///tmp/xdg-runtime/pulse/native", defaultAddr())
+	c := NewClient(Opts{})
+	assert.Equal(t, "unix", c.opts.Protocol)
+	assert.Equal(t, "/tmp/xdg-runtime/pulse/native", c.opts.Addr)
+
+	t.Setenv("XDG_RUNTIME_DIR", "")
+	assert.Equal(t, fmt.Sprintf("unix:///run/user/%d/pulse/native", os.Getuid()), defaultAddr())
+}
+
+func TestParseAddrs(t *testing.T) {
+	addrs := parseAddrs("unix:///run/pulse/native  tcp://10.0.0.1:4713 /tmp/pulse")
+	assert.Equal(t, []serverAddr{
+		{protocol: "unix", addr: "/run/pulse/native"},
+		{protocol: "tcp", addr: "10.0.0.1:4713"},
+		{protocol: "unix", addr: "/tmp/pulse"},
+	}, addrs)
+}
+
+func TestNormalizeTCPAddr(t *testing.T) {
+	for _, tc := range []struct {
+		addr, want string
+	}{
+		{"10.0.0.1:4713", "10.0.0.1:4713"},
+		{"10.0.0.1", "10.0.0.1:4713"},
+		{"10.0.0.1:1234", "10.0.0.1:1234"},
+		{"pulse.local", "pulse.local:4713"},
+		{"pulse.local:1234", "pulse.local:1234"},
+		{"[::1]:4713", "[::1]:4713"},
+		{"[::1]", "[::1]:4713"},
+		{"::1", "[::1]:4713"},
+		{"[fe80::1%eth0]:1234", "[fe80::1%eth0]:1234"},
+		{"2001:db8::2", "[2001:db8::2]:4713"},
+	} {
+		assert.Equal(t, tc.want, normalizeTCPAddr(tc.addr), tc.addr)
+	}
+
+	addrs := parseAddrs("tcp://[::1] tcp://2001:db8::2 tcp://10.0.0.1 unix:///tmp/pulse")
+	assert.Equal(t, []serverAddr{
+		{protocol: "tcp", addr: "[::1]:4713"},
+		{protocol: "tcp", addr: "[2001:db8::2]:4713"},
+		{protocol: "tcp", addr: "10.0.0.1:4713"},
+		{protocol: "unix", addr: "/tmp/pulse"},
+	}, addrs)
+}
+
+func TestConnectFailover(t *testing.T) {
+	// the first server refuses every connection
+	refusing, err := net.Listen("unix", filepath.Join(t.TempDir(), "refusing"))
+	require.NoError(t, err)
+	defer func() { _ = refusing.Close() }()
+	var refused int32
+	go func() {
+		for {
+			conn, err := refusing.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&refused, 1)
+			_ = conn.Close()
+		}
+	}()
+	srv := newFakeServer(t)
+
+	c := NewClient(Opts{
+		Addr:           "unix://" + refusing.Addr().String() + " " + srv.uri(),
+		Cookie:         fakeCookie(t),
+		RequestTimeout: time.Second,
+	})
+	var wg sync.WaitGroup
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c.Connect(ctx, 10*time.Millisecond, &wg)
+
+	require.Eventually(t, func() bool { return srv.connections() == 1 }, time.Second, time.Millisecond)
+	s, err := c.ServerInfo(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "fake", s.DefaultSink)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&refused))
+
+	// after losing the connection the working server is tried first
+	srv.drop()
+	require.Eventually(t, func() bool { return srv.connections() == 2 }, time.Second, time.Millisecond)
+	_, err = c.ServerInfo(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&refused))
+
+	c.Close()
+	wg.Wait()
+}
+
+func TestRequestIDs(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.handle(commandGetServerInfo, func(*bytes.Buffer) ([]interface{}, uint32) {
+		return nil, 1 // access denied
+	})
+	c := newFakeClient(t, srv)
+	ctx := context.Background()
+
+	const calls = 8
+	ids := make(chan uint64, calls)
+	var wg sync.WaitGroup
+	for i := 0; i < calls; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := c.ServerInfo(ctx)
+			var paErr *Error
+			if assert.True(t, errors.As(err, &paErr), "unexpected error: %v", err) {
+				ids <- paErr.RequestID
+			}
+		}()
+	}
+	wg.Wait()
+	close(ids)
+	seen := make(map[uint64]bool)
+	for id := range ids {
+		assert.False(t, seen[id], "duplicate request id %d", id)
+		seen[id] = true
+	}
+	assert.Len(t, seen, calls)
+
+	_, err := c.ServerInfo(ContextWithRequestID(ctx, 4412))
+	assert.EqualError(t, err, "pulse audio error: commandGetServerInfo req #4412 -> Access denied")
+}
+
+// failingConn accepts a limited number of writes and fails afterwards.
+type failingConn struct {
+	net.Conn
+	writes int
+}
+
+func (f *failingConn) Write(b []byte) (int, error) {
+	if f.writes == 0 {
+		return 0, syscall.EPIPE
+	}
+	f.writes--
+	return len(b), nil
+}
+
+func TestWriteFailureFailsPending(t *testing.T) {
+	c := NewClient(Opts{})
+	c.conn = &failingConn{writes: 2}
+	in := make(chan frame)
+	out := make(chan request, 3)
+	responses := make([]chan frame, 3)
+	for i := range responses {
+		responses[i] = make(chan frame, 1)
+		var b bytes.Buffer
+		require.NoError(t, bwrite(&b, uint32(0), uint32(0xffffffff), uint32(0), uint32(0), uint32(0),
+			uint32Tag, uint32(commandGetServerInfo), uint32Tag, uint32(0)))
+		out <- request{id: uint64(i), data: b.Bytes(), response: responses[i]}
+	}
+	pending := make(map[uint32]request)
+
+	err := c.handleFrames(in, out, pending, discardLogger{})
+	assert.True(t, errors.Is(err, syscall.EPIPE), "unexpected error: %v", err)
+	assert.Empty(t, pending)
+	for i, resp := range responses {
+		select {
+		case f := <-resp:
+			assert.True(t, errors.Is(f.err, ErrConnectionLost), "request %d: unexpected error: %v", i, f.err)
+		default:
+			t.Errorf("request %d was not answered", i)
+		}
+	}
+}
+
+func TestConcurrentClose(t *testing.T) {
+	srv := newFakeServer(t)
+	block := make(chan struct{})
+	srv.handle(commandGetServerInfo, func(*bytes.Buffer) ([]interface{}, uint32) {
+		// never answer while the client is closing
+		<-block
+		return nil, 1
+	})
+	defer close(block)
+	c := NewClient(Opts{
+		Addr:           srv.uri(),
+		Cookie:         fakeCookie(t),
+		RequestTimeout: 5 * time.Second,
+	})
+	var wg sync.WaitGroup
+	c.Connect(context.Background(), 10*time.Millisecond, &wg)
+	ctx := context.Background()
+	require.Eventually(t, func() bool {
+		_, err := c.Sinks(ctx)
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+
+	inFlight := make(chan error, 1)
+	go func() {
+		_, err := c.ServerInfo(ctx)
+		inFlight <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	var closers sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		closers.Add(1)
+		go func() {
+			defer closers.Done()
+			c.Close()
+		}()
+	}
+	closers.Wait()
+	c.Close()
+	wg.Wait()
+
+	select {
+	case err := <-inFlight:
+		assert.True(t, errors.Is(err, ErrClientClosed), "unexpected error: %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("in-flight request was not answered")
+	}
+	_, err := c.ServerInfo(ctx)
+	assert.True(t, errors.Is(err, ErrClientClosed), "unexpected error: %v", err)
+}
+
+func TestRun(t *testing.T) {
+	srv := newFakeServer(t)
+	c := NewClient(Opts{
+		Addr:           srv.uri(),
+		Cookie:         fakeCookie(t),
+		RequestTimeout: time.Second,
+	}, WithReconnectInterval(10*time.Millisecond))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- c.Run(ctx) }()
+
+	require.Eventually(t, func() bool {
+		_, err := c.ServerInfo(ctx)
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+	srv.drop()
+	require.Eventually(t, func() bool { return srv.connections() == 2 }, time.Second, time.Millisecond)
+
+	cancel()
+	select {
+	case err := <-done:
+		assert.True(t, errors.Is(err, context.Canceled), "unexpected error: %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return")
+	}
+
+	closed := NewClient(Opts{Addr: srv.uri(), Cookie: fakeCookie(t)})
+	go func() { done <- closed.Run(context.Background()) }()
+	require.Eventually(t, func() bool { return srv.connections() == 3 }, time.Second, time.Millisecond)
+	closed.Close()
+	select {
+	case err := <-done:
+		assert.True(t, errors.Is(err, ErrClientClosed), "unexpected error: %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return")
+	}
+}
+
+func TestBackoffDelay(t *testing.T) {
+	b := &backoff{initial: 100 * time.Millisecond, max: time.Second}
+	assert.Equal(t, 100*time.Millisecond, b.delay(0))
+	assert.Equal(t, 100*time.Millisecond, b.delay(1))
+	assert.Equal(t, 200*time.Millisecond, b.delay(2))
+	assert.Equal(t, 800*time.Millisecond, b.delay(4))
+	assert.Equal(t, time.Second, b.delay(5))
+	assert.Equal(t, time.Second, b.delay(100))
+
+	b.jitter = 0.5
+	for i := 0; i < 100; i++ {
+		d := b.delay(3)
+		assert.GreaterOrEqual(t, d, 200*time.Millisecond)
+		assert.LessOrEqual(t, d, 600*time.Millisecond)
+	}
+}
+
+func TestReconnectBackoff(t *testing.T) {
+	ln, err := net.Listen("unix", filepath.Join(t.TempDir(), "refusing"))
+	require.NoError(t, err)
+	defer func() { _ = ln.Close() }()
+	attempts := make(chan time.Time, 16)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			attempts <- time.Now()
+			_ = conn.Close()
+		}
+	}()
+
+	c := NewClient(Opts{Addr: "unix://" + ln.Addr().String(), Cookie: fakeCookie(t)},
+		WithReconnectBackoff(10*time.Millisecond, 80*time.Millisecond, 0))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- c.Run(ctx) }()
+
+	var times []time.Time
+	for len(times) < 6 {
+		select {
+		case at := <-attempts:
+			times = append(times, at)
+		case <-time.After(time.Second):
+			t.Fatal("client stopped reconnecting")
+		}
+	}
+	cancel()
+	<-done
+	// the gaps double until they reach the cap: 10, 20, 40, 80, 80ms
+	for i, min := range []time.Duration{10, 20, 40, 80, 80} {
+		assert.GreaterOrEqual(t, times[i+1].Sub(times[i]), min*time.Millisecond, "attempt %d", i+1)
+	}
+	assert.Less(t, times[5].Sub(times[4]), 160*time.Millisecond)
+}
+
+func TestClientProperties(t *testing.T) {
+	srv := newFakeServer(t)
+	announced := make(chan map[string]string, 1)
+	srv.handle(commandSetClientName, func(req *bytes.Buffer) ([]interface{}, uint32) {
+		var props map[string]string
+		if err := bread(req, &props); err != nil {
+			return nil, 3 // invalid argument
+		}
+		announced <- props
+		return []interface{}{uint32Tag, uint32(1)}, 0
+	})
+	newFakeClient(t, srv,
+		WithApplicationName("Spotify"),
+		WithClientProperties(map[string]string{"media.role": "music", "window.x11.display": ""}))
+
+	props := <-announced
+	assert.Equal(t, "Spotify", props["application.name"])
+	assert.Equal(t, "music", props["media.role"])
+	assert.NotContains(t, props, "window.x11.display")
+	assert.NotEmpty(t, props["application.process.id"])
+}
+
+// BenchmarkReceive measures reading and dispatching subscription events.
+func BenchmarkReceive(b *testing.B) {
+	var event bytes.Buffer
+	if err := writeFakeFrame(&event, commandSubscribeEvent, 0xffffffff, uint32Tag, uint32(0x0010), uint32Tag, uint32(0)); err != nil {
+		b.Fatal(err)
+	}
+	server, client := net.Pipe()
+	c := NewClient(Opts{})
+	c.conn = client
+	go func() {
+		for i := 0; i < b.N; i++ {
+			if _, err := server.Write(event.Bytes()); err != nil {
+				return
+			}
+		}
+		_ = server.Close()
+	}()
+	var wg sync.WaitGroup
+	b.ReportAllocs()
+	b.ResetTimer()
+	recv := c.receive(context.Background(), &wg)
+	_ = c.handleFrames(recv, make(chan request), make(map[uint32]request), discardLogger{})
+	wg.Wait()
+}
+
+func TestAutospawn(t *testing.T) {
+	srv := newFakeServer(t)
+	// hide the socket until the daemon is "started"
+	hidden := srv.addr + ".hidden"
+	require.NoError(t, os.Rename(srv.addr, hidden))
+	dir := t.TempDir()
+	marker := filepath.Join(dir, "started")
+	daemon := filepath.Join(dir, "pulseaudio")
+	script := "#!/bin/sh\n[ \"$1\" = --start ] || exit 1\ntouch " + marker + "\nmv " + hidden + " " + srv.addr + "\n"
+	require.NoError(t, os.WriteFile(daemon, []byte(script), 0755))
+
+	c := newFakeClient(t, srv, WithAutospawn(true), WithAutospawnBinary(daemon))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, c.Ping(ctx))
+	assert.FileExists(t, marker)
+}
+
+func TestAutospawnDisabled(t *testing.T) {
+	srv := newFakeServer(t)
+	require.NoError(t, os.Remove(srv.addr))
+	dir := t.TempDir()
+	marker := filepath.Join(dir, "started")
+	daemon := filepath.Join(dir, "pulseaudio")
+	require.NoError(t, os.WriteFile(daemon, []byte("#!/bin/sh\ntouch "+marker+"\n"), 0755))
+
+	c := newFakeClient(t, srv, WithAutospawnBinary(daemon))
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.Error(t, c.Ping(ctx))
+	assert.NoFileExists(t, marker)
+}
+
+func TestMaxFrameSize(t *testing.T) {
+	assert.Equal(t, uint32(frameSizeMaxAllow), NewClient(Opts{}).maxFrameSize)
+	assert.Equal(t, uint32(frameSizeMinAllow), NewClient(Opts{}, WithMaxFrameSize(1)).maxFrameSize)
+	assert.Equal(t, uint32(frameSizeMaxLimit), NewClient(Opts{}, WithMaxFrameSize(math.MaxUint32)).maxFrameSize)
+
+	srv := newFakeServer(t)
+	c := newFakeClient(t, srv, WithMaxFrameSize(frameSizeMinAllow))
+	ctx := context.Background()
+	err := c.SetProperty(ctx, "media.name", strings.Repeat("x", frameSizeMinAllow))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "too long")
+	require.NoError(t, c.Ping(ctx), "a rejected request must not break the connection")
+}
+
+func TestReceiveCancel(t *testing.T) {
+	// a server which accepts connections but never sends anything
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = l.Close() })
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+
+	c := NewClient(Opts{Addr: "tcp://" + l.Addr().String(), Cookie: fakeCookie(t)})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- c.Run(ctx) }()
+	select {
+	case conn := <-accepted:
+		t.Cleanup(func() { _ = conn.Close() })
+	case <-time.After(time.Second):
+		t.Fatal("client did not connect")
+	}
+
+	// Run waits for the receive loop, which is blocked reading from the silent server
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-done:
+		assert.True(t, errors.Is(err, context.Canceled), "unexpected error: %v", err)
+	case <-time.After(200 * time.Millisecond):
+		t.Fatal("receive loop did not stop on cancel")
+	}
+}
+
+func TestKeepAlive(t *testing.T) {
+	srv := newFakeServer(t)
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+	var pings int32
+	srv.handle(commandStat, func(*bytes.Buffer) ([]interface{}, uint32) {
+		if atomic.AddInt32(&pings, 1) == 2 {
+			// the second ping is never answered, like on a connection which died silently
+			<-release
+		}
+		return []interface{}{uint32Tag, uint32(0), uint32Tag, uint32(0), uint32Tag, uint32(0), uint32Tag, uint32(0), uint32Tag, uint32(0)}, 0
+	})
+	c := newFakeClient(t, srv, WithKeepAlive(20*time.Millisecond))
+
+	_, err := c.ServerInfo(context.Background())
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return srv.connections() == 2 }, time.Second, time.Millisecond)
+	require.Eventually(t, func() bool {
+		_, err := c.ServerInfo(context.Background())
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, 2, srv.connections(), "an answered ping must not cause a reconnect")
+}
+
+func TestDial(t *testing.T) {
+	srv := newFakeServer(t)
+	c := NewClient(Opts{Addr: srv.uri(), Cookie: fakeCookie(t), RequestTimeout: time.Second})
+	defer c.Close()
+	require.NoError(t, c.Dial(context.Background()))
+	_, err := c.ServerInfo(context.Background())
+	require.NoError(t, err)
+
+	// the connection isn't re-established
+	srv.drop()
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 1, srv.connections())
+	assert.Error(t, c.Err())
+}
+
+func TestDialFailure(t *testing.T) {
+	srv := newFakeServer(t)
+	missing := "unix://" + filepath.Join(t.TempDir(), "missing")
+	c := NewClient(Opts{Addr: missing + " " + missing, Cookie: fakeCookie(t)})
+	defer c.Close()
+	err := c.Dial(context.Background())
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, syscall.ENOENT), "unexpected error: %v", err)
+
+	// the next server is tried if the first one fails
+	c = NewClient(Opts{Addr: missing + " " + srv.uri(), Cookie: fakeCookie(t)})
+	defer c.Close()
+	require.NoError(t, c.Dial(context.Background()))
+
+	// a silent server never completes init
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() { _ = l.Close() }()
+	c = NewClient(Opts{Addr: "tcp://" + l.Addr().String(), Cookie: fakeCookie(t)})
+	defer c.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.True(t, errors.Is(c.Dial(ctx), context.DeadlineExceeded))
+}
+
+func TestErr(t *testing.T) {
+	srv := newFakeServer(t)
+	c := NewClient(Opts{Addr: srv.uri(), Cookie: fakeCookie(t), RequestTimeout: time.Second},
+		WithReconnectInterval(10*time.Millisecond))
+	done := make(chan error, 1)
+	go func() { done <- c.Run(context.Background()) }()
+
+	_, err := c.ServerInfo(context.Background())
+	require.NoError(t, err)
+	assert.NoError(t, c.Err())
+
+	srv.close()
+	require.Eventually(t, func() bool { return c.Err() != nil }, time.Second, time.Millisecond)
+
+	c.Close()
+	<-done
+	assert.Equal(t, ErrClientClosed, c.Err())
+
+	dialed := NewClient(Opts{Addr: newFakeServer(t).uri(), Cookie: fakeCookie(t)})
+	require.NoError(t, dialed.Dial(context.Background()))
+	assert.NoError(t, dialed.Err())
+	dialed.Close()
+	require.Eventually(t, func() bool { return dialed.Err() == ErrClientClosed }, time.Second, time.Millisecond)
+}
+
+func TestNoReconnect(t *testing.T) {
+	srv := newFakeServer(t)
+	c := NewClient(Opts{Addr: srv.uri(), Cookie: fakeCookie(t), RequestTimeout: time.Second},
+		WithReconnectInterval(10*time.Millisecond), WithNoReconnect())
+	defer c.Close()
+	done := make(chan error, 1)
+	go func() { done <- c.Run(context.Background()) }()
+
+	require.NoError(t, c.Ping(context.Background()))
+	srv.drop()
+	select {
+	case err := <-done:
+		assert.Error(t, err)
+		assert.Equal(t, err, c.Err())
+	case <-time.After(time.Second):
+		t.Fatal("Run kept reconnecting")
+	}
+
+	// every configured server is tried once
+	missing := "unix://" + filepath.Join(t.TempDir(), "missing")
+	c = NewClient(Opts{Addr: missing + " " + missing, Cookie: fakeCookie(t)},
+		WithReconnectInterval(10*time.Millisecond), WithNoReconnect())
+	defer c.Close()
+	attempts := make(chan error, 4)
+	c.attempts = attempts
+	err := c.Run(context.Background())
+	assert.True(t, errors.Is(err, syscall.ENOENT), "unexpected error: %v", err)
+	assert.Len(t, attempts, 2)
+}
+
+func TestWithDialer(t *testing.T) {
+	srv := newFakeServer(t)
+	var dials int32
+	c := NewClient(Opts{Addr: "tcp://pulse.invalid", Cookie: fakeCookie(t), RequestTimeout: time.Second},
+		WithDialer(func(ctx context.Context) (net.Conn, error) {
+			atomic.AddInt32(&dials, 1)
+			client, server := net.Pipe()
+			go srv.serveConn(server)
+			return client, nil
+		}))
+	defer c.Close()
+	require.NoError(t, c.Dial(context.Background()))
+	s, err := c.ServerInfo(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "fake", s.DefaultSink)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&dials))
+	assert.Equal(t, 0, srv.connections(), "the server socket must not be dialed")
+}
+
+func TestConcurrentRequests(t *testing.T) {
+	srv := newFakeServer(t)
+	c := newFakeClient(t, srv)
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				switch (i + j) % 3 {
+				case 0:
+					_, err := c.Volume(ctx)
+					assert.NoError(t, err)
+				case 1:
+					assert.NoError(t, c.SetVolume(ctx, float32(i)/16))
+				case 2:
+					sinks, err := c.Sinks(ctx)
+					if assert.NoError(t, err) {
+						assert.Len(t, sinks, 1)
+					}
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.NoError(t, c.Err())
+}
@@ -1,5 +1,58 @@
 package pulseaudio
 
+import "fmt"
+
+// ErrorCode is an error code sent by the PulseAudio server. It implements error, so a code can
+// be matched with errors.Is, e.g. errors.Is(err, ErrCodeNoSuchEntity).
+type ErrorCode uint32
+
+const (
+	ErrCodeOK                   ErrorCode = 0
+	ErrCodeAccess               ErrorCode = 1
+	ErrCodeCommand              ErrorCode = 2
+	ErrCodeInvalid              ErrorCode = 3
+	ErrCodeEntityExists         ErrorCode = 4
+	ErrCodeNoSuchEntity         ErrorCode = 5
+	ErrCodeConnectionRefused    ErrorCode = 6
+	ErrCodeProtocol             ErrorCode = 7
+	ErrCodeTimeout              ErrorCode = 8
+	ErrCodeAuthKey              ErrorCode = 9
+	ErrCodeInternal             ErrorCode = 10
+	ErrCodeConnectionTerminated ErrorCode = 11
+	ErrCodeKilled               ErrorCode = 12
+	ErrCodeInvalidServer        ErrorCode = 13
+	ErrCodeModInitFailed        ErrorCode = 14
+	ErrCodeBadState             ErrorCode = 15
+	ErrCodeNoData               ErrorCode = 16
+	ErrCodeVersion              ErrorCode = 17
+	ErrCodeTooLarge             ErrorCode = 18
+	ErrCodeNotSupported         ErrorCode = 19
+	ErrCodeUnknown              ErrorCode = 20
+	ErrCodeNoExtension          ErrorCode = 21
+	ErrCodeObsolete             ErrorCode = 22
+	ErrCodeNotImplemented       ErrorCode = 23
+	ErrCodeForked               ErrorCode = 24
+	ErrCodeIO                   ErrorCode = 25
+	ErrCodeBusy                 ErrorCode = 26
+)
+
+// Sentinels for the server errors callers most commonly need to tell apart.
+var (
+	ErrAccessDenied    error = ErrCodeAccess
+	ErrInvalidArgument error = ErrCodeInvalid
+	ErrEntityExists    error = ErrCodeEntityExists
+	ErrNoSuchEntity    error = ErrCodeNoSuchEntity
+	ErrNotSupported    error = ErrCodeNotSupported
+	ErrBusy            error = ErrCodeBusy
+)
+
+func (c ErrorCode) Error() string {
+	if int(c) < len(errorCodes) {
+		return errorCodes[c]
+	}
+	return fmt.Sprintf("Unknown error code %d", uint32(c))
+}
+
 var errorCodes = []string{
 	"OK",
 	"Access denied",
@@ -0,0 +1,61 @@
+package pulseaudio
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestErrorCodes(t *testing.T) {
+	assert.Len(t, errorCodes, int(ErrCodeBusy)+1)
+	assert.Equal(t, "No such entity", ErrCodeNoSuchEntity.Error())
+	assert.Equal(t, "Sink or resource busy", ErrCodeBusy.Error())
+	assert.Equal(t, "Unknown error code 99", ErrorCode(99).Error())
+
+	err := fmt.Errorf("could not set volume: %w", &Error{Cmd: "commandSetSinkVolume", Code: ErrCodeNoSuchEntity})
+	assert.True(t, errors.Is(err, ErrNoSuchEntity))
+	assert.True(t, errors.Is(err, ErrCodeNoSuchEntity))
+	assert.False(t, errors.Is(err, ErrAccessDenied))
+	assert.False(t, errors.Is(err, ErrClientClosed))
+	assert.EqualError(t, &Error{Cmd: "commandAuth", Code: 99, RequestID: 1}, "pulse audio error: commandAuth req #1 -> Unknown error code 99")
+}
+
+func TestServerErrorCodes(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.handle(commandGetServerInfo, func(*bytes.Buffer) ([]interface{}, uint32) {
+		return nil, uint32(ErrCodeAccess)
+	})
+	c := newFakeClient(t, srv)
+	ctx := context.Background()
+
+	_, err := c.ServerInfo(ctx)
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrAccessDenied), "unexpected error: %v", err)
+
+	err = c.SetSinkVolume(ctx, "missing", 1)
+	assert.True(t, errors.Is(err, ErrNoSuchEntity), "unexpected error: %v", err)
+	assert.False(t, errors.Is(err, ErrAccessDenied))
+}
+
+func TestTruncatedErrorFrame(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.handle(commandGetServerInfo, func(*bytes.Buffer) ([]interface{}, uint32) {
+		return nil, fakeTruncatedError
+	})
+	c := newFakeClient(t, srv)
+	ctx := context.Background()
+
+	_, err := c.ServerInfo(ctx)
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrMalformedFrame), "unexpected error: %v", err)
+	var pErr *Error
+	assert.False(t, errors.As(err, &pErr), "a missing code must not be reported as a server error")
+
+	// the connection is still usable
+	require.NoError(t, c.Ping(ctx))
+}
@@ -0,0 +1,72 @@
+package pulseaudio
+
+import "fmt"
+
+// Facility is the kind of object a subscription event is about.
+type Facility uint32
+
+// Facilities of subscription events, matching PA_SUBSCRIPTION_EVENT_SINK and friends.
+const (
+	FacilitySink         Facility = 0x0000
+	FacilitySource       Facility = 0x0001
+	FacilitySinkInput    Facility = 0x0002
+	FacilitySourceOutput Facility = 0x0003
+	FacilityModule       Facility = 0x0004
+	FacilityClient       Facility = 0x0005
+	FacilitySampleCache  Facility = 0x0006
+	FacilityServer       Facility = 0x0007
+	FacilityCard         Facility = 0x0009
+)
+
+var facilityNames = map[Facility]string{
+	FacilitySink:         "sink",
+	FacilitySource:       "source",
+	FacilitySinkInput:    "sink-input",
+	FacilitySourceOutput: "source-output",
+	FacilityModule:       "module",
+	FacilityClient:       "client",
+	FacilitySampleCache:  "sample-cache",
+	FacilityServer:       "server",
+	FacilityCard:         "card",
+}
+
+func (f Facility) String() string {
+	if name, ok := facilityNames[f]; ok {
+		return name
+	}
+	return fmt.Sprintf("UnknownValue(%d)", uint32(f))
+}
+
+// Operation is what happened to the object a subscription event is about.
+type Operation uint32
+
+// Operations of subscription events, matching PA_SUBSCRIPTION_EVENT_NEW, _CHANGE and _REMOVE.
+const (
+	OpNew    Operation = 0x0000
+	OpChange Operation = 0x0010
+	OpRemove Operation = 0x0020
+)
+
+func (o Operation) String() string {
+	switch o {
+	case OpNew:
+		return "new"
+	case OpChange:
+		return "change"
+	case OpRemove:
+		return "remove"
+	default:
+		return fmt.Sprintf("UnknownValue(%d)", uint32(o))
+	}
+}
+
+// ParseEventType splits the event type of a subscription event, as sent by the server together
+// with the index of the object, into facility and operation. ok is false if the value holds an
+// unknown facility or operation, or bits beyond both.
+func ParseEventType(raw uint32) (facility Facility, operation Operation, ok bool) {
+	facility = Facility(raw & subscriptionFacilityMask)
+	operation = Operation(raw & subscriptionTypeMask)
+	_, known := facilityNames[facility]
+	ok = known && operation != subscriptionTypeMask && raw&^(subscriptionFacilityMask|subscriptionTypeMask) == 0
+	return facility, operation, ok
+}
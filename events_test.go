@@ -0,0 +1,33 @@
+package pulseaudio
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseEventType(t *testing.T) {
+	for _, tc := range []struct {
+		raw       uint32
+		facility  Facility
+		operation Operation
+		ok        bool
+	}{
+		{0x0000, FacilitySink, OpNew, true},
+		{0x0010, FacilitySink, OpChange, true},
+		{0x0022, FacilitySinkInput, OpRemove, true},
+		{0x0017, FacilityServer, OpChange, true},
+		{0x0009, FacilityCard, OpNew, true},
+		{0x0008, Facility(8), OpNew, false}, // obsolete autoload facility
+		{0x0031, FacilitySource, Operation(0x30), false},
+		{0x0110, FacilitySink, OpChange, false},
+	} {
+		facility, operation, ok := ParseEventType(tc.raw)
+		assert.Equal(t, tc.facility, facility, "%#x", tc.raw)
+		assert.Equal(t, tc.operation, operation, "%#x", tc.raw)
+		assert.Equal(t, tc.ok, ok, "%#x", tc.raw)
+	}
+	assert.Equal(t, "sink-input", FacilitySinkInput.String())
+	assert.Equal(t, "remove", OpRemove.String())
+	assert.Equal(t, "UnknownValue(8)", Facility(8).String())
+}
@@ -0,0 +1,713 @@
+package pulseaudio
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeHandler answers a single request. A non-zero code is sent back as an error reply.
+type fakeHandler func(req *bytes.Buffer) (reply []interface{}, code uint32)
+
+// fakeServer speaks just enough of the native protocol for a Client to connect and issue requests.
+type fakeServer struct {
+	t        *testing.T
+	ln       net.Listener
+	addr     string
+	mu       sync.Mutex
+	writeMu  sync.Mutex
+	handlers map[command]fakeHandler
+	conns    []net.Conn
+	accepted int
+	sinks    []Sink
+	cards    []Card
+	sources  []Source
+	inputs   []SinkInput
+	received map[uint32][]byte // stream data by channel
+	written  []CVolume         // sink volumes in the order they were set
+
+	// shared memory, see enableSharedMemory
+	shm         bool
+	shmPool     *shmPool          // the pool data is sent from
+	shmSegments map[uint32][]byte // pools registered by clients
+	shmReleased []uint32          // blocks of shmPool released by clients
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	t.Helper()
+	addr := filepath.Join(t.TempDir(), "native")
+	ln, err := net.Listen("unix", addr)
+	if err != nil {
+		t.Fatalf("could not listen on %s: %v", addr, err)
+	}
+	s := &fakeServer{
+		t:        t,
+		ln:       ln,
+		addr:     addr,
+		handlers: make(map[command]fakeHandler),
+		sinks: []Sink{{
+			Index:       0,
+			Name:        "fake",
+			Description: "Fake Output",
+			SampleSpec:  SampleSpec{Format: 3, Channels: 2, Rate: 44100},
+			ChannelMap:  ChannelMap{1, 2},
+			CVolume:     CVolume{0x8000, 0x8000},
+			BaseVolume:  0x10000,
+
+			MonitorSourceName: "fake.monitor",
+		}},
+		cards: []Card{fakeCard()},
+		sources: []Source{{
+			Index:           0,
+			Name:            "fake.monitor",
+			Description:     "Monitor of Fake Output",
+			SampleSpec:      SampleSpec{Format: 3, Channels: 2, Rate: 44100},
+			ChannelMap:      ChannelMap{1, 2},
+			CVolume:         CVolume{0x10000, 0x10000},
+			MonitorSinkName: "fake",
+			BaseVolume:      0x10000,
+		}},
+	}
+	s.handle(commandAuth, func(*bytes.Buffer) ([]interface{}, uint32) {
+		return []interface{}{uint32Tag, uint32(version)}, 0
+	})
+	s.handle(commandSetClientName, func(*bytes.Buffer) ([]interface{}, uint32) {
+		return []interface{}{uint32Tag, uint32(1)}, 0
+	})
+	s.handle(commandStat, func(*bytes.Buffer) ([]interface{}, uint32) {
+		return []interface{}{
+			uint32Tag, uint32(12), // memblocks in use
+			uint32Tag, uint32(98304),
+			uint32Tag, uint32(4711), // memblocks allocated during the lifetime of the server
+			uint32Tag, uint32(77070336),
+			uint32Tag, uint32(44100), // sample cache size
+		}, 0
+	})
+	s.handle(commandGetServerInfo, func(*bytes.Buffer) ([]interface{}, uint32) {
+		return []interface{}{
+			stringTag, []byte("pulseaudio"), byte(0),
+			stringTag, []byte("16.1"), byte(0),
+			stringTag, []byte("user"), byte(0),
+			stringTag, []byte("host"), byte(0),
+			sampleSpecTag, byte(3), byte(2), uint32(44100),
+			stringTag, []byte("fake"), byte(0),
+			stringTag, []byte("fake.monitor"), byte(0),
+			uint32Tag, uint32(0),
+			channelMapTag, byte(2), []byte{1, 2},
+		}, 0
+	})
+	s.handle(commandGetSinkInfoList, func(*bytes.Buffer) ([]interface{}, uint32) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		var reply []interface{}
+		for _, sink := range s.sinks {
+			reply = append(reply, encodeFakeSink(sink)...)
+		}
+		return reply, 0
+	})
+	s.handle(commandGetSourceInfoList, func(*bytes.Buffer) ([]interface{}, uint32) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		var reply []interface{}
+		for _, source := range s.sources {
+			reply = append(reply, encodeFakeSource(source)...)
+		}
+		return reply, 0
+	})
+	s.handle(commandGetSourceInfo, func(req *bytes.Buffer) ([]interface{}, uint32) {
+		var idx uint32
+		var name string
+		if err := bread(req, uint32Tag, &idx, stringTag, &name); err != nil {
+			return nil, 3 // invalid argument
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for _, source := range s.sources {
+			if (idx != 0xffffffff && source.Index == idx) || (idx == 0xffffffff && source.Name == name) {
+				return encodeFakeSource(source), 0
+			}
+		}
+		return nil, 5 // no such entity
+	})
+	s.handle(commandGetSinkInputInfoList, func(*bytes.Buffer) ([]interface{}, uint32) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		var reply []interface{}
+		for _, input := range s.inputs {
+			reply = append(reply, encodeFakeSinkInput(input)...)
+		}
+		return reply, 0
+	})
+	s.handle(commandGetSinkInputInfo, func(req *bytes.Buffer) ([]interface{}, uint32) {
+		var idx uint32
+		if err := bread(req, uint32Tag, &idx); err != nil {
+			return nil, 3 // invalid argument
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for _, input := range s.inputs {
+			if input.Index == idx {
+				return encodeFakeSinkInput(input), 0
+			}
+		}
+		return nil, 5 // no such entity
+	})
+	s.handle(commandGetCardInfoList, func(*bytes.Buffer) ([]interface{}, uint32) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		var reply []interface{}
+		for _, card := range s.cards {
+			reply = append(reply, encodeFakeCard(card)...)
+		}
+		return reply, 0
+	})
+	s.handle(commandSubscribe, func(req *bytes.Buffer) ([]interface{}, uint32) {
+		var mask uint32
+		if err := bread(req, uint32Tag, &mask); err != nil {
+			return nil, 3 // invalid argument
+		}
+		return nil, 0
+	})
+	s.handle(commandGetCardInfo, func(req *bytes.Buffer) ([]interface{}, uint32) {
+		var idx uint32
+		var name string
+		if err := bread(req, uint32Tag, &idx, stringTag, &name); err != nil {
+			return nil, 3 // invalid argument
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for _, card := range s.cards {
+			if (idx != 0xffffffff && card.Index == idx) || (idx == 0xffffffff && card.Name == name) {
+				return encodeFakeCard(card), 0
+			}
+		}
+		return nil, 5 // no such entity
+	})
+	s.handle(commandSetCardProfile, func(req *bytes.Buffer) ([]interface{}, uint32) {
+		var idx uint32
+		var cardName, profileName string
+		if err := bread(req, uint32Tag, &idx, stringTag, &cardName, stringTag, &profileName); err != nil {
+			return nil, 3 // invalid argument
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for i := range s.cards {
+			if s.cards[i].Index != idx && s.cards[i].Name != cardName {
+				continue
+			}
+			profile, ok := s.cards[i].Profiles[profileName]
+			if !ok {
+				return nil, 5 // no such entity
+			}
+			s.cards[i].ActiveProfile = profile
+			return nil, 0
+		}
+		return nil, 5 // no such entity
+	})
+	s.handle(commandSetPortLatencyOffset, func(req *bytes.Buffer) ([]interface{}, uint32) {
+		var idx uint32
+		var cardName, portName string
+		var offset int64
+		if err := bread(req, uint32Tag, &idx, stringTag, &cardName, stringTag, &portName, int64Tag, &offset); err != nil {
+			return nil, 3 // invalid argument
+		}
+		card := s.card(cardName)
+		if card == nil {
+			return nil, 5 // no such entity
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for i := range card.Ports {
+			if card.Ports[i].Name == portName {
+				card.Ports[i].LatencyOffset = offset
+				return nil, 0
+			}
+		}
+		return nil, 5 // no such entity
+	})
+	s.handle(commandGetSinkInfo, func(req *bytes.Buffer) ([]interface{}, uint32) {
+		var idx uint32
+		var name string
+		if err := bread(req, uint32Tag, &idx, stringTag, &name); err != nil {
+			return nil, 3 // invalid argument
+		}
+		sink := s.sink(idx, name)
+		if sink == nil {
+			return nil, 5 // no such entity
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return encodeFakeSink(*sink), 0
+	})
+	s.handle(commandSetSinkVolume, func(req *bytes.Buffer) ([]interface{}, uint32) {
+		var idx uint32
+		var name string
+		var cvolume CVolume
+		if err := bread(req, uint32Tag, &idx, stringTag, &name, &cvolume); err != nil {
+			return nil, 3 // invalid argument
+		}
+		sink := s.sink(idx, name)
+		if sink == nil {
+			return nil, 5 // no such entity
+		}
+		s.mu.Lock()
+		sink.CVolume = cvolume
+		s.written = append(s.written, cvolume)
+		s.mu.Unlock()
+		return nil, 0
+	})
+	s.handle(commandSetSinkMute, func(req *bytes.Buffer) ([]interface{}, uint32) {
+		var idx uint32
+		var name string
+		var mute bool
+		if err := bread(req, uint32Tag, &idx, stringTag, &name, &mute); err != nil {
+			return nil, 3 // invalid argument
+		}
+		sink := s.sink(idx, name)
+		if sink == nil {
+			return nil, 5 // no such entity
+		}
+		s.mu.Lock()
+		sink.Muted = mute
+		s.mu.Unlock()
+		return nil, 0
+	})
+	s.handle(commandSetSourceMute, func(req *bytes.Buffer) ([]interface{}, uint32) {
+		var idx uint32
+		var name string
+		var mute bool
+		if err := bread(req, uint32Tag, &idx, stringTag, &name, &mute); err != nil {
+			return nil, 3 // invalid argument
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for i := range s.sources {
+			if (idx != 0xffffffff && s.sources[i].Index == idx) || (idx == 0xffffffff && s.sources[i].Name == name) {
+				s.sources[i].Muted = mute
+				return nil, 0
+			}
+		}
+		return nil, 5 // no such entity
+	})
+	go s.serve()
+	t.Cleanup(s.close)
+	return s
+}
+
+// sink looks up a sink by index or, if the index is unset, by name.
+func (s *fakeServer) sink(idx uint32, name string) *Sink {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.sinks {
+		if (idx != 0xffffffff && s.sinks[i].Index == idx) || (idx == 0xffffffff && s.sinks[i].Name == name) {
+			return &s.sinks[i]
+		}
+	}
+	return nil
+}
+
+// volume returns the current volume of the sink with the given name.
+func (s *fakeServer) volume(name string) CVolume {
+	sink := s.sink(0xffffffff, name)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append(CVolume(nil), sink.CVolume...)
+}
+
+// volumeWrites returns every volume set by clients so far.
+func (s *fakeServer) volumeWrites() []CVolume {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]CVolume(nil), s.written...)
+}
+
+// update modifies the sink with the given name.
+func (s *fakeServer) update(name string, modify func(sink *Sink)) {
+	sink := s.sink(0xffffffff, name)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	modify(sink)
+}
+
+func (s *fakeServer) setVolume(name string, cvolume CVolume) {
+	sink := s.sink(0xffffffff, name)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sink.CVolume = cvolume
+}
+
+// encodeFakeSink renders a sink in the wire format decoded by Sink.ReadFrom.
+func encodeFakeSink(sink Sink) []interface{} {
+	muted := falseTag
+	if sink.Muted {
+		muted = trueTag
+	}
+	return []interface{}{
+		uint32Tag, sink.Index,
+		stringTag, []byte(sink.Name), byte(0),
+		stringTag, []byte(sink.Description), byte(0),
+		sampleSpecTag, sink.SampleSpec.Format, sink.SampleSpec.Channels, sink.SampleSpec.Rate,
+		channelMapTag, byte(len(sink.ChannelMap)), []byte(sink.ChannelMap),
+		uint32Tag, sink.ModuleIndex,
+		sink.CVolume,
+		muted,
+		uint32Tag, sink.MonitorSourceIndex,
+		stringTag, []byte(sink.MonitorSourceName), byte(0),
+		usecTag, sink.Latency,
+		stringTag, []byte(sink.Driver), byte(0),
+		uint32Tag, sink.Flags,
+		map[string]string(sink.PropList),
+		usecTag, sink.RequestedLatency,
+		volumeTag, sink.BaseVolume,
+		uint32Tag, sink.SinkState,
+		uint32Tag, sink.NVolumeSteps,
+		uint32Tag, sink.CardIndex,
+		uint32Tag, uint32(0), // ports
+		stringNullTag,      // active port
+		uint8Tag, uint8(0), // formats
+	}
+}
+
+// encodeFakeSource renders a source in the wire format decoded by Source.ReadFrom.
+func encodeFakeSource(source Source) []interface{} {
+	return []interface{}{
+		uint32Tag, source.Index,
+		stringTag, []byte(source.Name), byte(0),
+		stringTag, []byte(source.Description), byte(0),
+		source.SampleSpec,
+		source.ChannelMap,
+		uint32Tag, source.ModuleIndex,
+		source.CVolume,
+		fakeBool(source.Muted),
+		uint32Tag, source.MonitorSinkIndex,
+		stringTag, []byte(source.MonitorSinkName), byte(0),
+		usecTag, source.Latency,
+		stringTag, []byte(source.Driver), byte(0),
+		uint32Tag, source.Flags,
+		map[string]string(source.PropList),
+		usecTag, source.RequestedLatency,
+		volumeTag, source.BaseVolume,
+		uint32Tag, source.SourceState,
+		uint32Tag, source.NVolumeSteps,
+		uint32Tag, source.CardIndex,
+		uint32Tag, uint32(0), // ports
+		stringNullTag,      // active port
+		uint8Tag, uint8(0), // formats
+	}
+}
+
+// encodeFakeSinkInput renders a sink input in the wire format decoded by SinkInput.ReadFrom.
+func encodeFakeSinkInput(input SinkInput) []interface{} {
+	return []interface{}{
+		uint32Tag, input.Index,
+		stringTag, []byte(input.Name), byte(0),
+		uint32Tag, input.ModuleIndex,
+		uint32Tag, input.ClientIndex,
+		uint32Tag, input.SinkIndex,
+		input.SampleSpec,
+		input.ChannelMap,
+		input.CVolume,
+		usecTag, input.BufferLatency,
+		usecTag, input.SinkLatency,
+		stringTag, []byte(input.ResampleMethod), byte(0),
+		stringTag, []byte(input.Driver), byte(0),
+		fakeBool(input.Muted),
+		map[string]string(input.PropList),
+		fakeBool(input.Corked),
+		fakeBool(input.HasVolume),
+		fakeBool(input.VolumeWritable),
+		formatInfoTag, uint8Tag, input.Format.Encoding, map[string]string(input.Format.PropList),
+	}
+}
+
+func fakeBool(b bool) tagType {
+	if b {
+		return trueTag
+	}
+	return falseTag
+}
+
+// fakeCard returns a card with an analog output and an unplugged HDMI output.
+func fakeCard() Card {
+	analog := &Profile{Name: "output:analog-stereo", Description: "Analog Stereo Output", Nsinks: 1, Priority: 6500, Available: 1}
+	hdmi := &Profile{Name: "output:hdmi-stereo", Description: "Digital Stereo (HDMI) Output", Nsinks: 1, Priority: 5900, Available: 0}
+	off := &Profile{Name: "off", Description: "Off", Available: 1}
+	return Card{
+		Index:         0,
+		Name:          "fake_card",
+		Driver:        "module-alsa-card.c",
+		Profiles:      map[string]*Profile{analog.Name: analog, hdmi.Name: hdmi, off.Name: off},
+		ActiveProfile: analog,
+		PropList:      map[string]string{"device.description": "Fake Card"},
+		Ports: []Port{{
+			Name:        "analog-output-speaker",
+			Description: "Speakers",
+			Pririty:     10000,
+			Available:   0, // unknown
+			Direction:   PortDirectionOutput,
+			Profiles:    []*Profile{analog},
+		}, {
+			Name:        "hdmi-output-0",
+			Description: "HDMI / DisplayPort",
+			Pririty:     5900,
+			Available:   1, // not available
+			Direction:   PortDirectionOutput,
+			Profiles:    []*Profile{hdmi},
+		}},
+	}
+}
+
+// card looks up a card by name.
+func (s *fakeServer) card(name string) *Card {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.cards {
+		if s.cards[i].Name == name {
+			return &s.cards[i]
+		}
+	}
+	return nil
+}
+
+// encodeFakeCard renders a card in the wire format decoded by Client.Cards.
+func encodeFakeCard(card Card) []interface{} {
+	reply := []interface{}{
+		uint32Tag, card.Index,
+		stringTag, []byte(card.Name), byte(0),
+		uint32Tag, card.Module,
+		stringTag, []byte(card.Driver), byte(0),
+		uint32Tag, uint32(len(card.Profiles)),
+	}
+	for _, profile := range card.Profiles {
+		reply = append(reply,
+			stringTag, []byte(profile.Name), byte(0),
+			stringTag, []byte(profile.Description), byte(0),
+			uint32Tag, profile.Nsinks,
+			uint32Tag, profile.Nsources,
+			uint32Tag, profile.Priority,
+			uint32Tag, profile.Available)
+	}
+	active := ""
+	if card.ActiveProfile != nil {
+		active = card.ActiveProfile.Name
+	}
+	reply = append(reply,
+		stringTag, []byte(active), byte(0),
+		map[string]string(card.PropList),
+		uint32Tag, uint32(len(card.Ports)))
+	for _, port := range card.Ports {
+		reply = append(reply,
+			stringTag, []byte(port.Name), byte(0),
+			stringTag, []byte(port.Description), byte(0),
+			uint32Tag, port.Pririty,
+			uint32Tag, port.Available,
+			uint8Tag, port.Direction,
+			map[string]string(port.PropList),
+			uint32Tag, uint32(len(port.Profiles)))
+		for _, profile := range port.Profiles {
+			reply = append(reply, stringTag, []byte(profile.Name), byte(0))
+		}
+		reply = append(reply, int64Tag, port.LatencyOffset)
+	}
+	return reply
+}
+
+// newFakeClient returns a client connected to the server. It is closed when the test ends.
+func newFakeClient(t *testing.T, s *fakeServer, clientOpts ...ClientOpt) *Client {
+	t.Helper()
+	c := NewClient(Opts{
+		Addr:           s.uri(),
+		Cookie:         fakeCookie(t),
+		RequestTimeout: time.Second,
+	}, clientOpts...)
+	var wg sync.WaitGroup
+	c.Connect(context.Background(), 10*time.Millisecond, &wg)
+	t.Cleanup(func() {
+		c.Close()
+		wg.Wait()
+	})
+	return c
+}
+
+// uri returns the server address in the format accepted by Opts.Addr.
+func (s *fakeServer) uri() string {
+	return "unix://" + s.addr
+}
+
+func (s *fakeServer) handle(cmd command, h fakeHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[cmd] = h
+}
+
+func (s *fakeServer) connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.accepted
+}
+
+// streamData returns the data received on the stream channel.
+func (s *fakeServer) streamData(channel uint32) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]byte(nil), s.received[channel]...)
+}
+
+// broadcast sends a command with the server-initiated tag to all connected clients.
+func (s *fakeServer) broadcast(cmd command, args ...interface{}) {
+	s.each(func(conn net.Conn) error {
+		return writeFakeFrame(conn, cmd, 0xffffffff, args...)
+	})
+}
+
+// sendData sends a memblock on the stream channel to all connected clients.
+func (s *fakeServer) sendData(channel uint32, data []byte) {
+	s.each(func(conn net.Conn) error {
+		header := make([]byte, 20)
+		binary.BigEndian.PutUint32(header, uint32(len(data)))
+		binary.BigEndian.PutUint32(header[4:], channel)
+		_, err := conn.Write(append(header, data...))
+		return err
+	})
+}
+
+func (s *fakeServer) each(write func(conn net.Conn) error) {
+	s.mu.Lock()
+	conns := append([]net.Conn(nil), s.conns...)
+	s.mu.Unlock()
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	for _, conn := range conns {
+		if err := write(conn); err != nil {
+			s.t.Logf("fake server write error: %v", err)
+		}
+	}
+}
+
+// drop closes all client connections currently served.
+func (s *fakeServer) drop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.conns = nil
+}
+
+func (s *fakeServer) close() {
+	_ = s.ln.Close()
+	s.drop()
+}
+
+func (s *fakeServer) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns = append(s.conns, conn)
+		s.accepted++
+		s.mu.Unlock()
+		go s.serveConn(conn)
+	}
+}
+
+func (s *fakeServer) serveConn(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	for {
+		header, fds, err := s.readHeader(conn)
+		if err != nil {
+			return
+		}
+		payload := make([]byte, binary.BigEndian.Uint32(header))
+		if _, err := io.ReadFull(conn, payload); err != nil {
+			return
+		}
+		if binary.BigEndian.Uint32(header[16:])&frameFlagShmMask != 0 {
+			s.shmFrame(conn, header, payload)
+			continue
+		}
+		if channel := binary.BigEndian.Uint32(header[4:]); channel != 0xffffffff {
+			s.mu.Lock()
+			if s.received == nil {
+				s.received = make(map[uint32][]byte)
+			}
+			s.received[channel] = append(s.received[channel], payload...)
+			s.mu.Unlock()
+			continue
+		}
+		req := bytes.NewBuffer(payload)
+		var cmd command
+		var tag uint32
+		if err := bread(req, uint32Tag, &cmd, uint32Tag, &tag); err != nil {
+			return
+		}
+		if cmd == commandRegisterMemfdShmid {
+			// not answered
+			s.registerSegment(req, fds)
+			continue
+		}
+		closeFDs(fds)
+		s.mu.Lock()
+		h, ok := s.handlers[cmd]
+		s.mu.Unlock()
+		var reply []interface{}
+		code := uint32(2) // unknown command
+		if ok {
+			reply, code = h(req)
+		}
+		if code == fakeTruncatedError {
+			reply = nil
+		} else if code != 0 {
+			reply = []interface{}{uint32Tag, code}
+		}
+		rsp := commandReply
+		if code != 0 {
+			rsp = commandError
+		}
+		s.writeMu.Lock()
+		err = writeFakeFrame(conn, rsp, tag, reply...)
+		s.writeMu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// fakeTruncatedError makes the fake server answer with an error frame lacking the error code.
+const fakeTruncatedError = 0xffffffff
+
+func writeFakeFrame(w io.Writer, cmd command, tag uint32, args ...interface{}) error {
+	var b bytes.Buffer
+	err := bwrite(&b, append([]interface{}{uint32(0), // length is fixed below
+		uint32(0xffffffff),   // channel
+		uint32(0), uint32(0), // offset high & low
+		uint32(0),              // flags
+		uint32Tag, uint32(cmd), // command
+		uint32Tag, tag, // tag
+	}, args...)...)
+	if err != nil {
+		return err
+	}
+	binary.BigEndian.PutUint32(b.Bytes(), uint32(b.Len()-20))
+	_, err = w.Write(b.Bytes())
+	return err
+}
+
+// fakeCookie writes a valid (all-zero) authentication cookie and returns its path.
+func fakeCookie(t *testing.T) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "cookie")
+	if err := os.WriteFile(p, make([]byte, 256), 0600); err != nil {
+		t.Fatalf("could not write cookie: %v", err)
+	}
+	return p
+}
@@ -1,238 +1,179 @@
 package pulseaudio
 
 import (
-	"encoding/binary"
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"reflect"
+	"strings"
+
+	"github.com/gophertribe/pulseaudio/internal/tagstruct"
 )
 
-type tagType byte
+// The tagstruct encoding is shared with the fake server of package pulseaudiotest.
+type tagType = tagstruct.Tag
 
 const (
-	invalidTag    tagType = 0
-	stringTag     tagType = 't'
-	stringNullTag tagType = 'N'
-	uint32Tag     tagType = 'L'
-	uint8Tag      tagType = 'B'
-	uint64Tag     tagType = 'R'
-	int64Tag      tagType = 'r'
-	sampleSpecTag tagType = 'a'
-	arbitraryTag  tagType = 'x'
-	trueTag       tagType = '1'
-	falseTag      tagType = '0'
-	timeTag       tagType = 'T'
-	usecTag       tagType = 'U'
-	channelMapTag tagType = 'm'
-	cvolumeTag    tagType = 'v'
-	propListTag   tagType = 'P'
-	volumeTag     tagType = 'V'
-	formatInfoTag tagType = 'f'
+	invalidTag    = tagstruct.InvalidTag
+	stringTag     = tagstruct.StringTag
+	stringNullTag = tagstruct.StringNullTag
+	uint32Tag     = tagstruct.Uint32Tag
+	uint8Tag      = tagstruct.Uint8Tag
+	uint64Tag     = tagstruct.Uint64Tag
+	int64Tag      = tagstruct.Int64Tag
+	sampleSpecTag = tagstruct.SampleSpecTag
+	arbitraryTag  = tagstruct.ArbitraryTag
+	trueTag       = tagstruct.TrueTag
+	falseTag      = tagstruct.FalseTag
+	timeTag       = tagstruct.TimeTag
+	usecTag       = tagstruct.UsecTag
+	channelMapTag = tagstruct.ChannelMapTag
+	cvolumeTag    = tagstruct.CVolumeTag
+	propListTag   = tagstruct.PropListTag
+	volumeTag     = tagstruct.VolumeTag
+	formatInfoTag = tagstruct.FormatInfoTag
 )
 
-func (t tagType) String() string {
-	switch t {
-	case invalidTag:
-		return "invalidTag"
-	case stringTag:
-		return "stringTag"
-	case stringNullTag:
-		return "stringNullTag"
-	case uint32Tag:
-		return "uint32Tag"
-	case uint8Tag:
-		return "uint8Tag"
-	case uint64Tag:
-		return "uint64Tag"
-	case int64Tag:
-		return "int64Tag"
-	case sampleSpecTag:
-		return "sampleSpecTag"
-	case arbitraryTag:
-		return "arbitraryTag"
-	case trueTag:
-		return "trueTag"
-	case falseTag:
-		return "falseTag"
-	case timeTag:
-		return "timeTag"
-	case usecTag:
-		return "usecTag"
-	case channelMapTag:
-		return "channelMapTag"
-	case cvolumeTag:
-		return "cvolumeTag"
-	case propListTag:
-		return "propListTag"
-	case volumeTag:
-		return "volumeTag"
-	case formatInfoTag:
-		return "formatInfoTag"
-	default:
-		return fmt.Sprintf("UnknownValue(%d)", t)
-	}
-}
-
 type binaryReader interface {
 	readFrom(r io.Reader, c *Client) error
 }
 
 func bwrite(w io.Writer, data ...interface{}) error {
 	for _, v := range data {
-		if propList, ok := v.(map[string]string); ok {
-			err := bwrite(w, propListTag)
+		if cvolume, ok := v.(CVolume); ok {
+			arr := []uint32(cvolume)
+			err := bwrite(w, cvolumeTag, byte(len(arr)), arr)
 			if err != nil {
 				return err
 			}
-			for k, v := range propList {
-				if v == "" {
-					continue
-				}
-
-				l := uint32(len(v) + 1) // +1 for null at the end of string
-				err := bwrite(w,
-					stringTag, []byte(k), byte(0),
-					uint32Tag, l,
-					arbitraryTag, l,
-					[]byte(v), byte(0),
-				)
-				if err != nil {
-					return err
-				}
-			}
-			err = bwrite(w, stringNullTag)
+			continue
+		}
+
+		if spec, ok := v.(SampleSpec); ok {
+			err := bwrite(w, sampleSpecTag, spec.Format, spec.Channels, spec.Rate)
 			if err != nil {
 				return err
 			}
 			continue
 		}
 
-		if cvolume, ok := v.(CVolume); ok {
-			arr := []uint32(cvolume)
-			err := bwrite(w, cvolumeTag, byte(len(arr)), arr)
+		if channelMap, ok := v.(ChannelMap); ok {
+			err := bwrite(w, channelMapTag, byte(len(channelMap)), []byte(channelMap))
 			if err != nil {
 				return err
 			}
 			continue
 		}
 
-		if err := binary.Write(w, binary.BigEndian, v); err != nil {
+		if err := tagstruct.Write(w, v); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func bread(r io.Reader, data ...interface{}) error {
-	nullString := false
-	for i, v := range data {
-		t, ok := v.(tagType)
-		if ok {
-			var tt tagType
-			if err := binary.Read(r, binary.BigEndian, &tt); err != nil {
-				return err
-			}
-			if tt != t {
-				if t == stringTag && tt == stringNullTag {
-					nullString = true
-					continue
-				}
-				return fmt.Errorf("protcol error: (field %d) got type %s but expected %s", i, tt, t)
-			}
-			continue
-		}
+// argError reports which argument of bread failed to decode.
+type argError struct {
+	index int
+	err   error
+}
 
-		sptr, ok := v.(*string)
-		if ok {
-			if nullString {
-				nullString = false
-				continue
-			}
-			buf := make([]byte, 1024) // max string length i guess.
-			i := 0
-			for {
-				_, err := r.Read(buf[i : i+1])
-				if err != nil {
-					return err
-				}
-				if buf[i] == 0 {
-					*sptr = string(buf[:i])
-					break
-				} else {
-					if i > len(buf) {
-						return fmt.Errorf("string is too long (max %d bytes)", len(buf))
-					}
-					i++
-				}
-			}
-			continue
-		}
+func (e *argError) Error() string { return e.err.Error() }
+func (e *argError) Unwrap() error { return e.err }
 
-		propList, ok := v.(*map[string]string)
-		if ok {
-			*propList = make(map[string]string)
-			err := bread(r, propListTag)
-			if err != nil {
-				return err
-			}
-			for {
-				var t tagType
-				if err = bread(r, &t); err != nil {
-					return err
-				}
-				if t == stringNullTag {
-					// end of the proplist.
-					break
-				}
-				if t != stringTag {
-					return fmt.Errorf("protcol error: got type %s but expected %s", t, stringTag)
-				}
-
-				var k, v string
-				var l1, l2 uint32
-				if err = bread(r,
-					&k,
-					uint32Tag, &l1,
-					arbitraryTag, &l2,
-					&v,
-				); err != nil {
-					return err
-				}
-				if len(v) != int(l1-1) || len(v) != int(l2-1) {
-					return fmt.Errorf("protocol error: proplist value length mismatch (len %d, arb len %d, value len %d)",
-						l1, l2, len(v))
-				}
-				(*propList)[k] = v
+// decodeError describes where decoding a reply failed, e.g. "Sink.CVolume" of the reply to
+// commandGetSinkInfoList.
+type decodeError struct {
+	cmd  string
+	path string
+	err  error
+}
+
+func (e *decodeError) Error() string {
+	if e.cmd == "" {
+		return fmt.Sprintf("decoding %s: %v", e.path, e.err)
+	}
+	return fmt.Sprintf("decoding %s for %s: %v", e.path, e.cmd, e.err)
+}
+
+func (e *decodeError) Unwrap() error { return e.err }
+
+// breadStruct reads fields of the struct v like bread. Errors name the field being decoded.
+func breadStruct(r io.Reader, v interface{}, data ...interface{}) error {
+	err := bread(r, data...)
+	if err == nil {
+		return nil
+	}
+	path := reflect.TypeOf(v).Elem().Name()
+	var ae *argError
+	if errors.As(err, &ae) {
+		err = ae.err
+		if name := fieldName(v, data[ae.index:]); name != "" {
+			path += "." + name
+		}
+	}
+	var de *decodeError
+	if errors.As(err, &de) {
+		// a nested value failed; keep its fields but not its type name if the field is known
+		inner := de.path
+		if strings.Contains(path, ".") {
+			inner = ""
+			if i := strings.Index(de.path, "."); i >= 0 {
+				inner = de.path[i+1:]
 			}
-			continue
 		}
+		if inner != "" {
+			path += "." + inner
+		}
+		err = de.err
+	}
+	return &decodeError{path: path, err: err}
+}
 
-		rdr, ok := v.(io.ReaderFrom)
-		if ok {
-			if _, err := rdr.ReadFrom(r); err != nil {
-				return err
-			}
+// fieldName returns the name of the field of the struct v which the first pointer in data
+// points to. Tags preceding the pointer are skipped.
+func fieldName(v interface{}, data []interface{}) string {
+	for _, d := range data {
+		if _, ok := d.(tagType); ok {
 			continue
 		}
-
-		bptr, ok := v.(*bool)
-		if ok {
-			var tt tagType
-			if err := binary.Read(r, binary.BigEndian, &tt); err != nil {
-				return err
-			}
-			if tt == trueTag {
-				*bptr = true
-			} else if tt == falseTag {
-				*bptr = false
-			} else {
-				return fmt.Errorf("protcol error: got type %s but expected boolean true or false", tt)
+		p := reflect.ValueOf(d)
+		if p.Kind() != reflect.Ptr {
+			return ""
+		}
+		s := reflect.ValueOf(v).Elem()
+		for i := 0; i < s.NumField(); i++ {
+			if s.Field(i).Addr().Pointer() == p.Pointer() && s.Field(i).Type() == p.Type().Elem() {
+				return s.Type().Field(i).Name
 			}
-			continue
 		}
+		return ""
+	}
+	return ""
+}
 
-		if err := binary.Read(r, binary.BigEndian, v); err != nil {
-			return err
-		}
+// decodeReply reads a reply to cmd like bread. Errors name the command and the field being decoded.
+func decodeReply(cmd command, b *bytes.Buffer, data ...interface{}) error {
+	err := bread(b, data...)
+	if err == nil {
+		return nil
+	}
+	var ae *argError
+	if errors.As(err, &ae) {
+		err = ae.err
+	}
+	var de *decodeError
+	if errors.As(err, &de) {
+		return &decodeError{cmd: cmd.String(), path: de.path, err: de.err}
+	}
+	return &decodeError{cmd: cmd.String(), path: "reply", err: err}
+}
+
+func bread(r io.Reader, data ...interface{}) error {
+	i, err := tagstruct.Read(r, data...)
+	if err != nil {
+		return &argError{index: i, err: err}
 	}
 	return nil
 }
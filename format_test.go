@@ -0,0 +1,61 @@
+package pulseaudio
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeErrorNamesField(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.handle(commandGetSinkInfoList, func(*bytes.Buffer) ([]interface{}, uint32) {
+		reply := encodeFakeSink(srv.sinks[0])
+		// send a channel map where the volume is expected
+		for i, v := range reply {
+			if cvolume, ok := v.(CVolume); ok {
+				reply[i] = ChannelMap{byte(len(cvolume)), 1}
+			}
+		}
+		return reply, 0
+	})
+	c := newFakeClient(t, srv)
+
+	_, err := c.Sinks(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "decoding Sink.CVolume for commandGetSinkInfoList: ")
+	var de *decodeError
+	assert.True(t, errors.As(err, &de))
+}
+
+func TestDecodeErrorNestedField(t *testing.T) {
+	var b bytes.Buffer
+	card := fakeCard()
+	reply := encodeFakeCard(card)
+	require.NoError(t, bwrite(&b, reply...))
+	// cut the reply within the name of the second port
+	data := b.Bytes()
+	i := bytes.Index(data, []byte("hdmi-output-0"))
+	require.True(t, i > 0)
+
+	err := decodeReply(commandGetCardInfo, bytes.NewBuffer(data[:i+3]), &card)
+	require.Error(t, err)
+	assert.Equal(t, "decoding Card.Port.Name for commandGetCardInfo: EOF", err.Error())
+	assert.True(t, errors.Is(err, io.EOF))
+
+	// a module without its property list
+	b.Reset()
+	require.NoError(t, bwrite(&b, uint32Tag, uint32(3), stringTag, []byte("module-null-sink"), byte(0),
+		stringNullTag, uint32Tag, uint32(0xffffffff)))
+	var module Module
+	err = decodeReply(commandGetModuleInfoList, &b, &module)
+	assert.EqualError(t, err, "decoding Module.PropList for commandGetModuleInfoList: EOF")
+
+	var spec SampleSpec
+	err = breadStruct(bytes.NewBuffer([]byte{byte(sampleSpecTag), 3, 2}), &spec, sampleSpecTag, &spec.Format, &spec.Channels, &spec.Rate)
+	assert.EqualError(t, err, "decoding SampleSpec.Rate: EOF")
+}
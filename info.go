@@ -2,7 +2,12 @@ package pulseaudio
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
+	"sort"
+	"strings"
+	"time"
 )
 
 type Server struct {
@@ -18,7 +23,7 @@ type Server struct {
 }
 
 func (s *Server) ReadFrom(r io.Reader) (int64, error) {
-	return 0, bread(r,
+	return 0, breadStruct(r, s,
 		stringTag, &s.PackageName,
 		stringTag, &s.PackageVersion,
 		stringTag, &s.User,
@@ -39,7 +44,7 @@ type Module struct {
 }
 
 func (m *Module) ReadFrom(r io.Reader) (int64, error) {
-	err := bread(r,
+	err := breadStruct(r, m,
 		uint32Tag, &m.Index,
 		stringTag, &m.Name,
 		stringTag, &m.Argument,
@@ -47,8 +52,8 @@ func (m *Module) ReadFrom(r io.Reader) (int64, error) {
 	if err != nil {
 		return 0, err
 	}
-	err = bread(r, &m.PropList)
-	return 0, nil
+	err = breadStruct(r, m, &m.PropList)
+	return 0, err
 }
 
 type Sink struct {
@@ -64,11 +69,11 @@ type Sink struct {
 	MonitorSourceName  string
 	Latency            uint64
 	Driver             string
-	Flags              uint32
+	Flags              SinkFlags
 	PropList           map[string]string
 	RequestedLatency   uint64
 	BaseVolume         uint32
-	SinkState          uint32
+	SinkState          SinkState
 	NVolumeSteps       uint32
 	CardIndex          uint32
 	Ports              []SinkPort
@@ -76,9 +81,130 @@ type Sink struct {
 	Formats            []FormatInfo
 }
 
+// SinkState is the operational state of a sink.
+type SinkState uint32
+
+const (
+	SinkStateRunning   SinkState = 0
+	SinkStateIdle      SinkState = 1
+	SinkStateSuspended SinkState = 2
+	SinkStateInvalid   SinkState = 0xffffffff
+)
+
+func (s SinkState) String() string {
+	switch s {
+	case SinkStateRunning:
+		return "RUNNING"
+	case SinkStateIdle:
+		return "IDLE"
+	case SinkStateSuspended:
+		return "SUSPENDED"
+	case SinkStateInvalid:
+		return "INVALID"
+	default:
+		return fmt.Sprintf("UnknownValue(%d)", s)
+	}
+}
+
+// SinkFlags is a bitfield describing the capabilities of a sink.
+type SinkFlags uint32
+
+const (
+	SinkHwVolumeCtrl   SinkFlags = 0x0001
+	SinkLatency        SinkFlags = 0x0002
+	SinkHardware       SinkFlags = 0x0004
+	SinkNetwork        SinkFlags = 0x0008
+	SinkHwMuteCtrl     SinkFlags = 0x0010
+	SinkDecibelVolume  SinkFlags = 0x0020
+	SinkFlatVolume     SinkFlags = 0x0040
+	SinkDynamicLatency SinkFlags = 0x0080
+	SinkSetFormats     SinkFlags = 0x0100
+)
+
+// sinkFlagNames lists the flags in the order used by pactl.
+var sinkFlagNames = []struct {
+	flag SinkFlags
+	name string
+}{
+	{SinkHardware, "HARDWARE"},
+	{SinkNetwork, "NETWORK"},
+	{SinkHwMuteCtrl, "HW_MUTE_CTRL"},
+	{SinkHwVolumeCtrl, "HW_VOLUME_CTRL"},
+	{SinkDecibelVolume, "DECIBEL_VOLUME"},
+	{SinkLatency, "LATENCY"},
+	{SinkDynamicLatency, "DYNAMIC_LATENCY"},
+	{SinkFlatVolume, "FLAT_VOLUME"},
+	{SinkSetFormats, "SET_FORMATS"},
+}
+
+// Has reports whether all bits of flag are set.
+func (f SinkFlags) Has(flag SinkFlags) bool {
+	return f&flag == flag
+}
+
+// String renders the flags space separated, e.g. "HARDWARE DECIBEL_VOLUME LATENCY".
+func (f SinkFlags) String() string {
+	var names []string
+	rest := f
+	for _, n := range sinkFlagNames {
+		if f.Has(n.flag) {
+			names = append(names, n.name)
+			rest &^= n.flag
+		}
+	}
+	if rest != 0 {
+		names = append(names, fmt.Sprintf("0x%x", uint32(rest)))
+	}
+	return strings.Join(names, " ")
+}
+
+// parseSinkFlags reads flags in the format produced by SinkFlags.String. Unknown names are ignored.
+func parseSinkFlags(s string) SinkFlags {
+	var f SinkFlags
+	for _, field := range strings.Fields(s) {
+		for _, n := range sinkFlagNames {
+			if n.name == field {
+				f |= n.flag
+			}
+		}
+	}
+	return f
+}
+
+// HasHardwareVolume reports whether volume changes are applied by the device. Otherwise the
+// server scales the samples in software.
+func (s *Sink) HasHardwareVolume() bool {
+	return s.Flags.Has(SinkHwVolumeCtrl)
+}
+
+// SupportsDecibelVolume reports whether the volume of the sink maps to decibels.
+func (s *Sink) SupportsDecibelVolume() bool {
+	return s.Flags.Has(SinkDecibelVolume)
+}
+
+// VolumeSteps returns the number of discrete volume steps of the sink, from muted to VolumeNorm.
+// Sinks with software or decibel volume report VolumeNorm+1 steps, i.e. a continuous scale.
+func (s *Sink) VolumeSteps() uint32 {
+	return s.NVolumeSteps
+}
+
+// VolumeStep returns the step nearest to the average volume of the sink, see VolumeSteps. Volumes
+// above VolumeNorm map to the last step.
+func (s *Sink) VolumeStep() uint32 {
+	if s.NVolumeSteps < 2 {
+		return 0
+	}
+	last := uint64(s.NVolumeSteps - 1)
+	step := (uint64(s.CVolume.Avg())*last + VolumeNorm/2) / VolumeNorm
+	if step > last {
+		return uint32(last)
+	}
+	return uint32(step)
+}
+
 func (s *Sink) ReadFrom(r io.Reader) (int64, error) {
 	var portCount uint32
-	err := bread(r,
+	err := breadStruct(r, s,
 		uint32Tag, &s.Index,
 		stringTag, &s.Name,
 		stringTag, &s.Description,
@@ -104,32 +230,32 @@ func (s *Sink) ReadFrom(r io.Reader) (int64, error) {
 	}
 	s.Ports = make([]SinkPort, portCount)
 	for i := uint32(0); i < portCount; i++ {
-		err = bread(r, &s.Ports[i])
+		err = breadStruct(r, s, &s.Ports[i])
 		if err != nil {
 			return 0, err
 		}
 	}
 	if portCount == 0 {
-		err = bread(r, stringNullTag)
+		err = breadStruct(r, s, stringNullTag)
 		if err != nil {
 			return 0, err
 		}
 	} else {
-		err = bread(r, stringTag, &s.ActivePortName)
+		err = breadStruct(r, s, stringTag, &s.ActivePortName)
 		if err != nil {
 			return 0, err
 		}
 	}
 
 	var formatCount uint8
-	err = bread(r,
+	err = breadStruct(r, s,
 		uint8Tag, &formatCount)
 	if err != nil {
 		return 0, err
 	}
 	s.Formats = make([]FormatInfo, formatCount)
 	for i := uint8(0); i < formatCount; i++ {
-		err = bread(r, &s.Formats[i])
+		err = breadStruct(r, s, &s.Formats[i])
 		if err != nil {
 			return 0, err
 		}
@@ -137,13 +263,261 @@ func (s *Sink) ReadFrom(r io.Reader) (int64, error) {
 	return 0, nil
 }
 
+// SourceFlags is a bitfield describing the capabilities of a source. The bits differ from
+// SinkFlags from SourceDynamicLatency on.
+type SourceFlags uint32
+
+const (
+	SourceHwVolumeCtrl   SourceFlags = 0x0001
+	SourceLatency        SourceFlags = 0x0002
+	SourceHardware       SourceFlags = 0x0004
+	SourceNetwork        SourceFlags = 0x0008
+	SourceHwMuteCtrl     SourceFlags = 0x0010
+	SourceDecibelVolume  SourceFlags = 0x0020
+	SourceDynamicLatency SourceFlags = 0x0040
+	SourceFlatVolume     SourceFlags = 0x0080
+)
+
+// sourceFlagNames lists the flags in the order used by pactl.
+var sourceFlagNames = []struct {
+	flag SourceFlags
+	name string
+}{
+	{SourceHardware, "HARDWARE"},
+	{SourceNetwork, "NETWORK"},
+	{SourceHwMuteCtrl, "HW_MUTE_CTRL"},
+	{SourceHwVolumeCtrl, "HW_VOLUME_CTRL"},
+	{SourceDecibelVolume, "DECIBEL_VOLUME"},
+	{SourceLatency, "LATENCY"},
+	{SourceDynamicLatency, "DYNAMIC_LATENCY"},
+	{SourceFlatVolume, "FLAT_VOLUME"},
+}
+
+// Has reports whether all bits of flag are set.
+func (f SourceFlags) Has(flag SourceFlags) bool {
+	return f&flag == flag
+}
+
+// String renders the flags space separated, e.g. "HARDWARE DECIBEL_VOLUME LATENCY".
+func (f SourceFlags) String() string {
+	var names []string
+	rest := f
+	for _, n := range sourceFlagNames {
+		if f.Has(n.flag) {
+			names = append(names, n.name)
+			rest &^= n.flag
+		}
+	}
+	if rest != 0 {
+		names = append(names, fmt.Sprintf("0x%x", uint32(rest)))
+	}
+	return strings.Join(names, " ")
+}
+
+// parseSourceFlags reads flags in the format produced by SourceFlags.String. Unknown names are
+// ignored.
+func parseSourceFlags(s string) SourceFlags {
+	var f SourceFlags
+	for _, field := range strings.Fields(s) {
+		for _, n := range sourceFlagNames {
+			if n.name == field {
+				f |= n.flag
+			}
+		}
+	}
+	return f
+}
+
+type Source struct {
+	Index            uint32
+	Name             string
+	Description      string
+	SampleSpec       SampleSpec
+	ChannelMap       ChannelMap
+	ModuleIndex      uint32
+	CVolume          CVolume
+	Muted            bool
+	MonitorSinkIndex uint32
+	MonitorSinkName  string
+	Latency          uint64
+	Driver           string
+	Flags            SourceFlags
+	PropList         map[string]string
+	RequestedLatency uint64
+	BaseVolume       uint32
+	SourceState      SinkState // sources use the same states as sinks
+	NVolumeSteps     uint32
+	CardIndex        uint32
+	Ports            []SinkPort
+	ActivePortName   string
+	Formats          []FormatInfo
+}
+
+func (s *Source) ReadFrom(r io.Reader) (int64, error) {
+	var portCount uint32
+	err := breadStruct(r, s,
+		uint32Tag, &s.Index,
+		stringTag, &s.Name,
+		stringTag, &s.Description,
+		&s.SampleSpec,
+		&s.ChannelMap,
+		uint32Tag, &s.ModuleIndex,
+		&s.CVolume,
+		&s.Muted,
+		uint32Tag, &s.MonitorSinkIndex,
+		stringTag, &s.MonitorSinkName,
+		usecTag, &s.Latency,
+		stringTag, &s.Driver,
+		uint32Tag, &s.Flags,
+		&s.PropList,
+		usecTag, &s.RequestedLatency,
+		volumeTag, &s.BaseVolume,
+		uint32Tag, &s.SourceState,
+		uint32Tag, &s.NVolumeSteps,
+		uint32Tag, &s.CardIndex,
+		uint32Tag, &portCount)
+	if err != nil {
+		return 0, err
+	}
+	s.Ports = make([]SinkPort, portCount)
+	for i := uint32(0); i < portCount; i++ {
+		err = breadStruct(r, s, &s.Ports[i])
+		if err != nil {
+			return 0, err
+		}
+	}
+	// the active port is sent as a null string if there are no ports
+	err = breadStruct(r, s, stringTag, &s.ActivePortName)
+	if err != nil {
+		return 0, err
+	}
+	var formatCount uint8
+	err = breadStruct(r, s, uint8Tag, &formatCount)
+	if err != nil {
+		return 0, err
+	}
+	s.Formats = make([]FormatInfo, formatCount)
+	for i := uint8(0); i < formatCount; i++ {
+		err = breadStruct(r, s, &s.Formats[i])
+		if err != nil {
+			return 0, err
+		}
+	}
+	return 0, nil
+}
+
+// SinkInput is a playback stream connected to a sink.
+type SinkInput struct {
+	Index          uint32
+	Name           string
+	ModuleIndex    uint32
+	ClientIndex    uint32
+	SinkIndex      uint32
+	SampleSpec     SampleSpec
+	ChannelMap     ChannelMap
+	CVolume        CVolume
+	BufferLatency  uint64
+	SinkLatency    uint64
+	ResampleMethod string
+	Driver         string
+	Muted          bool
+	PropList       map[string]string
+	Corked         bool
+	HasVolume      bool
+	VolumeWritable bool
+	Format         FormatInfo
+}
+
+func (s *SinkInput) ReadFrom(r io.Reader) (int64, error) {
+	return 0, breadStruct(r, s,
+		uint32Tag, &s.Index,
+		stringTag, &s.Name,
+		uint32Tag, &s.ModuleIndex,
+		uint32Tag, &s.ClientIndex,
+		uint32Tag, &s.SinkIndex,
+		&s.SampleSpec,
+		&s.ChannelMap,
+		&s.CVolume,
+		usecTag, &s.BufferLatency,
+		usecTag, &s.SinkLatency,
+		stringTag, &s.ResampleMethod,
+		stringTag, &s.Driver,
+		&s.Muted,
+		&s.PropList,
+		&s.Corked,
+		&s.HasVolume,
+		&s.VolumeWritable,
+		&s.Format)
+}
+
+// FormatEncoding is the encoding of a stream format, e.g. PCM or a compressed format which is
+// passed through to an S/PDIF or HDMI receiver.
+type FormatEncoding byte
+
+const (
+	FormatEncodingAny      FormatEncoding = 0
+	FormatEncodingPCM      FormatEncoding = 1
+	FormatEncodingAC3      FormatEncoding = 2
+	FormatEncodingEAC3     FormatEncoding = 3
+	FormatEncodingMPEG     FormatEncoding = 4
+	FormatEncodingDTS      FormatEncoding = 5
+	FormatEncodingMPEG2AAC FormatEncoding = 6
+	FormatEncodingTrueHD   FormatEncoding = 7
+	FormatEncodingDTSHD    FormatEncoding = 8
+	FormatEncodingInvalid  FormatEncoding = 0xff
+)
+
+var formatEncodingNames = []string{
+	"any",
+	"pcm",
+	"ac3-iec61937",
+	"eac3-iec61937",
+	"mpeg-iec61937",
+	"dts-iec61937",
+	"mpeg2-aac-iec61937",
+	"truehd-iec61937",
+	"dtshd-iec61937",
+}
+
+// String returns the encoding name used by PulseAudio, e.g. "ac3-iec61937".
+func (e FormatEncoding) String() string {
+	if int(e) < len(formatEncodingNames) {
+		return formatEncodingNames[e]
+	}
+	if e == FormatEncodingInvalid {
+		return "invalid"
+	}
+	return fmt.Sprintf("UnknownValue(%d)", e)
+}
+
+var formatEncodingLabels = []string{
+	"Any",
+	"PCM",
+	"AC3",
+	"E-AC3",
+	"MPEG",
+	"DTS",
+	"MPEG-2 AAC",
+	"TrueHD",
+	"DTS-HD",
+}
+
+// Label returns a short name of the encoding for display, e.g. "AC3" for passthrough of AC3
+// in IEC 61937 frames. Unknown encodings are labelled like String.
+func (e FormatEncoding) Label() string {
+	if int(e) < len(formatEncodingLabels) {
+		return formatEncodingLabels[e]
+	}
+	return e.String()
+}
+
 type FormatInfo struct {
-	Encoding byte
+	Encoding FormatEncoding
 	PropList map[string]string
 }
 
 func (i *FormatInfo) ReadFrom(r io.Reader) (int64, error) {
-	return 0, bread(r, formatInfoTag, uint8Tag, &i.Encoding, &i.PropList)
+	return 0, breadStruct(r, i, formatInfoTag, uint8Tag, &i.Encoding, &i.PropList)
 }
 
 type SinkPort struct {
@@ -153,7 +527,7 @@ type SinkPort struct {
 }
 
 func (p *SinkPort) ReadFrom(r io.Reader) (int64, error) {
-	return 0, bread(r,
+	return 0, breadStruct(r, p,
 		stringTag, &p.Name,
 		stringTag, &p.Description,
 		uint32Tag, &p.Priority,
@@ -172,8 +546,136 @@ func (v *CVolume) ReadFrom(r io.Reader) (int64, error) {
 	return 0, bread(r, []uint32(*v))
 }
 
+// ChannelPosition is the speaker position a channel is played on.
+type ChannelPosition byte
+
+const (
+	ChannelMono               ChannelPosition = 0
+	ChannelFrontLeft          ChannelPosition = 1
+	ChannelFrontRight         ChannelPosition = 2
+	ChannelFrontCenter        ChannelPosition = 3
+	ChannelRearCenter         ChannelPosition = 4
+	ChannelRearLeft           ChannelPosition = 5
+	ChannelRearRight          ChannelPosition = 6
+	ChannelLFE                ChannelPosition = 7
+	ChannelFrontLeftOfCenter  ChannelPosition = 8
+	ChannelFrontRightOfCenter ChannelPosition = 9
+	ChannelSideLeft           ChannelPosition = 10
+	ChannelSideRight          ChannelPosition = 11
+	ChannelAux0               ChannelPosition = 12 // ChannelAux0 + n is the n-th auxiliary channel
+	ChannelAux31              ChannelPosition = 43
+	ChannelTopCenter          ChannelPosition = 44
+	ChannelTopFrontLeft       ChannelPosition = 45
+	ChannelTopFrontRight      ChannelPosition = 46
+	ChannelTopFrontCenter     ChannelPosition = 47
+	ChannelTopRearLeft        ChannelPosition = 48
+	ChannelTopRearRight       ChannelPosition = 49
+	ChannelTopRearCenter      ChannelPosition = 50
+)
+
+var channelPositionNames = map[ChannelPosition]string{
+	ChannelMono:               "mono",
+	ChannelFrontLeft:          "front-left",
+	ChannelFrontRight:         "front-right",
+	ChannelFrontCenter:        "front-center",
+	ChannelRearCenter:         "rear-center",
+	ChannelRearLeft:           "rear-left",
+	ChannelRearRight:          "rear-right",
+	ChannelLFE:                "lfe",
+	ChannelFrontLeftOfCenter:  "front-left-of-center",
+	ChannelFrontRightOfCenter: "front-right-of-center",
+	ChannelSideLeft:           "side-left",
+	ChannelSideRight:          "side-right",
+	ChannelTopCenter:          "top-center",
+	ChannelTopFrontLeft:       "top-front-left",
+	ChannelTopFrontRight:      "top-front-right",
+	ChannelTopFrontCenter:     "top-front-center",
+	ChannelTopRearLeft:        "top-rear-left",
+	ChannelTopRearRight:       "top-rear-right",
+	ChannelTopRearCenter:      "top-rear-center",
+}
+
+// String returns the position name used by PulseAudio, e.g. "front-left".
+func (p ChannelPosition) String() string {
+	if p >= ChannelAux0 && p <= ChannelAux31 {
+		return fmt.Sprintf("aux%d", p-ChannelAux0)
+	}
+	if name, ok := channelPositionNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("UnknownValue(%d)", p)
+}
+
+// ChannelMap holds the position of every channel of a stream or device.
 type ChannelMap []byte
 
+// ChannelMapMono returns the channel map of a single channel stream.
+func ChannelMapMono() ChannelMap {
+	return ChannelMap{byte(ChannelMono)}
+}
+
+// ChannelMapStereo returns the standard two channel map.
+func ChannelMapStereo() ChannelMap {
+	return ChannelMap{byte(ChannelFrontLeft), byte(ChannelFrontRight)}
+}
+
+// ChannelMapSurround51 returns the standard 5.1 channel map ("surround-51").
+func ChannelMapSurround51() ChannelMap {
+	return ChannelMap{
+		byte(ChannelFrontLeft), byte(ChannelFrontRight),
+		byte(ChannelRearLeft), byte(ChannelRearRight),
+		byte(ChannelFrontCenter), byte(ChannelLFE),
+	}
+}
+
+// ChannelMapSurround71 returns the standard 7.1 channel map ("surround-71").
+func ChannelMapSurround71() ChannelMap {
+	return ChannelMap{
+		byte(ChannelFrontLeft), byte(ChannelFrontRight),
+		byte(ChannelRearLeft), byte(ChannelRearRight),
+		byte(ChannelFrontCenter), byte(ChannelLFE),
+		byte(ChannelSideLeft), byte(ChannelSideRight),
+	}
+}
+
+// defaultChannelMap returns the standard map for the number of channels, falling back
+// to auxiliary positions for uncommon channel counts.
+func defaultChannelMap(channels byte) ChannelMap {
+	switch channels {
+	case 1:
+		return ChannelMapMono()
+	case 2:
+		return ChannelMapStereo()
+	case 6:
+		return ChannelMapSurround51()
+	case 8:
+		return ChannelMapSurround71()
+	}
+	m := make(ChannelMap, channels)
+	for i := range m {
+		m[i] = byte(ChannelAux0) + byte(i)
+	}
+	return m
+}
+
+// Positions returns the channel positions in channel order, matching the indices of a CVolume.
+func (m ChannelMap) Positions() []ChannelPosition {
+	positions := make([]ChannelPosition, len(m))
+	for i, p := range m {
+		positions[i] = ChannelPosition(p)
+	}
+	return positions
+}
+
+// String renders the map like pactl does, e.g. "front-left,front-right".
+func (m ChannelMap) String() string {
+	names := make([]string, len(m))
+	for i, p := range m.Positions() {
+		names[i] = p.String()
+	}
+	return strings.Join(names, ",")
+}
+
 func (m *ChannelMap) ReadFrom(r io.Reader) (int64, error) {
 	var n byte
 	err := bread(r, channelMapTag, &n)
@@ -185,14 +687,87 @@ func (m *ChannelMap) ReadFrom(r io.Reader) (int64, error) {
 	return 0, err
 }
 
+// SampleFormat is the encoding of a single audio sample.
+type SampleFormat byte
+
+const (
+	SampleU8        SampleFormat = 0
+	SampleALaw      SampleFormat = 1
+	SampleULaw      SampleFormat = 2
+	SampleS16LE     SampleFormat = 3
+	SampleS16BE     SampleFormat = 4
+	SampleFloat32LE SampleFormat = 5
+	SampleFloat32BE SampleFormat = 6
+	SampleS32LE     SampleFormat = 7
+	SampleS32BE     SampleFormat = 8
+	SampleS24LE     SampleFormat = 9
+	SampleS24BE     SampleFormat = 10
+	SampleS24_32LE  SampleFormat = 11
+	SampleS24_32BE  SampleFormat = 12
+	SampleInvalid   SampleFormat = 0xff
+)
+
+var sampleFormatNames = []string{
+	"u8",
+	"aLaw",
+	"uLaw",
+	"s16le",
+	"s16be",
+	"float32le",
+	"float32be",
+	"s32le",
+	"s32be",
+	"s24le",
+	"s24be",
+	"s24-32le",
+	"s24-32be",
+}
+
+// String returns the format name used by PulseAudio, e.g. "s16le".
+func (f SampleFormat) String() string {
+	if int(f) < len(sampleFormatNames) {
+		return sampleFormatNames[f]
+	}
+	if f == SampleInvalid {
+		return "invalid"
+	}
+	return fmt.Sprintf("UnknownValue(%d)", f)
+}
+
 type SampleSpec struct {
-	Format   byte
+	Format   SampleFormat
 	Channels byte
 	Rate     uint32
 }
 
+// String renders the sample spec like pactl does, e.g. "s16le 2ch 44100Hz".
+func (s SampleSpec) String() string {
+	return fmt.Sprintf("%s %dch %dHz", s.Format, s.Channels, s.Rate)
+}
+
+// Limits of a valid sample spec, matching the server's.
+const (
+	maxSampleRate = 384000
+	maxChannels   = 32
+)
+
+// Valid checks the sample spec before it is sent to the server. The error matches
+// ErrInvalidArgument like the one the server would return.
+func (s SampleSpec) Valid() error {
+	if int(s.Format) >= len(sampleFormatNames) {
+		return fmt.Errorf("invalid sample format %s: %w", s.Format, ErrInvalidArgument)
+	}
+	if s.Rate < 1 || s.Rate > maxSampleRate {
+		return fmt.Errorf("invalid sample rate %d (1-%d): %w", s.Rate, maxSampleRate, ErrInvalidArgument)
+	}
+	if s.Channels < 1 || s.Channels > maxChannels {
+		return fmt.Errorf("invalid channel count %d (1-%d): %w", s.Channels, maxChannels, ErrInvalidArgument)
+	}
+	return nil
+}
+
 func (s *SampleSpec) ReadFrom(r io.Reader) (int64, error) {
-	return 0, bread(r, sampleSpecTag, &s.Format, &s.Channels, &s.Rate)
+	return 0, breadStruct(r, s, sampleSpecTag, &s.Format, &s.Channels, &s.Rate)
 }
 
 type Card struct {
@@ -206,6 +781,81 @@ type Card struct {
 	Ports         []Port
 }
 
+func (c *Card) ReadFrom(r io.Reader) (int64, error) {
+	var profileCount uint32
+	err := breadStruct(r, c,
+		uint32Tag, &c.Index,
+		stringTag, &c.Name,
+		uint32Tag, &c.Module,
+		stringTag, &c.Driver,
+		uint32Tag, &profileCount)
+	if err != nil {
+		return 0, err
+	}
+	c.Profiles = make(map[string]*Profile)
+	for i := uint32(0); i < profileCount; i++ {
+		var profile Profile
+		err = breadStruct(r, &profile,
+			stringTag, &profile.Name,
+			stringTag, &profile.Description,
+			uint32Tag, &profile.Nsinks,
+			uint32Tag, &profile.Nsources,
+			uint32Tag, &profile.Priority,
+			uint32Tag, &profile.Available)
+		if err != nil {
+			return 0, err
+		}
+		c.Profiles[profile.Name] = &profile
+	}
+	var portCount uint32
+	var activeProfileName string
+	err = breadStruct(r, c,
+		stringTag, &activeProfileName,
+		&c.PropList,
+		uint32Tag, &portCount)
+	if err != nil {
+		return 0, err
+	}
+	c.ActiveProfile = c.Profiles[activeProfileName]
+	c.Ports = make([]Port, portCount)
+	for i := uint32(0); i < portCount; i++ {
+		c.Ports[i].Card = c
+		err = breadStruct(r, c, &c.Ports[i])
+		if err != nil {
+			return 0, err
+		}
+	}
+	return 0, nil
+}
+
+// AvailableProfiles returns the profiles of the card which can be selected, highest priority
+// first. Profiles whose ports are unplugged, e.g. HDMI outputs without a display, are left out.
+func (c *Card) AvailableProfiles() []*Profile {
+	return c.filterProfiles(func(p *Profile) bool { return p.Available != 0 })
+}
+
+// ActiveOutputProfiles returns the available profiles of the card which have at least one sink,
+// highest priority first, e.g. to offer the outputs of a card in a profile picker.
+func (c *Card) ActiveOutputProfiles() []*Profile {
+	return c.filterProfiles(func(p *Profile) bool { return p.Available != 0 && p.Nsinks > 0 })
+}
+
+func (c *Card) filterProfiles(keep func(*Profile) bool) []*Profile {
+	var profiles []*Profile
+	for _, p := range c.Profiles {
+		if keep(p) {
+			profiles = append(profiles, p)
+		}
+	}
+	sort.Slice(profiles, func(i, j int) bool {
+		if profiles[i].Priority != profiles[j].Priority {
+			return profiles[i].Priority > profiles[j].Priority
+		}
+		return profiles[i].Name < profiles[j].Name
+	})
+	return profiles
+}
+
 type Profile struct {
 	Name, Description string
 	Nsinks, Nsources  uint32
@@ -218,14 +868,36 @@ type Port struct {
 	Name, Description string
 	Pririty           uint32
 	Available         uint32
-	Direction         byte
+	Direction         PortDirection
 	PropList          map[string]string
 	Profiles          []*Profile
 	LatencyOffset     int64
 }
 
+// PortDirection tells whether a card port plays or records audio.
+type PortDirection byte
+
+const (
+	PortDirectionOutput        PortDirection = 0x01
+	PortDirectionInput         PortDirection = 0x02
+	PortDirectionBidirectional               = PortDirectionOutput | PortDirectionInput
+)
+
+func (d PortDirection) String() string {
+	switch d {
+	case PortDirectionOutput:
+		return "output"
+	case PortDirectionInput:
+		return "input"
+	case PortDirectionBidirectional:
+		return "bidirectional"
+	default:
+		return fmt.Sprintf("UnknownValue(%d)", d)
+	}
+}
+
 func (p *Port) ReadFrom(r io.Reader) (int64, error) {
-	err := bread(r,
+	err := breadStruct(r, p,
 		stringTag, &p.Name,
 		stringTag, &p.Description,
 		uint32Tag, &p.Pririty,
@@ -236,19 +908,19 @@ func (p *Port) ReadFrom(r io.Reader) (int64, error) {
 		return 0, err
 	}
 	var portProfileCount uint32
-	err = bread(r, uint32Tag, &portProfileCount)
+	err = breadStruct(r, p, uint32Tag, &portProfileCount)
 	if err != nil {
 		return 0, err
 	}
 	for j := uint32(0); j < portProfileCount; j++ {
 		var profileName string
-		err = bread(r, stringTag, &profileName)
+		err = breadStruct(r, p, stringTag, &profileName)
 		if err != nil {
 			return 0, err
 		}
 		p.Profiles = append(p.Profiles, p.Card.Profiles[profileName])
 	}
-	return 0, bread(r, int64Tag, &p.LatencyOffset)
+	return 0, breadStruct(r, p, int64Tag, &p.LatencyOffset)
 }
 
 func (c *Client) Sinks(ctx context.Context) ([]Sink, error) {
@@ -259,7 +931,7 @@ func (c *Client) Sinks(ctx context.Context) ([]Sink, error) {
 	var sinks []Sink
 	for b.Len() > 0 {
 		var sink Sink
-		err = bread(b, &sink)
+		err = decodeReply(commandGetSinkInfoList, b, &sink)
 		if err != nil {
 			return nil, err
 		}
@@ -268,6 +940,253 @@ func (c *Client) Sinks(ctx context.Context) ([]Sink, error) {
 	return sinks, nil
 }
 
+func (c *Client) Sources(ctx context.Context) ([]Source, error) {
+	b, err := c.request(ctx, commandGetSourceInfoList)
+	if err != nil {
+		return nil, err
+	}
+	var sources []Source
+	for b.Len() > 0 {
+		var source Source
+		err = decodeReply(commandGetSourceInfoList, b, &source)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, source)
+	}
+	return sources, nil
+}
+
+func (c *Client) SinkInputs(ctx context.Context) ([]SinkInput, error) {
+	b, err := c.request(ctx, commandGetSinkInputInfoList)
+	if err != nil {
+		return nil, err
+	}
+	var inputs []SinkInput
+	for b.Len() > 0 {
+		var input SinkInput
+		err = decodeReply(commandGetSinkInputInfoList, b, &input)
+		if err != nil {
+			return nil, err
+		}
+		inputs = append(inputs, input)
+	}
+	return inputs, nil
+}
+
+// GetSinkByName returns the current state of a single sink.
+func (c *Client) GetSinkByName(ctx context.Context, name string) (*Sink, error) {
+	b, err := c.request(ctx, commandGetSinkInfo, uint32Tag, uint32(0xffffffff), stringTag, []byte(name), byte(0))
+	if err != nil {
+		return nil, err
+	}
+	var sink Sink
+	err = decodeReply(commandGetSinkInfo, b, &sink)
+	if err != nil {
+		return nil, err
+	}
+	return &sink, nil
+}
+
+// GetSourceByName returns the current state of a single source.
+func (c *Client) GetSourceByName(ctx context.Context, name string) (*Source, error) {
+	b, err := c.request(ctx, commandGetSourceInfo, uint32Tag, uint32(0xffffffff), stringTag, []byte(name), byte(0))
+	if err != nil {
+		return nil, err
+	}
+	var source Source
+	err = decodeReply(commandGetSourceInfo, b, &source)
+	if err != nil {
+		return nil, err
+	}
+	return &source, nil
+}
+
+// DefaultSinkInfo returns the current state of the sink named by PULSE_SINK if set, the default
+// sink of the server otherwise, which is the sink the volume and mute operations act on.
+func (c *Client) DefaultSinkInfo(ctx context.Context) (*Sink, error) {
+	if c == nil {
+		return nil, ErrClientDisabled
+	}
+	var sink *Sink
+	err := c.retryTargetSink(ctx, func(name string) error {
+		var err error
+		sink, err = c.GetSinkByName(ctx, name)
+		return err
+	})
+	return sink, err
+}
+
+// DefaultSourceInfo returns the current state of the source named by PULSE_SOURCE if set, the
+// default source of the server otherwise.
+func (c *Client) DefaultSourceInfo(ctx context.Context) (*Source, error) {
+	if c == nil {
+		return nil, ErrClientDisabled
+	}
+	name, err := c.targetSource(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return c.GetSourceByName(ctx, name)
+}
+
+// MonitorSource returns the monitor source of the named sink, which records what the sink plays,
+// e.g. to capture the system audio with Record.
+func (c *Client) MonitorSource(ctx context.Context, sinkName string) (*Source, error) {
+	sink, err := c.GetSinkByName(ctx, sinkName)
+	if err != nil {
+		return nil, err
+	}
+	if sink.MonitorSourceIndex == 0xffffffff {
+		return nil, fmt.Errorf("sink %s has no monitor source: %w", sinkName, ErrNoSuchEntity)
+	}
+	return c.getSourceByIndex(ctx, sink.MonitorSourceIndex)
+}
+
+func (c *Client) getSinkByIndex(ctx context.Context, index uint32) (*Sink, error) {
+	b, err := c.request(ctx, commandGetSinkInfo, uint32Tag, index, stringNullTag)
+	if err != nil {
+		return nil, err
+	}
+	var sink Sink
+	err = decodeReply(commandGetSinkInfo, b, &sink)
+	if err != nil {
+		return nil, err
+	}
+	return &sink, nil
+}
+
+func (c *Client) getSinkInputByIndex(ctx context.Context, index uint32) (*SinkInput, error) {
+	b, err := c.request(ctx, commandGetSinkInputInfo, uint32Tag, index)
+	if err != nil {
+		return nil, err
+	}
+	var input SinkInput
+	err = decodeReply(commandGetSinkInputInfo, b, &input)
+	if err != nil {
+		return nil, err
+	}
+	return &input, nil
+}
+
+func (c *Client) getSourceByIndex(ctx context.Context, index uint32) (*Source, error) {
+	b, err := c.request(ctx, commandGetSourceInfo, uint32Tag, index, stringNullTag)
+	if err != nil {
+		return nil, err
+	}
+	var source Source
+	err = decodeReply(commandGetSourceInfo, b, &source)
+	if err != nil {
+		return nil, err
+	}
+	return &source, nil
+}
+
+// WaitForSink returns the named sink once it exists, e.g. after loading a module which creates
+// it. The sink is looked up again on every server change until ctx is done.
+func (c *Client) WaitForSink(ctx context.Context, name string) (*Sink, error) {
+	if c == nil {
+		return nil, ErrClientDisabled
+	}
+	// the subscription ends with the wait
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// subscribe first so that a sink created while looking it up isn't missed
+	updates, err := c.SubscribeEvents(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		sink, err := c.GetSinkByName(ctx, name)
+		if err == nil {
+			return sink, nil
+		}
+		if !errors.Is(err, ErrNoSuchEntity) {
+			return nil, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case _, ok := <-updates:
+			if !ok {
+				if ctx.Err() != nil {
+					return nil, ctx.Err()
+				}
+				return nil, ErrClientClosed
+			}
+		}
+	}
+}
+
+// ErrAmbiguousSink is returned by FindSink when the query matches several sinks.
+var ErrAmbiguousSink = errors.New("query matches several sinks")
+
+// FindSink looks up a sink by a user supplied query such as "headphones" or "HDMI". The query
+// is compared case-insensitively with the name, the description and the device.description
+// property of every sink. A sink matching exactly is preferred over sinks containing the query.
+func (c *Client) FindSink(ctx context.Context, query string) (*Sink, error) {
+	sinks, err := c.Sinks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if query == "" {
+		return nil, fmt.Errorf("empty sink query: %w", ErrInvalidArgument)
+	}
+	q := strings.ToLower(query)
+	var exact, partial []int
+	for i := range sinks {
+		s := &sinks[i]
+		isExact, isPartial := false, false
+		for _, label := range []string{s.Name, s.Description, s.PropList["device.description"]} {
+			label = strings.ToLower(label)
+			isExact = isExact || label == q
+			isPartial = isPartial || strings.Contains(label, q)
+		}
+		if isExact {
+			exact = append(exact, i)
+		} else if isPartial {
+			partial = append(partial, i)
+		}
+	}
+	matches := exact
+	if len(matches) == 0 {
+		matches = partial
+	}
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("no sink matches %q: %w", query, ErrNoSuchEntity)
+	case 1:
+		return &sinks[matches[0]], nil
+	}
+	names := make([]string, len(matches))
+	for i, m := range matches {
+		names[i] = sinks[m].Name
+	}
+	return nil, fmt.Errorf("%q matches %s: %w", query, strings.Join(names, ", "), ErrAmbiguousSink)
+}
+
+// SinkLatency queries the server for the current latency of the named sink.
+func (c *Client) SinkLatency(ctx context.Context, name string) (time.Duration, error) {
+	sink, err := c.GetSinkByName(ctx, name)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(sink.Latency) * time.Microsecond, nil
+}
+
+// MeasureLatency estimates the delay added between writing audio and hearing it on the named
+// sink: the latency reported by the sink plus half the round trip of the query, which
+// approximates the transport delay to the server.
+func (c *Client) MeasureLatency(ctx context.Context, sinkName string) (time.Duration, error) {
+	start := time.Now()
+	sink, err := c.GetSinkByName(ctx, sinkName)
+	if err != nil {
+		return 0, err
+	}
+	rtt := time.Since(start)
+	return time.Duration(sink.Latency)*time.Microsecond + rtt/2, nil
+}
+
 func (c *Client) Modules(ctx context.Context) ([]Module, error) {
 	b, err := c.request(ctx, commandGetModuleInfoList)
 	if err != nil {
@@ -276,7 +1195,7 @@ func (c *Client) Modules(ctx context.Context) ([]Module, error) {
 	var modules []Module
 	for b.Len() > 0 {
 		var module Module
-		err = bread(b, &module)
+		err = decodeReply(commandGetModuleInfoList, b, &module)
 		if err != nil {
 			return nil, err
 		}
@@ -293,51 +1212,44 @@ func (c *Client) Cards(ctx context.Context) ([]Card, error) {
 	var cards []Card
 	for b.Len() > 0 {
 		var card Card
-		var profileCount uint32
-		err := bread(b,
-			uint32Tag, &card.Index,
-			stringTag, &card.Name,
-			uint32Tag, &card.Module,
-			stringTag, &card.Driver,
-			uint32Tag, &profileCount)
-		if err != nil {
-			return nil, err
-		}
-		card.Profiles = make(map[string]*Profile)
-		for i := uint32(0); i < profileCount; i++ {
-			var profile Profile
-			err = bread(b,
-				stringTag, &profile.Name,
-				stringTag, &profile.Description,
-				uint32Tag, &profile.Nsinks,
-				uint32Tag, &profile.Nsources,
-				uint32Tag, &profile.Priority,
-				uint32Tag, &profile.Available)
-			if err != nil {
-				return nil, err
-			}
-			card.Profiles[profile.Name] = &profile
-		}
-		var portCount uint32
-		var activeProfileName string
-		err = bread(b,
-			stringTag, &activeProfileName,
-			&card.PropList,
-			uint32Tag, &portCount)
+		err = decodeReply(commandGetCardInfoList, b, &card)
 		if err != nil {
 			return nil, err
 		}
-		card.ActiveProfile = card.Profiles[activeProfileName]
-		card.Ports = make([]Port, portCount)
-		for i := uint32(0); i < portCount; i++ {
-			card.Ports[i].Card = &card
-			err = bread(b, &card.Ports[i])
-		}
 		cards = append(cards, card)
 	}
+	// point the ports to the cards in the slice rather than to the decoded copies
+	for i := range cards {
+		for j := range cards[i].Ports {
+			cards[i].Ports[j].Card = &cards[i]
+		}
+	}
 	return cards, nil
 }
 
+// GetCard returns a single card without fetching the whole card list.
+func (c *Client) GetCard(ctx context.Context, index uint32) (*Card, error) {
+	return c.getCard(ctx, uint32Tag, index, stringNullTag)
+}
+
+// GetCardByName returns a single card without fetching the whole card list.
+func (c *Client) GetCardByName(ctx context.Context, name string) (*Card, error) {
+	return c.getCard(ctx, uint32Tag, uint32(0xffffffff), stringTag, []byte(name), byte(0))
+}
+
+func (c *Client) getCard(ctx context.Context, args ...interface{}) (*Card, error) {
+	b, err := c.request(ctx, commandGetCardInfo, args...)
+	if err != nil {
+		return nil, err
+	}
+	var card Card
+	err = decodeReply(commandGetCardInfo, b, &card)
+	if err != nil {
+		return nil, err
+	}
+	return &card, nil
+}
+
 func (c *Client) SetCardProfile(ctx context.Context, cardIndex uint32, profileName string) error {
 	_, err := c.request(ctx, commandSetCardProfile,
 		uint32Tag, cardIndex,
@@ -346,21 +1258,216 @@ func (c *Client) SetCardProfile(ctx context.Context, cardIndex uint32, profileNa
 	return err
 }
 
+// findPort looks up the named port of the named card.
+func (c *Client) findPort(ctx context.Context, cardName, portName string) (*Port, error) {
+	cards, err := c.Cards(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, card := range cards {
+		if card.Name != cardName {
+			continue
+		}
+		for i := range card.Ports {
+			if card.Ports[i].Name == portName {
+				return &card.Ports[i], nil
+			}
+		}
+		return nil, fmt.Errorf("PulseAudio error: card %s has no port %s", cardName, portName)
+	}
+	return nil, fmt.Errorf("PulseAudio error: card %s not found", cardName)
+}
+
+// PortLatencyOffset returns the latency offset configured for a port of a card.
+func (c *Client) PortLatencyOffset(ctx context.Context, cardName, portName string) (time.Duration, error) {
+	port, err := c.findPort(ctx, cardName, portName)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(port.LatencyOffset) * time.Microsecond, nil
+}
+
+// SetPortLatencyOffset sets the latency offset of a port of a card, e.g. to calibrate the
+// audio delay of a home theater output. The offset has a resolution of one microsecond.
+func (c *Client) SetPortLatencyOffset(ctx context.Context, cardName, portName string, offset time.Duration) error {
+	_, err := c.findPort(ctx, cardName, portName)
+	if err != nil {
+		return err
+	}
+	_, err = c.request(ctx, commandSetPortLatencyOffset,
+		uint32Tag, uint32(0xffffffff),
+		stringTag, []byte(cardName), byte(0),
+		stringTag, []byte(portName), byte(0),
+		int64Tag, offset.Microseconds())
+	return err
+}
+
+// ErrProfileUnavailable is returned when selecting a card profile which can't be used,
+// e.g. because the ports it requires are unplugged.
+var ErrProfileUnavailable = errors.New("profile is not available")
+
+// SetCardProfileByIndex activates one of the profiles returned in Card.Profiles.
+func (c *Client) SetCardProfileByIndex(ctx context.Context, cardIndex uint32, profile *Profile) error {
+	if profile == nil {
+		return fmt.Errorf("PulseAudio error: no profile given for card %d", cardIndex)
+	}
+	if profile.Available == 0 {
+		return fmt.Errorf("could not set profile %s of card %d: %w", profile.Name, cardIndex, ErrProfileUnavailable)
+	}
+	return c.SetCardProfile(ctx, cardIndex, profile.Name)
+}
+
+// deviceRestoreSaveFormats is the module-device-restore extension command which stores the
+// formats of a device.
+const deviceRestoreSaveFormats = 5
+
+// SetSinkFormats sets the formats the named sink accepts, e.g. to enable AC3 or DTS passthrough
+// on an S/PDIF or HDMI sink. The formats are stored by module-device-restore, which needs to be loaded.
+func (c *Client) SetSinkFormats(ctx context.Context, sinkName string, formats []FormatInfo) error {
+	if len(formats) > 0xff {
+		return fmt.Errorf("PulseAudio error: too many formats (%d)", len(formats))
+	}
+	sink, err := c.GetSinkByName(ctx, sinkName)
+	if err != nil {
+		return err
+	}
+	args := []interface{}{
+		uint32Tag, uint32(0xffffffff),
+		stringTag, []byte("module-device-restore"), byte(0),
+		uint32Tag, uint32(deviceRestoreSaveFormats),
+		uint32Tag, uint32(0), // device type sink
+		uint32Tag, sink.Index,
+		uint8Tag, byte(len(formats)),
+	}
+	for _, f := range formats {
+		props := f.PropList
+		if props == nil {
+			props = map[string]string{}
+		}
+		args = append(args, formatInfoTag, uint8Tag, f.Encoding, props)
+	}
+	_, err = c.request(ctx, commandExtension, args...)
+	return err
+}
+
 func (c *Client) setDefaultSink(ctx context.Context, sinkName string) error {
 	_, err := c.request(ctx, commandSetDefaultSink,
 		stringTag, []byte(sinkName), byte(0))
+	c.invalidateServerInfo()
+	return err
+}
+
+// ServerStats holds the memory counters of the server.
+type ServerStats struct {
+	// MemblockTotal is the number of memory blocks currently in use
+	MemblockTotal     uint32
+	MemblockTotalSize uint32
+	// MemblockAllocated is the number of memory blocks allocated during the lifetime of the server
+	MemblockAllocated     uint32
+	MemblockAllocatedSize uint32
+	// ScacheSize is the total size of the samples in the sample cache
+	ScacheSize uint32
+}
+
+func (s *ServerStats) ReadFrom(r io.Reader) (int64, error) {
+	return 0, breadStruct(r, s,
+		uint32Tag, &s.MemblockTotal,
+		uint32Tag, &s.MemblockTotalSize,
+		uint32Tag, &s.MemblockAllocated,
+		uint32Tag, &s.MemblockAllocatedSize,
+		uint32Tag, &s.ScacheSize)
+}
+
+// Stat returns the memory counters of the server, e.g. to detect leaks in long running servers.
+func (c *Client) Stat(ctx context.Context) (*ServerStats, error) {
+	if c == nil {
+		return nil, ErrClientDisabled
+	}
+	b, err := c.request(ctx, commandStat)
+	if err != nil {
+		return nil, err
+	}
+	var stats ServerStats
+	err = decodeReply(commandStat, b, &stats)
+	if err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// Ping checks that the server answers requests. It sends the cheapest request available and
+// doesn't change any server state.
+func (c *Client) Ping(ctx context.Context) error {
+	if c == nil {
+		return ErrClientDisabled
+	}
+	_, err := c.request(ctx, commandStat)
 	return err
 }
 
+// ServerInfo returns the server information, including the default sink and source. The
+// result may come from the cache enabled with WithServerInfoCacheTTL.
 func (c *Client) ServerInfo(ctx context.Context) (*Server, error) {
+	cached, gen := c.cachedServerInfo()
+	if cached != nil {
+		return cached, nil
+	}
 	r, err := c.request(ctx, commandGetServerInfo)
 	if err != nil {
 		return nil, err
 	}
 	var s Server
-	err = bread(r, &s)
+	err = decodeReply(commandGetServerInfo, r, &s)
 	if err != nil {
 		return nil, err
 	}
+	c.cacheServerInfo(&s, gen)
 	return &s, nil
 }
+
+// cachedServerInfo returns a copy of the cached server information if it is still fresh, and the
+// invalidation generation to pass to cacheServerInfo otherwise.
+func (c *Client) cachedServerInfo() (*Server, uint64) {
+	if c.serverInfoTTL <= 0 {
+		return nil, 0
+	}
+	c.serverInfoMu.Lock()
+	defer c.serverInfoMu.Unlock()
+	if c.serverInfo == nil || time.Since(c.serverInfoAt) > c.serverInfoTTL {
+		return nil, c.serverInfoGen
+	}
+	s := *c.serverInfo
+	return &s, c.serverInfoGen
+}
+
+// cacheServerInfo caches server information requested at generation gen, unless the cache was
+// invalidated since, in which case the information may already be outdated.
+func (c *Client) cacheServerInfo(s *Server, gen uint64) {
+	if c.serverInfoTTL <= 0 {
+		return
+	}
+	cached := *s
+	c.serverInfoMu.Lock()
+	defer c.serverInfoMu.Unlock()
+	if gen != c.serverInfoGen {
+		return
+	}
+	c.serverInfo = &cached
+	c.serverInfoAt = time.Now()
+}
+
+// invalidateServerInfo drops the cached server information and any reply still on its way.
+func (c *Client) invalidateServerInfo() {
+	c.serverInfoMu.Lock()
+	defer c.serverInfoMu.Unlock()
+	c.serverInfo = nil
+	c.serverInfoGen++
+}
+
+// dropServerInfo drops the cached server information when connecting. Pending requests are only
+// sent on the new connection, so their replies may still be cached.
+func (c *Client) dropServerInfo() {
+	c.serverInfoMu.Lock()
+	defer c.serverInfoMu.Unlock()
+	c.serverInfo = nil
+}
@@ -0,0 +1,561 @@
+package pulseaudio
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSinkStateString(t *testing.T) {
+	assert.Equal(t, "RUNNING", SinkStateRunning.String())
+	assert.Equal(t, "IDLE", SinkStateIdle.String())
+	assert.Equal(t, "SUSPENDED", SinkStateSuspended.String())
+	assert.Equal(t, "INVALID", SinkStateInvalid.String())
+	assert.Equal(t, "UnknownValue(7)", SinkState(7).String())
+}
+
+func TestSinkFlags(t *testing.T) {
+	f := SinkHardware | SinkDecibelVolume | SinkLatency
+	assert.True(t, f.Has(SinkHardware))
+	assert.True(t, f.Has(SinkHardware|SinkLatency))
+	assert.False(t, f.Has(SinkHwVolumeCtrl))
+	assert.Equal(t, "HARDWARE DECIBEL_VOLUME LATENCY", f.String())
+	assert.Equal(t, "", SinkFlags(0).String())
+	assert.Equal(t, "NETWORK 0x1000", (SinkNetwork | 0x1000).String())
+	assert.Equal(t, f, parseSinkFlags(f.String()))
+}
+
+func TestSourceFlags(t *testing.T) {
+	f := SourceHardware | SourceDecibelVolume | SourceDynamicLatency
+	assert.True(t, f.Has(SourceHardware|SourceDynamicLatency))
+	assert.False(t, f.Has(SourceFlatVolume))
+	assert.Equal(t, "HARDWARE DECIBEL_VOLUME DYNAMIC_LATENCY", f.String())
+	assert.Equal(t, "", SourceFlags(0).String())
+	assert.Equal(t, "NETWORK 0x1000", (SourceNetwork | 0x1000).String())
+	assert.Equal(t, f, parseSourceFlags(f.String()))
+}
+
+func TestSinkVolumeCapabilities(t *testing.T) {
+	software := Sink{Flags: SinkHardware | SinkDecibelVolume | SinkLatency}
+	assert.False(t, software.HasHardwareVolume())
+	assert.True(t, software.SupportsDecibelVolume())
+
+	hardware := Sink{Flags: SinkHardware | SinkHwVolumeCtrl}
+	assert.True(t, hardware.HasHardwareVolume())
+	assert.False(t, hardware.SupportsDecibelVolume())
+}
+
+func TestSampleFormatString(t *testing.T) {
+	assert.Equal(t, "u8", SampleU8.String())
+	assert.Equal(t, "s16le", SampleS16LE.String())
+	assert.Equal(t, "float32le", SampleFloat32LE.String())
+	assert.Equal(t, "s24-32be", SampleS24_32BE.String())
+	assert.Equal(t, "invalid", SampleInvalid.String())
+	assert.Equal(t, "UnknownValue(13)", SampleFormat(13).String())
+}
+
+func TestFormatEncodingString(t *testing.T) {
+	assert.Equal(t, "pcm", FormatEncodingPCM.String())
+	assert.Equal(t, "ac3-iec61937", FormatEncodingAC3.String())
+	assert.Equal(t, "dtshd-iec61937", FormatEncodingDTSHD.String())
+	assert.Equal(t, "invalid", FormatEncodingInvalid.String())
+	assert.Equal(t, "UnknownValue(9)", FormatEncoding(9).String())
+
+	var labels []string
+	for _, e := range []FormatEncoding{FormatEncodingPCM, FormatEncodingAC3, FormatEncodingDTS} {
+		labels = append(labels, e.Label())
+	}
+	assert.Equal(t, []string{"PCM", "AC3", "DTS"}, labels)
+	assert.Equal(t, "DTS-HD", FormatEncodingDTSHD.Label())
+	assert.Equal(t, "invalid", FormatEncodingInvalid.Label())
+	assert.Equal(t, "UnknownValue(9)", FormatEncoding(9).Label())
+}
+
+func TestSetSinkFormats(t *testing.T) {
+	srv := newFakeServer(t)
+	type saved struct {
+		sink    uint32
+		formats []FormatInfo
+	}
+	stored := make(chan saved, 1)
+	srv.handle(commandExtension, func(req *bytes.Buffer) ([]interface{}, uint32) {
+		var module, subcommand, deviceType uint32
+		var name string
+		var s saved
+		var n byte
+		err := bread(req, uint32Tag, &module, stringTag, &name, uint32Tag, &subcommand,
+			uint32Tag, &deviceType, uint32Tag, &s.sink, uint8Tag, &n)
+		if err != nil || name != "module-device-restore" || subcommand != deviceRestoreSaveFormats || deviceType != 0 {
+			return nil, 3 // invalid argument
+		}
+		for i := byte(0); i < n; i++ {
+			var f FormatInfo
+			if err := bread(req, &f); err != nil {
+				return nil, 3
+			}
+			s.formats = append(s.formats, f)
+		}
+		stored <- s
+		return nil, 0
+	})
+	c := newFakeClient(t, srv)
+
+	formats := []FormatInfo{
+		{Encoding: FormatEncodingPCM, PropList: map[string]string{}},
+		{Encoding: FormatEncodingAC3, PropList: map[string]string{}},
+		{Encoding: FormatEncodingDTS},
+	}
+	require.NoError(t, c.SetSinkFormats(context.Background(), "fake", formats))
+	s := <-stored
+	assert.Equal(t, uint32(0), s.sink)
+	require.Len(t, s.formats, 3)
+	assert.Equal(t, FormatEncodingAC3, s.formats[1].Encoding)
+	assert.Equal(t, FormatEncodingDTS, s.formats[2].Encoding)
+
+	assert.ErrorIs(t, c.SetSinkFormats(context.Background(), "missing", formats), ErrNoSuchEntity)
+}
+
+func TestSampleSpecValid(t *testing.T) {
+	assert.NoError(t, SampleSpec{Format: SampleS16LE, Channels: 2, Rate: 44100}.Valid())
+	assert.NoError(t, SampleSpec{Format: SampleS24_32BE, Channels: 32, Rate: 384000}.Valid())
+	for _, spec := range []SampleSpec{
+		{Format: SampleS16LE, Channels: 2, Rate: 0},
+		{Format: SampleS16LE, Channels: 2, Rate: 384001},
+		{Format: SampleS16LE, Channels: 0, Rate: 44100},
+		{Format: SampleS16LE, Channels: 33, Rate: 44100},
+		{Format: 13, Channels: 2, Rate: 44100},
+		{Format: SampleInvalid, Channels: 2, Rate: 44100},
+	} {
+		assert.ErrorIs(t, spec.Valid(), ErrInvalidArgument, "%s", spec)
+	}
+
+	// invalid specs are rejected without a connection
+	c := NewClient(Opts{})
+	_, err := c.Play(context.Background(), "", SampleSpec{Format: SampleS16LE, Channels: 2})
+	assert.ErrorIs(t, err, ErrInvalidArgument)
+	_, err = c.Record(context.Background(), "", SampleSpec{Format: SampleS16LE, Rate: 44100})
+	assert.ErrorIs(t, err, ErrInvalidArgument)
+	_, err = c.CreateNullSink(context.Background(), "sink", "", SampleSpec{Rate: 44100})
+	assert.ErrorIs(t, err, ErrInvalidArgument)
+}
+
+func TestSampleSpecString(t *testing.T) {
+	assert.Equal(t, "s16le 2ch 44100Hz", SampleSpec{Format: SampleS16LE, Channels: 2, Rate: 44100}.String())
+	assert.Equal(t, "s16le 4ch 44100Hz", SampleSpec{Format: SampleS16LE, Channels: 4, Rate: 44100}.String())
+}
+
+func TestChannelMap(t *testing.T) {
+	m := ChannelMap{1, 2, 5, 6}
+	assert.Equal(t, []ChannelPosition{ChannelFrontLeft, ChannelFrontRight, ChannelRearLeft, ChannelRearRight}, m.Positions())
+	assert.Equal(t, "front-left,front-right,rear-left,rear-right", m.String())
+	assert.Equal(t, "front-left,front-right", ChannelMap{1, 2}.String())
+	assert.Equal(t, "mono", ChannelMap{0}.String())
+	assert.Equal(t, "", ChannelMap{}.String())
+}
+
+func TestChannelPositionString(t *testing.T) {
+	assert.Equal(t, "lfe", ChannelLFE.String())
+	assert.Equal(t, "aux0", ChannelAux0.String())
+	assert.Equal(t, "aux31", ChannelAux31.String())
+	assert.Equal(t, "top-rear-center", ChannelTopRearCenter.String())
+	assert.Equal(t, "UnknownValue(51)", ChannelPosition(51).String())
+}
+
+func TestChannelMapConstructors(t *testing.T) {
+	for expected, m := range map[string]ChannelMap{
+		"mono":                   ChannelMapMono(),
+		"front-left,front-right": ChannelMapStereo(),
+		"front-left,front-right,rear-left,rear-right,front-center,lfe":                      ChannelMapSurround51(),
+		"front-left,front-right,rear-left,rear-right,front-center,lfe,side-left,side-right": ChannelMapSurround71(),
+	} {
+		var b bytes.Buffer
+		require.NoError(t, bwrite(&b, m))
+		var decoded ChannelMap
+		require.NoError(t, bread(&b, &decoded))
+		assert.Equal(t, m, decoded)
+		assert.Equal(t, expected, decoded.String())
+		assert.Zero(t, b.Len())
+	}
+}
+
+func TestSinkLatency(t *testing.T) {
+	srv := newFakeServer(t)
+	c := newFakeClient(t, srv)
+	ctx := context.Background()
+
+	srv.update("fake", func(sink *Sink) { sink.Latency = 15857 })
+	latency, err := c.SinkLatency(ctx, "fake")
+	require.NoError(t, err)
+	assert.Equal(t, 15857*time.Microsecond, latency)
+
+	srv.update("fake", func(sink *Sink) { sink.Latency = 25000 })
+	latency, err = c.SinkLatency(ctx, "fake")
+	require.NoError(t, err)
+	assert.Equal(t, 25*time.Millisecond, latency)
+
+	_, err = c.SinkLatency(ctx, "missing")
+	var paErr *Error
+	assert.True(t, errors.As(err, &paErr))
+}
+
+func TestFindSink(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.sinks = append(srv.sinks,
+		Sink{
+			Index:       1,
+			Name:        "alsa_output.pci-0000_00_1f.3.hdmi-stereo",
+			Description: "Built-in Audio Digital Stereo (HDMI)",
+			SampleSpec:  SampleSpec{Format: SampleS16LE, Channels: 2, Rate: 48000},
+			ChannelMap:  ChannelMap{1, 2},
+			CVolume:     CVolume{VolumeNorm, VolumeNorm},
+			PropList:    map[string]string{"device.description": "TV"},
+		},
+		Sink{
+			Index:       2,
+			Name:        "alsa_output.pci-0000_00_1f.3.analog-stereo",
+			Description: "Built-in Audio Analog Stereo",
+			SampleSpec:  SampleSpec{Format: SampleS16LE, Channels: 2, Rate: 48000},
+			ChannelMap:  ChannelMap{1, 2},
+			CVolume:     CVolume{VolumeNorm, VolumeNorm},
+			PropList:    map[string]string{"device.description": "Headphones"},
+		})
+	c := newFakeClient(t, srv)
+	ctx := context.Background()
+
+	for query, name := range map[string]string{
+		"hdmi":       "alsa_output.pci-0000_00_1f.3.hdmi-stereo",
+		"tv":         "alsa_output.pci-0000_00_1f.3.hdmi-stereo",
+		"HEADPHONES": "alsa_output.pci-0000_00_1f.3.analog-stereo",
+		"Fake":       "fake",
+	} {
+		sink, err := c.FindSink(ctx, query)
+		if assert.NoError(t, err, query) {
+			assert.Equal(t, name, sink.Name, query)
+		}
+	}
+
+	_, err := c.FindSink(ctx, "built-in")
+	assert.ErrorIs(t, err, ErrAmbiguousSink)
+	_, err = c.FindSink(ctx, "bluetooth")
+	assert.ErrorIs(t, err, ErrNoSuchEntity)
+	_, err = c.FindSink(ctx, "")
+	assert.ErrorIs(t, err, ErrInvalidArgument)
+}
+
+func TestPing(t *testing.T) {
+	srv := newFakeServer(t)
+	c := newFakeClient(t, srv)
+	require.NoError(t, c.Ping(context.Background()))
+
+	c.Close()
+	assert.ErrorIs(t, c.Ping(context.Background()), ErrClientClosed)
+	assert.ErrorIs(t, (*Client)(nil).Ping(context.Background()), ErrClientDisabled)
+}
+
+func TestStat(t *testing.T) {
+	srv := newFakeServer(t)
+	c := newFakeClient(t, srv)
+	stats, err := c.Stat(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, &ServerStats{
+		MemblockTotal:         12,
+		MemblockTotalSize:     98304,
+		MemblockAllocated:     4711,
+		MemblockAllocatedSize: 77070336,
+		ScacheSize:            44100,
+	}, stats)
+}
+
+func TestMeasureLatency(t *testing.T) {
+	srv := newFakeServer(t)
+	c := newFakeClient(t, srv)
+	ctx := context.Background()
+
+	srv.update("fake", func(sink *Sink) { sink.Latency = 15857 })
+	latency, err := c.MeasureLatency(ctx, "fake")
+	require.NoError(t, err)
+	assert.Greater(t, latency, 15857*time.Microsecond)
+	assert.Less(t, latency, 15857*time.Microsecond+time.Second)
+
+	_, err = c.MeasureLatency(ctx, "missing")
+	assert.ErrorIs(t, err, ErrNoSuchEntity)
+}
+
+func TestPortLatencyOffset(t *testing.T) {
+	srv := newFakeServer(t)
+	c := newFakeClient(t, srv)
+	ctx := context.Background()
+
+	require.NoError(t, c.SetPortLatencyOffset(ctx, "fake_card", "hdmi-output-0", 120*time.Millisecond))
+	offset, err := c.PortLatencyOffset(ctx, "fake_card", "hdmi-output-0")
+	require.NoError(t, err)
+	assert.Equal(t, 120*time.Millisecond, offset)
+	offset, err = c.PortLatencyOffset(ctx, "fake_card", "analog-output-speaker")
+	require.NoError(t, err)
+	assert.Zero(t, offset)
+
+	assert.EqualError(t, c.SetPortLatencyOffset(ctx, "fake_card", "missing", time.Millisecond),
+		"PulseAudio error: card fake_card has no port missing")
+	assert.EqualError(t, c.SetPortLatencyOffset(ctx, "missing", "hdmi-output-0", time.Millisecond),
+		"PulseAudio error: card missing not found")
+}
+
+func TestPortDirection(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.cards[0].Ports = append(srv.cards[0].Ports, Port{
+		Name:        "analog-input-mic",
+		Description: "Microphone",
+		Direction:   PortDirectionInput,
+	})
+	c := newFakeClient(t, srv)
+
+	cards, err := c.Cards(context.Background())
+	require.NoError(t, err)
+	require.Len(t, cards, 1)
+	var directions []PortDirection
+	for _, port := range cards[0].Ports {
+		directions = append(directions, port.Direction)
+	}
+	assert.Equal(t, []PortDirection{PortDirectionOutput, PortDirectionOutput, PortDirectionInput}, directions)
+
+	assert.Equal(t, "output", PortDirectionOutput.String())
+	assert.Equal(t, "input", PortDirectionInput.String())
+	assert.Equal(t, "bidirectional", PortDirectionBidirectional.String())
+	assert.Equal(t, "UnknownValue(4)", PortDirection(4).String())
+}
+
+func TestCardProfiles(t *testing.T) {
+	card := fakeCard()
+	var names []string
+	for _, p := range card.AvailableProfiles() {
+		names = append(names, p.Name)
+	}
+	assert.Equal(t, []string{"output:analog-stereo", "off"}, names)
+
+	outputs := card.ActiveOutputProfiles()
+	require.Len(t, outputs, 1)
+	assert.Equal(t, "output:analog-stereo", outputs[0].Name)
+
+	assert.Empty(t, (&Card{}).AvailableProfiles())
+}
+
+func TestSetCardProfileByIndex(t *testing.T) {
+	srv := newFakeServer(t)
+	c := newFakeClient(t, srv)
+	ctx := context.Background()
+
+	cards, err := c.Cards(ctx)
+	require.NoError(t, err)
+	require.Len(t, cards, 1)
+	card := cards[0]
+
+	require.NoError(t, c.SetCardProfileByIndex(ctx, card.Index, card.Profiles["off"]))
+	assert.Equal(t, "off", srv.card("fake_card").ActiveProfile.Name)
+
+	err = c.SetCardProfileByIndex(ctx, card.Index, card.Profiles["output:hdmi-stereo"])
+	assert.True(t, errors.Is(err, ErrProfileUnavailable), "unexpected error: %v", err)
+	assert.Equal(t, "off", srv.card("fake_card").ActiveProfile.Name)
+
+	assert.Error(t, c.SetCardProfileByIndex(ctx, card.Index, nil))
+}
+
+func TestGetCard(t *testing.T) {
+	srv := newFakeServer(t)
+	second := fakeCard()
+	second.Index = 3
+	second.Name = "second_card"
+	srv.cards = append(srv.cards, second)
+	c := newFakeClient(t, srv)
+	ctx := context.Background()
+
+	card, err := c.GetCard(ctx, 3)
+	require.NoError(t, err)
+	assert.Equal(t, "second_card", card.Name)
+	assert.Equal(t, "output:analog-stereo", card.ActiveProfile.Name)
+	require.Len(t, card.Ports, 2)
+	assert.Same(t, card, card.Ports[0].Card)
+	assert.Same(t, card.Profiles["output:hdmi-stereo"], card.Ports[1].Profiles[0])
+
+	card, err = c.GetCardByName(ctx, "fake_card")
+	require.NoError(t, err)
+	assert.Equal(t, uint32(0), card.Index)
+
+	_, err = c.GetCard(ctx, 7)
+	assert.Error(t, err)
+
+	cards, err := c.Cards(ctx)
+	require.NoError(t, err)
+	require.Len(t, cards, 2)
+	assert.Same(t, &cards[1], cards[1].Ports[1].Card)
+}
+
+// countServerInfo counts the commandGetServerInfo requests received by the fake server.
+func countServerInfo(srv *fakeServer) *int32 {
+	var n int32
+	srv.mu.Lock()
+	h := srv.handlers[commandGetServerInfo]
+	srv.mu.Unlock()
+	srv.handle(commandGetServerInfo, func(req *bytes.Buffer) ([]interface{}, uint32) {
+		atomic.AddInt32(&n, 1)
+		return h(req)
+	})
+	return &n
+}
+
+func TestServerInfoCache(t *testing.T) {
+	srv := newFakeServer(t)
+	requests := countServerInfo(srv)
+	srv.handle(commandSetDefaultSink, func(*bytes.Buffer) ([]interface{}, uint32) { return nil, 0 })
+	c := newFakeClient(t, srv, WithServerInfoCacheTTL(time.Minute))
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		s, err := c.ServerInfo(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, "fake", s.DefaultSink)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(requests))
+
+	// the default sink changed
+	srv.broadcast(commandSubscribeEvent, uint32Tag, uint32(0x0010|0x0007), uint32Tag, uint32(0))
+	assert.Eventually(t, func() bool {
+		_, err := c.ServerInfo(ctx)
+		return err == nil && atomic.LoadInt32(requests) == 2
+	}, time.Second, 10*time.Millisecond)
+
+	require.NoError(t, c.setDefaultSink(ctx, "fake"))
+	_, err := c.ServerInfo(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(requests))
+}
+
+func TestServerInfoCacheInvalidatedDuringRequest(t *testing.T) {
+	srv := newFakeServer(t)
+	requests := countServerInfo(srv)
+	srv.mu.Lock()
+	h := srv.handlers[commandGetServerInfo]
+	srv.mu.Unlock()
+	c := newFakeClient(t, srv, WithServerInfoCacheTTL(time.Minute))
+	ctx := context.Background()
+	require.NoError(t, c.Ping(ctx))
+
+	// the default sink changes while the first reply is on its way
+	srv.handle(commandGetServerInfo, func(req *bytes.Buffer) ([]interface{}, uint32) {
+		if atomic.LoadInt32(requests) == 0 {
+			srv.broadcast(commandSubscribeEvent, uint32Tag, uint32(0x0010|0x0007), uint32Tag, uint32(0))
+		}
+		return h(req)
+	})
+	for i := 0; i < 3; i++ {
+		_, err := c.ServerInfo(ctx)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, int32(2), atomic.LoadInt32(requests), "outdated reply was cached")
+}
+
+func TestServerInfoNoCache(t *testing.T) {
+	srv := newFakeServer(t)
+	requests := countServerInfo(srv)
+	c := newFakeClient(t, srv)
+
+	for i := 0; i < 3; i++ {
+		_, err := c.ServerInfo(context.Background())
+		require.NoError(t, err)
+	}
+	assert.Equal(t, int32(3), atomic.LoadInt32(requests))
+}
+
+func TestWaitForSink(t *testing.T) {
+	srv := newFakeServer(t)
+	missed := make(chan string, 10)
+	srv.handle(commandGetSinkInfo, func(req *bytes.Buffer) ([]interface{}, uint32) {
+		var idx uint32
+		var name string
+		if err := bread(req, uint32Tag, &idx, stringTag, &name); err != nil {
+			return nil, 3 // invalid argument
+		}
+		sink := srv.sink(idx, name)
+		if sink == nil {
+			missed <- name
+			return nil, 5 // no such entity
+		}
+		srv.mu.Lock()
+		defer srv.mu.Unlock()
+		return encodeFakeSink(*sink), 0
+	})
+	c := newFakeClient(t, srv)
+	ctx := context.Background()
+
+	sink, err := c.WaitForSink(ctx, "fake")
+	require.NoError(t, err)
+	assert.Equal(t, "fake", sink.Name)
+
+	found := make(chan *Sink, 1)
+	go func() {
+		sink, err := c.WaitForSink(ctx, "combined")
+		assert.NoError(t, err)
+		found <- sink
+	}()
+	assert.Equal(t, "combined", <-missed)
+	srv.mu.Lock()
+	srv.sinks = append(srv.sinks, Sink{Index: 7, Name: "combined", ChannelMap: ChannelMap{1, 2}, CVolume: CVolume{VolumeNorm, VolumeNorm}})
+	srv.mu.Unlock()
+	srv.broadcast(commandSubscribeEvent, uint32Tag, uint32(0x0000), uint32Tag, uint32(7)) // new sink
+	select {
+	case sink := <-found:
+		require.NotNil(t, sink)
+		assert.Equal(t, uint32(7), sink.Index)
+	case <-time.After(time.Second):
+		t.Fatal("sink was not found after it appeared")
+	}
+	assert.Eventually(t, func() bool { return !c.hasSubscribers() }, time.Second, time.Millisecond,
+		"subscriptions of finished waits were kept")
+
+	ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err = c.WaitForSink(ctx, "missing")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestMonitorSource(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.sinks = append(srv.sinks, Sink{Index: 1, Name: "null", ChannelMap: ChannelMap{1, 2}, CVolume: CVolume{VolumeNorm, VolumeNorm},
+		MonitorSourceIndex: 0xffffffff})
+	c := newFakeClient(t, srv)
+	ctx := context.Background()
+
+	source, err := c.MonitorSource(ctx, "fake")
+	require.NoError(t, err)
+	assert.Equal(t, "fake.monitor", source.Name)
+	assert.Equal(t, "fake", source.MonitorSinkName)
+
+	_, err = c.MonitorSource(ctx, "null")
+	assert.ErrorIs(t, err, ErrNoSuchEntity)
+	_, err = c.MonitorSource(ctx, "missing")
+	assert.ErrorIs(t, err, ErrNoSuchEntity)
+}
+
+func TestDefaultDeviceInfo(t *testing.T) {
+	srv := newFakeServer(t)
+	c := newFakeClient(t, srv)
+	ctx := context.Background()
+
+	sink, err := c.DefaultSinkInfo(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "fake", sink.Name)
+	assert.Equal(t, CVolume{0x8000, 0x8000}, sink.CVolume)
+	source, err := c.DefaultSourceInfo(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "fake.monitor", source.Name)
+
+	t.Setenv("PULSE_SOURCE", "mic")
+	c = newFakeClient(t, srv)
+	_, err = c.DefaultSourceInfo(ctx)
+	assert.ErrorIs(t, err, ErrNoSuchEntity)
+}
@@ -0,0 +1,235 @@
+// Package tagstruct implements the tagstruct encoding of the PulseAudio native protocol. It is
+// shared by the client and the fake server of package pulseaudiotest.
+package tagstruct
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Tag precedes every value of a tagstruct and tells its type.
+type Tag byte
+
+// Tags of the values.
+const (
+	InvalidTag    Tag = 0
+	StringTag     Tag = 't'
+	StringNullTag Tag = 'N'
+	Uint32Tag     Tag = 'L'
+	Uint8Tag      Tag = 'B'
+	Uint64Tag     Tag = 'R'
+	Int64Tag      Tag = 'r'
+	SampleSpecTag Tag = 'a'
+	ArbitraryTag  Tag = 'x'
+	TrueTag       Tag = '1'
+	FalseTag      Tag = '0'
+	TimeTag       Tag = 'T'
+	UsecTag       Tag = 'U'
+	ChannelMapTag Tag = 'm'
+	CVolumeTag    Tag = 'v'
+	PropListTag   Tag = 'P'
+	VolumeTag     Tag = 'V'
+	FormatInfoTag Tag = 'f'
+)
+
+func (t Tag) String() string {
+	switch t {
+	case InvalidTag:
+		return "invalidTag"
+	case StringTag:
+		return "stringTag"
+	case StringNullTag:
+		return "stringNullTag"
+	case Uint32Tag:
+		return "uint32Tag"
+	case Uint8Tag:
+		return "uint8Tag"
+	case Uint64Tag:
+		return "uint64Tag"
+	case Int64Tag:
+		return "int64Tag"
+	case SampleSpecTag:
+		return "sampleSpecTag"
+	case ArbitraryTag:
+		return "arbitraryTag"
+	case TrueTag:
+		return "trueTag"
+	case FalseTag:
+		return "falseTag"
+	case TimeTag:
+		return "timeTag"
+	case UsecTag:
+		return "usecTag"
+	case ChannelMapTag:
+		return "channelMapTag"
+	case CVolumeTag:
+		return "cvolumeTag"
+	case PropListTag:
+		return "propListTag"
+	case VolumeTag:
+		return "volumeTag"
+	case FormatInfoTag:
+		return "formatInfoTag"
+	default:
+		return fmt.Sprintf("UnknownValue(%d)", t)
+	}
+}
+
+// Write encodes data in network byte order. A map[string]string is written as a property list
+// without its empty values; everything else, including the tags, is written as binary.Write does.
+func Write(w io.Writer, data ...interface{}) error {
+	for _, v := range data {
+		if propList, ok := v.(map[string]string); ok {
+			err := Write(w, PropListTag)
+			if err != nil {
+				return err
+			}
+			for k, v := range propList {
+				if v == "" {
+					continue
+				}
+
+				l := uint32(len(v) + 1) // +1 for null at the end of string
+				err := Write(w,
+					StringTag, []byte(k), byte(0),
+					Uint32Tag, l,
+					ArbitraryTag, l,
+					[]byte(v), byte(0),
+				)
+				if err != nil {
+					return err
+				}
+			}
+			err = Write(w, StringNullTag)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := binary.Write(w, binary.BigEndian, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Read decodes data written by Write. A Tag in data is checked against the next tag of r, any
+// other value must be a pointer: strings, property lists and booleans are decoded from their
+// tagstruct representation, an io.ReaderFrom reads itself and everything else is read with
+// binary.Read. Read returns the index of the value which failed.
+func Read(r io.Reader, data ...interface{}) (int, error) {
+	nullString := false
+	for i, v := range data {
+		t, ok := v.(Tag)
+		if ok {
+			var tt Tag
+			if err := binary.Read(r, binary.BigEndian, &tt); err != nil {
+				return i, err
+			}
+			if tt != t {
+				if t == StringTag && tt == StringNullTag {
+					nullString = true
+					continue
+				}
+				return i, fmt.Errorf("protcol error: (field %d) got type %s but expected %s", i, tt, t)
+			}
+			continue
+		}
+
+		sptr, ok := v.(*string)
+		if ok {
+			if nullString {
+				nullString = false
+				continue
+			}
+			buf := make([]byte, 1024) // max string length i guess.
+			n := 0
+			for {
+				_, err := r.Read(buf[n : n+1])
+				if err != nil {
+					return i, err
+				}
+				if buf[n] == 0 {
+					*sptr = string(buf[:n])
+					break
+				} else {
+					if n > len(buf) {
+						return i, fmt.Errorf("string is too long (max %d bytes)", len(buf))
+					}
+					n++
+				}
+			}
+			continue
+		}
+
+		propList, ok := v.(*map[string]string)
+		if ok {
+			*propList = make(map[string]string)
+			_, err := Read(r, PropListTag)
+			if err != nil {
+				return i, err
+			}
+			for {
+				var t Tag
+				if _, err = Read(r, &t); err != nil {
+					return i, err
+				}
+				if t == StringNullTag {
+					// end of the proplist.
+					break
+				}
+				if t != StringTag {
+					return i, fmt.Errorf("protcol error: got type %s but expected %s", t, StringTag)
+				}
+
+				var k, v string
+				var l1, l2 uint32
+				if _, err = Read(r,
+					&k,
+					Uint32Tag, &l1,
+					ArbitraryTag, &l2,
+					&v,
+				); err != nil {
+					return i, err
+				}
+				if len(v) != int(l1-1) || len(v) != int(l2-1) {
+					return i, fmt.Errorf("protocol error: proplist value length mismatch (len %d, arb len %d, value len %d)",
+						l1, l2, len(v))
+				}
+				(*propList)[k] = v
+			}
+			continue
+		}
+
+		rdr, ok := v.(io.ReaderFrom)
+		if ok {
+			if _, err := rdr.ReadFrom(r); err != nil {
+				return i, err
+			}
+			continue
+		}
+
+		bptr, ok := v.(*bool)
+		if ok {
+			var tt Tag
+			if err := binary.Read(r, binary.BigEndian, &tt); err != nil {
+				return i, err
+			}
+			if tt == TrueTag {
+				*bptr = true
+			} else if tt == FalseTag {
+				*bptr = false
+			} else {
+				return i, fmt.Errorf("protcol error: got type %s but expected boolean true or false", tt)
+			}
+			continue
+		}
+
+		if err := binary.Read(r, binary.BigEndian, v); err != nil {
+			return i, err
+		}
+	}
+	return 0, nil
+}
@@ -0,0 +1,175 @@
+package pulseaudio
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// LoadModule loads a server module with the given argument string and returns its index.
+func (c *Client) LoadModule(ctx context.Context, name, args string) (uint32, error) {
+	b, err := c.request(ctx, commandLoadModule,
+		stringTag, []byte(name), byte(0),
+		stringTag, []byte(args), byte(0))
+	if err != nil {
+		return 0, err
+	}
+	var index uint32
+	err = decodeReply(commandLoadModule, b, uint32Tag, &index)
+	if err != nil {
+		return 0, err
+	}
+	return index, nil
+}
+
+// UnloadModule unloads the module with the given index, e.g. one created by CreateLoopback.
+func (c *Client) UnloadModule(ctx context.Context, index uint32) error {
+	_, err := c.request(ctx, commandUnloadModule, uint32Tag, index)
+	return err
+}
+
+// FindModule returns the first loaded module with the given name (e.g. "module-loopback"), so that
+// a module can be loaded only if it isn't already. ok is false if no such module is loaded.
+func (c *Client) FindModule(ctx context.Context, name string) (module *Module, ok bool, err error) {
+	modules, err := c.Modules(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	for i := range modules {
+		if modules[i].Name == name {
+			return &modules[i], true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+// CreateLoopback routes sourceName to sinkName with module-loopback and returns the module index.
+// The optional channel names (e.g. "front-left", "front-right") set the channel map of the loopback.
+func (c *Client) CreateLoopback(ctx context.Context, sourceName, sinkName string, latencyMsec uint32, channelMap ...string) (uint32, error) {
+	args := []string{
+		moduleArg("source", sourceName),
+		moduleArg("sink", sinkName),
+		moduleArg("latency_msec", fmt.Sprintf("%d", latencyMsec)),
+	}
+	if len(channelMap) > 0 {
+		args = append(args,
+			moduleArg("channels", fmt.Sprintf("%d", len(channelMap))),
+			moduleArg("channel_map", strings.Join(channelMap, ",")))
+	}
+	return c.LoadModule(ctx, "module-loopback", strings.Join(args, " "))
+}
+
+// CreateCombinedSink creates a virtual sink named name which plays on all slaveSinks, using
+// module-combine-sink, and returns the module index. Without slave sinks the server combines all sinks.
+func (c *Client) CreateCombinedSink(ctx context.Context, name string, slaveSinks []string) (uint32, error) {
+	args := []string{moduleArg("sink_name", name)}
+	if len(slaveSinks) > 0 {
+		for _, slave := range slaveSinks {
+			if slave == "" || strings.Contains(slave, ",") {
+				return 0, fmt.Errorf("PulseAudio error: invalid slave sink name %q", slave)
+			}
+		}
+		args = append(args, moduleArg("slaves", strings.Join(slaveSinks, ",")))
+	}
+	return c.LoadModule(ctx, "module-combine-sink", strings.Join(args, " "))
+}
+
+// CreateNullSink creates a sink which discards its audio, using module-null-sink, and returns the
+// module index. The sink's monitor source can be recorded from. A zero spec keeps the server defaults.
+func (c *Client) CreateNullSink(ctx context.Context, name, description string, spec SampleSpec) (uint32, error) {
+	args := []string{moduleArg("sink_name", name)}
+	if description != "" {
+		args = append(args, moduleArg("sink_properties", "device.description="+quotePropValue(description)))
+	}
+	if spec != (SampleSpec{}) {
+		if err := spec.Valid(); err != nil {
+			return 0, err
+		}
+		args = append(args, moduleArg("format", spec.Format.String()))
+		args = append(args,
+			moduleArg("rate", fmt.Sprintf("%d", spec.Rate)),
+			moduleArg("channels", fmt.Sprintf("%d", spec.Channels)))
+	}
+	return c.LoadModule(ctx, "module-null-sink", strings.Join(args, " "))
+}
+
+// EnableRoleDucking lowers streams with the duckee media role to volume (1 is 100%) while a stream
+// with the trigger role plays, using module-role-ducking, and returns the module index. Roles are
+// comma separated lists such as "phone,event".
+func (c *Client) EnableRoleDucking(ctx context.Context, trigger, duckee string, volume float32) (uint32, error) {
+	if volume < 0 {
+		return 0, fmt.Errorf("PulseAudio error: invalid ducking volume %v", volume)
+	}
+	args := []string{
+		moduleArg("trigger_roles", trigger),
+		moduleArg("ducking_roles", duckee),
+		moduleArg("volume", fmt.Sprintf("%d%%", int(volume*100+0.5))),
+	}
+	return c.LoadModule(ctx, "module-role-ducking", strings.Join(args, " "))
+}
+
+// CreateEqualizerSink creates an equalizer sink playing on masterSink, using module-equalizer-sink,
+// and returns the module index. The equalizer shows up in Sinks like any other sink. Its bands are
+// controlled over D-Bus (e.g. with qpaeq), so module-dbus-protocol is loaded as well unless it
+// already is; it stays loaded when the equalizer is unloaded.
+func (c *Client) CreateEqualizerSink(ctx context.Context, masterSink string) (uint32, error) {
+	// module-dbus-protocol may be loaded only once, so the server refuses a second load
+	_, loaded, err := c.FindModule(ctx, "module-dbus-protocol")
+	if err != nil {
+		return 0, err
+	}
+	if !loaded {
+		_, err = c.LoadModule(ctx, "module-dbus-protocol", "")
+		if err != nil {
+			return 0, fmt.Errorf("could not load module-dbus-protocol: %w", err)
+		}
+	}
+	return c.LoadModule(ctx, "module-equalizer-sink", moduleArg("sink_master", masterSink))
+}
+
+// tunnelSinkNewVersion is the protocol version from which CreateTunnelSink uses module-tunnel-sink-new.
+const tunnelSinkNewVersion = 32
+
+// CreateTunnelSink creates a local sink which plays on sinkName of the PulseAudio server at
+// remoteServer (e.g. "tcp:livingroom.local") and returns the module index. An empty sinkName
+// selects the default sink of the remote server. module-tunnel-sink-new is used if the server
+// is recent enough, module-tunnel-sink otherwise.
+func (c *Client) CreateTunnelSink(ctx context.Context, remoteServer, sinkName string) (uint32, error) {
+	if c.ServerProtocolVersion() == 0 {
+		// wait for the connection so that the server version is known
+		if _, err := c.ServerInfo(ctx); err != nil {
+			return 0, err
+		}
+	}
+	module := "module-tunnel-sink-new"
+	if c.ServerProtocolVersion() < tunnelSinkNewVersion {
+		module = "module-tunnel-sink"
+	}
+	args := []string{moduleArg("server", remoteServer)}
+	if sinkName != "" {
+		args = append(args, moduleArg("sink", sinkName))
+	}
+	return c.LoadModule(ctx, module, strings.Join(args, " "))
+}
+
+// moduleArg formats a key=value module argument, quoting the value if needed.
+func moduleArg(key, value string) string {
+	return key + "=" + quoteModuleValue(value)
+}
+
+// quoteModuleValue quotes a value for a module argument string if it contains spaces,
+// quotes or backslashes, which the server's argument parser would otherwise split on.
+func quoteModuleValue(value string) string {
+	if value != "" && !strings.ContainsAny(value, " \t\n'\"\\") {
+		return value
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	return `"` + r.Replace(value) + `"`
+}
+
+// quotePropValue quotes a value of a property list passed as a module argument, e.g. the
+// device.description in sink_properties.
+func quotePropValue(value string) string {
+	r := strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+	return `'` + r.Replace(value) + `'`
+}
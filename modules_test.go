@@ -0,0 +1,206 @@
+package pulseaudio
+
+import (
+	"bytes"
+	"context"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// loadedModule is a commandLoadModule request seen by the fake server.
+type loadedModule struct {
+	name string
+	args string
+}
+
+// handleModules makes the fake server accept module loads, which are reported on the returned
+// channel. Modules get indexes starting at 20.
+func handleModules(srv *fakeServer) <-chan loadedModule {
+	loaded := make(chan loadedModule, 4)
+	index := uint32(20)
+	srv.handle(commandLoadModule, func(req *bytes.Buffer) ([]interface{}, uint32) {
+		var m loadedModule
+		if err := bread(req, stringTag, &m.name, stringTag, &m.args); err != nil {
+			return nil, 3 // invalid argument
+		}
+		loaded <- m
+		index++
+		return []interface{}{uint32Tag, index - 1}, 0
+	})
+	srv.handle(commandUnloadModule, func(req *bytes.Buffer) ([]interface{}, uint32) {
+		var idx uint32
+		if err := bread(req, uint32Tag, &idx); err != nil || idx < 20 || idx >= index {
+			return nil, 5 // no such entity
+		}
+		return nil, 0
+	})
+	return loaded
+}
+
+// handleModuleList makes the fake server report modules as loaded.
+func handleModuleList(srv *fakeServer, modules ...Module) {
+	srv.handle(commandGetModuleInfoList, func(*bytes.Buffer) ([]interface{}, uint32) {
+		var reply []interface{}
+		for _, m := range modules {
+			reply = append(reply,
+				uint32Tag, m.Index,
+				stringTag, []byte(m.Name), byte(0),
+				stringTag, []byte(m.Argument), byte(0),
+				uint32Tag, m.NUsed,
+				map[string]string(m.PropList),
+			)
+		}
+		return reply, 0
+	})
+}
+
+func TestFindModule(t *testing.T) {
+	srv := newFakeServer(t)
+	handleModuleList(srv,
+		Module{Index: 3, Name: "module-null-sink", Argument: "sink_name=null", NUsed: 0xffffffff, PropList: map[string]string{}},
+		Module{Index: 7, Name: "module-loopback", Argument: "source=fake.monitor", NUsed: 0xffffffff, PropList: map[string]string{"module.author": "Pierre-Louis Bossart"}},
+	)
+	c := newFakeClient(t, srv)
+	ctx := context.Background()
+
+	m, ok, err := c.FindModule(ctx, "module-loopback")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, uint32(7), m.Index)
+	assert.Equal(t, "source=fake.monitor", m.Argument)
+
+	m, ok, err = c.FindModule(ctx, "module-echo-cancel")
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.Nil(t, m)
+}
+
+func TestQuoteModuleValue(t *testing.T) {
+	assert.Equal(t, "alsa_output.zone1", quoteModuleValue("alsa_output.zone1"))
+	assert.Equal(t, `""`, quoteModuleValue(""))
+	assert.Equal(t, `"Living Room"`, quoteModuleValue("Living Room"))
+	assert.Equal(t, `"say \"hi\" \\o/"`, quoteModuleValue(`say "hi" \o/`))
+}
+
+func TestCreateLoopback(t *testing.T) {
+	srv := newFakeServer(t)
+	loaded := handleModules(srv)
+	c := newFakeClient(t, srv)
+	ctx := context.Background()
+
+	idx, err := c.CreateLoopback(ctx, "fake.monitor", "fake", 50)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(20), idx)
+	assert.Equal(t, loadedModule{"module-loopback", "source=fake.monitor sink=fake latency_msec=50"}, <-loaded)
+
+	idx, err = c.CreateLoopback(ctx, "mic", "fake", 20, "front-left", "front-right")
+	require.NoError(t, err)
+	assert.Equal(t, uint32(21), idx)
+	assert.Equal(t, loadedModule{"module-loopback",
+		"source=mic sink=fake latency_msec=20 channels=2 channel_map=front-left,front-right"}, <-loaded)
+
+	require.NoError(t, c.UnloadModule(ctx, 21))
+	assert.ErrorIs(t, c.UnloadModule(ctx, 99), ErrNoSuchEntity)
+}
+
+func TestCreateCombinedSink(t *testing.T) {
+	srv := newFakeServer(t)
+	loaded := handleModules(srv)
+	c := newFakeClient(t, srv)
+	ctx := context.Background()
+
+	idx, err := c.CreateCombinedSink(ctx, "house", []string{"alsa_output.zone1", "alsa_output.zone 2"})
+	require.NoError(t, err)
+	assert.Equal(t, uint32(20), idx)
+	assert.Equal(t, loadedModule{"module-combine-sink",
+		`sink_name=house slaves="alsa_output.zone1,alsa_output.zone 2"`}, <-loaded)
+
+	_, err = c.CreateCombinedSink(ctx, "all", nil)
+	require.NoError(t, err)
+	assert.Equal(t, loadedModule{"module-combine-sink", "sink_name=all"}, <-loaded)
+
+	_, err = c.CreateCombinedSink(ctx, "house", []string{"a,b"})
+	assert.Error(t, err)
+}
+
+func TestCreateNullSink(t *testing.T) {
+	srv := newFakeServer(t)
+	loaded := handleModules(srv)
+	c := newFakeClient(t, srv)
+	ctx := context.Background()
+
+	_, err := c.CreateNullSink(ctx, "recorder", "Living Room's Recorder", SampleSpec{Format: SampleS16LE, Channels: 2, Rate: 48000})
+	require.NoError(t, err)
+	assert.Equal(t, loadedModule{"module-null-sink",
+		`sink_name=recorder sink_properties="device.description='Living Room\\'s Recorder'" format=s16le rate=48000 channels=2`},
+		<-loaded)
+
+	_, err = c.CreateNullSink(ctx, "sink", "", SampleSpec{})
+	require.NoError(t, err)
+	assert.Equal(t, loadedModule{"module-null-sink", "sink_name=sink"}, <-loaded)
+}
+
+func TestCreateTunnelSink(t *testing.T) {
+	srv := newFakeServer(t)
+	loaded := handleModules(srv)
+	c := newFakeClient(t, srv)
+	ctx := context.Background()
+
+	_, err := c.CreateTunnelSink(ctx, "tcp:livingroom.local", "alsa_output.zone1")
+	require.NoError(t, err)
+	assert.Equal(t, uint32(version), c.ServerProtocolVersion())
+	assert.Equal(t, loadedModule{"module-tunnel-sink-new", "server=tcp:livingroom.local sink=alsa_output.zone1"}, <-loaded)
+
+	// pretend to talk to an older server
+	atomic.StoreUint32(&c.serverVersion, tunnelSinkNewVersion-1)
+	_, err = c.CreateTunnelSink(ctx, "tcp:livingroom.local", "")
+	require.NoError(t, err)
+	assert.Equal(t, loadedModule{"module-tunnel-sink", "server=tcp:livingroom.local"}, <-loaded)
+}
+
+func TestEnableRoleDucking(t *testing.T) {
+	srv := newFakeServer(t)
+	loaded := handleModules(srv)
+	c := newFakeClient(t, srv)
+	ctx := context.Background()
+
+	idx, err := c.EnableRoleDucking(ctx, "phone", "music,video", 0.3)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(20), idx)
+	assert.Equal(t, loadedModule{"module-role-ducking", "trigger_roles=phone ducking_roles=music,video volume=30%"}, <-loaded)
+
+	_, err = c.EnableRoleDucking(ctx, "phone", "music", -1)
+	assert.Error(t, err)
+}
+
+func TestCreateEqualizerSink(t *testing.T) {
+	srv := newFakeServer(t)
+	loaded := handleModules(srv)
+	handleModuleList(srv)
+	c := newFakeClient(t, srv)
+	ctx := context.Background()
+
+	idx, err := c.CreateEqualizerSink(ctx, "alsa_output.zone1")
+	require.NoError(t, err)
+	assert.Equal(t, uint32(21), idx)
+	assert.Equal(t, loadedModule{"module-dbus-protocol", ""}, <-loaded)
+	assert.Equal(t, loadedModule{"module-equalizer-sink", "sink_master=alsa_output.zone1"}, <-loaded)
+
+	// module-dbus-protocol is loaded now, the server would refuse to load it twice
+	handleModuleList(srv, Module{Index: 20, Name: "module-dbus-protocol", NUsed: 0xffffffff, PropList: map[string]string{}})
+	idx, err = c.CreateEqualizerSink(ctx, "alsa_output.zone1")
+	require.NoError(t, err)
+	assert.Equal(t, uint32(22), idx)
+	assert.Equal(t, loadedModule{"module-equalizer-sink", "sink_master=alsa_output.zone1"}, <-loaded)
+
+	// D-Bus is not available
+	handleModuleList(srv)
+	srv.handle(commandLoadModule, func(*bytes.Buffer) ([]interface{}, uint32) {
+		return nil, uint32(ErrCodeModInitFailed)
+	})
+	_, err = c.CreateEqualizerSink(ctx, "alsa_output.zone1")
+	assert.ErrorIs(t, err, ErrCodeModInitFailed)
+}
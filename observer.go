@@ -0,0 +1,102 @@
+package pulseaudio
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// OnVolumeChange calls cb whenever the volume of a sink changes, with the name of the sink and
+// its new volume as returned by Volume. The sinks are compared on every server change until the
+// client is closed; sinks which appear or disappear don't produce calls. cb is called from a
+// goroutine of the client, one call at a time.
+func (c *Client) OnVolumeChange(cb func(sinkName string, volume float32)) {
+	var known map[string]float32
+	c.observe(func(ctx context.Context) error {
+		sinks, err := c.Sinks(ctx)
+		if err != nil {
+			return err
+		}
+		current := make(map[string]float32, len(sinks))
+		for _, sink := range sinks {
+			current[sink.Name] = fromVolume(sink.CVolume.Avg())
+		}
+		var changed []string
+		for name, volume := range current {
+			if before, ok := known[name]; ok && before != volume {
+				changed = append(changed, name)
+			}
+		}
+		sort.Strings(changed)
+		for _, name := range changed {
+			cb(name, current[name])
+		}
+		known = current
+		return nil
+	})
+}
+
+// OnDefaultSinkChange calls cb with the name of the default sink whenever another sink becomes
+// the default, until the client is closed. cb is called from a goroutine of the client, one
+// call at a time.
+func (c *Client) OnDefaultSinkChange(cb func(name string)) {
+	known := ""
+	first := true
+	c.observe(func(ctx context.Context) error {
+		s, err := c.ServerInfo(ctx)
+		if err != nil {
+			return err
+		}
+		if !first && s.DefaultSink != known {
+			cb(s.DefaultSink)
+		}
+		known = s.DefaultSink
+		first = false
+		return nil
+	})
+}
+
+// observe subscribes to server events in the background and runs check once subscribed and
+// again after every event, until the client is closed. check compares the state of the server
+// with the one it saw last; until it succeeds once, nothing is compared.
+func (c *Client) observe(check func(ctx context.Context) error) {
+	go func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			select {
+			case <-c.done:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		var updates <-chan struct{}
+		for {
+			var err error
+			updates, err = c.SubscribeEvents(ctx)
+			if err == nil {
+				break
+			}
+			c.logger.Errorf("could not subscribe to server events, retrying: %v", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(c.reconnectInterval):
+			}
+		}
+		for {
+			if err := check(ctx); err != nil && ctx.Err() == nil {
+				c.logger.Errorf("could not read server state to detect changes: %v", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case _, ok := <-updates:
+				if !ok {
+					return
+				}
+			}
+		}
+	}()
+}
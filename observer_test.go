@@ -0,0 +1,112 @@
+package pulseaudio
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// notifyHandled wraps the handler of cmd so that every request is reported on the returned
+// channel once it has been answered.
+func notifyHandled(srv *fakeServer, cmd command) <-chan struct{} {
+	handled := make(chan struct{}, 16)
+	srv.mu.Lock()
+	h := srv.handlers[cmd]
+	srv.mu.Unlock()
+	srv.handle(cmd, func(req *bytes.Buffer) ([]interface{}, uint32) {
+		reply, code := h(req)
+		handled <- struct{}{}
+		return reply, code
+	})
+	return handled
+}
+
+func waitHandled(t *testing.T, handled <-chan struct{}) {
+	t.Helper()
+	select {
+	case <-handled:
+	case <-time.After(time.Second):
+		t.Fatal("the server state was not read")
+	}
+}
+
+func TestOnVolumeChange(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.sinks = append(srv.sinks, Sink{Index: 1, Name: "hdmi", ChannelMap: ChannelMap{1, 2}, CVolume: CVolume{VolumeNorm, VolumeNorm}})
+	handled := notifyHandled(srv, commandGetSinkInfoList)
+	c := newFakeClient(t, srv)
+
+	type change struct {
+		sink   string
+		volume float32
+	}
+	var mu sync.Mutex
+	var changes []change
+	c.OnVolumeChange(func(sinkName string, volume float32) {
+		mu.Lock()
+		defer mu.Unlock()
+		changes = append(changes, change{sinkName, volume})
+	})
+	waitHandled(t, handled)
+
+	srv.mu.Lock()
+	srv.sinks[1].CVolume = CVolume{VolumeNorm / 2, VolumeNorm / 2}
+	srv.sinks = append(srv.sinks, Sink{Index: 2, Name: "new", ChannelMap: ChannelMap{1, 2}, CVolume: CVolume{VolumeNorm, VolumeNorm}})
+	srv.mu.Unlock()
+	srv.broadcast(commandSubscribeEvent, uint32Tag, uint32(0x0010), uint32Tag, uint32(1))
+	waitHandled(t, handled)
+
+	// an event without a volume change
+	srv.broadcast(commandSubscribeEvent, uint32Tag, uint32(0x0010), uint32Tag, uint32(2))
+	waitHandled(t, handled)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []change{{"hdmi", 0.5}}, changes)
+}
+
+func TestOnDefaultSinkChange(t *testing.T) {
+	srv := newFakeServer(t)
+	var mu sync.Mutex
+	defaultSink := "fake"
+	srv.handle(commandGetServerInfo, func(*bytes.Buffer) ([]interface{}, uint32) {
+		mu.Lock()
+		defer mu.Unlock()
+		return []interface{}{
+			stringTag, []byte("pulseaudio"), byte(0),
+			stringTag, []byte("16.1"), byte(0),
+			stringTag, []byte("user"), byte(0),
+			stringTag, []byte("host"), byte(0),
+			sampleSpecTag, byte(3), byte(2), uint32(44100),
+			stringTag, []byte(defaultSink), byte(0),
+			stringTag, []byte("fake.monitor"), byte(0),
+			uint32Tag, uint32(0),
+			channelMapTag, byte(2), []byte{1, 2},
+		}, 0
+	})
+	handled := notifyHandled(srv, commandGetServerInfo)
+	c := newFakeClient(t, srv)
+
+	names := make(chan string, 4)
+	c.OnDefaultSinkChange(func(name string) { names <- name })
+	waitHandled(t, handled)
+
+	// a server change which doesn't touch the default sink
+	srv.broadcast(commandSubscribeEvent, uint32Tag, uint32(0x0010|0x0007), uint32Tag, uint32(0))
+	waitHandled(t, handled)
+	mu.Lock()
+	defaultSink = "hdmi"
+	mu.Unlock()
+	srv.broadcast(commandSubscribeEvent, uint32Tag, uint32(0x0010|0x0007), uint32Tag, uint32(0))
+	select {
+	case name := <-names:
+		assert.Equal(t, "hdmi", name)
+	case <-time.After(time.Second):
+		t.Fatal("the default sink change was not reported")
+	}
+	require.Empty(t, names)
+}
@@ -116,7 +116,7 @@ func (c *Client) Outputs(ctx context.Context) (outputs []Output, activeIndex int
 	activeIndex = -1
 	for _, card := range cards {
 		for _, port := range card.Ports {
-			if port.Direction != 1 {
+			if port.Direction != PortDirectionOutput {
 				continue
 			}
 			for _, sink := range sinks {
@@ -0,0 +1,173 @@
+package pulseaudio
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync/atomic"
+)
+
+// Availability of a port as reported in SinkPort.Available and Port.Available.
+const (
+	PortAvailableUnknown uint32 = 0
+	PortAvailableNo      uint32 = 1
+	PortAvailableYes     uint32 = 2
+)
+
+// BestPort returns the port with the highest priority which isn't known to be unavailable,
+// or nil if the sink has no such port.
+func (s *Sink) BestPort() *SinkPort {
+	var best *SinkPort
+	for i := range s.Ports {
+		p := &s.Ports[i]
+		if p.Available == PortAvailableNo {
+			continue
+		}
+		if best == nil || p.Priority > best.Priority {
+			best = p
+		}
+	}
+	return best
+}
+
+// SortSinksByPriority sorts sinks by the priority of their best port, highest first. Sinks without
+// a usable port are moved to the end. The order of sinks with equal priority is kept.
+func SortSinksByPriority(sinks []Sink) {
+	sort.SliceStable(sinks, func(i, j int) bool {
+		pi, pj := sinks[i].BestPort(), sinks[j].BestPort()
+		if pi == nil || pj == nil {
+			return pi != nil && pj == nil
+		}
+		return pi.Priority > pj.Priority
+	})
+}
+
+// PortEventType tells whether a port became available or unavailable.
+type PortEventType int
+
+const (
+	// PortInserted is sent when a port becomes available, e.g. headphones were plugged in.
+	PortInserted PortEventType = iota
+	// PortRemoved is sent when a port becomes unavailable, e.g. headphones were unplugged.
+	PortRemoved
+)
+
+func (t PortEventType) String() string {
+	switch t {
+	case PortInserted:
+		return "inserted"
+	case PortRemoved:
+		return "removed"
+	default:
+		return fmt.Sprintf("UnknownValue(%d)", int(t))
+	}
+}
+
+// PortEvent reports a change of the availability of a card port.
+type PortEvent struct {
+	Type     PortEventType
+	CardName string
+	PortName string
+}
+
+// WatchPortAvailability returns a channel which receives an event whenever a card port becomes
+// available or unavailable, e.g. when headphones are plugged in. The ports are compared whenever
+// the server reports a card change and after a reconnection; ports with unknown availability don't
+// produce events. The channel is closed when ctx is done or the client is closed.
+func (c *Client) WatchPortAvailability(ctx context.Context) (<-chan PortEvent, error) {
+	// cardsChanged is set to 1 on the receive goroutine when the cards need to be read again
+	var cardsChanged int32
+	changed := func() { atomic.StoreInt32(&cardsChanged, 1) }
+	// register and subscribe first so that no change made while reading the cards is missed
+	h := c.addEventHandler(func(facility Facility, _ Operation, _ uint32) {
+		if facility == FacilityCard {
+			changed()
+		}
+	}, changed)
+	updates, err := c.SubscribeEvents(ctx)
+	if err != nil {
+		c.removeEventHandler(h)
+		return nil, err
+	}
+	// the cards read now include the changes recorded so far
+	atomic.StoreInt32(&cardsChanged, 0)
+	cards, err := c.Cards(ctx)
+	if err != nil {
+		c.removeEventHandler(h)
+		return nil, err
+	}
+	known := portAvailability(cards)
+	events := make(chan PortEvent, 4)
+	go func() {
+		defer close(events)
+		defer c.removeEventHandler(h)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case _, ok := <-updates:
+				if !ok {
+					return
+				}
+			}
+			if !atomic.CompareAndSwapInt32(&cardsChanged, 1, 0) {
+				continue
+			}
+			cards, err := c.Cards(ctx)
+			if err != nil {
+				c.logger.Errorf("could not read cards to detect port changes: %v", err)
+				changed()
+				continue
+			}
+			current := portAvailability(cards)
+			for _, ev := range diffPortAvailability(known, current) {
+				select {
+				case events <- ev:
+				case <-ctx.Done():
+					return
+				}
+			}
+			known = current
+		}
+	}()
+	return events, nil
+}
+
+// portKey identifies a port of a card.
+type portKey struct {
+	card, port string
+}
+
+func portAvailability(cards []Card) map[portKey]uint32 {
+	available := make(map[portKey]uint32)
+	for i := range cards {
+		for _, port := range cards[i].Ports {
+			available[portKey{cards[i].Name, port.Name}] = port.Available
+		}
+	}
+	return available
+}
+
+// diffPortAvailability returns events for the ports which became available or unavailable,
+// sorted by card and port name.
+func diffPortAvailability(before, after map[portKey]uint32) []PortEvent {
+	var events []PortEvent
+	for key, now := range after {
+		if before[key] == now {
+			continue
+		}
+		switch now {
+		case PortAvailableYes:
+			events = append(events, PortEvent{Type: PortInserted, CardName: key.card, PortName: key.port})
+		case PortAvailableNo:
+			events = append(events, PortEvent{Type: PortRemoved, CardName: key.card, PortName: key.port})
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].CardName != events[j].CardName {
+			return events[i].CardName < events[j].CardName
+		}
+		return events[i].PortName < events[j].PortName
+	})
+	return events
+}
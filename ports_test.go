@@ -0,0 +1,102 @@
+package pulseaudio
+
+import (
+	"bytes"
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBestPort(t *testing.T) {
+	sink := Sink{Ports: []SinkPort{
+		{Name: "analog-output-speaker", Priority: 10000, Available: PortAvailableUnknown},
+		{Name: "analog-output-headphones", Priority: 9900, Available: PortAvailableYes},
+		{Name: "hdmi-output-0", Priority: 20000, Available: PortAvailableNo},
+	}}
+	if assert.NotNil(t, sink.BestPort()) {
+		assert.Equal(t, "analog-output-speaker", sink.BestPort().Name)
+	}
+	sink.Ports[0].Available = PortAvailableNo
+	assert.Equal(t, "analog-output-headphones", sink.BestPort().Name)
+	sink.Ports[1].Available = PortAvailableNo
+	assert.Nil(t, sink.BestPort())
+	assert.Nil(t, (&Sink{}).BestPort())
+}
+
+func TestSortSinksByPriority(t *testing.T) {
+	sinks := []Sink{
+		{Name: "null"},
+		{Name: "usb", Ports: []SinkPort{{Priority: 100}}},
+		{Name: "hdmi", Ports: []SinkPort{{Priority: 5900, Available: PortAvailableNo}}},
+		{Name: "analog", Ports: []SinkPort{{Priority: 9900, Available: PortAvailableYes}}},
+		{Name: "usb2", Ports: []SinkPort{{Priority: 100}}},
+	}
+	SortSinksByPriority(sinks)
+	var names []string
+	for _, s := range sinks {
+		names = append(names, s.Name)
+	}
+	assert.Equal(t, []string{"analog", "usb", "usb2", "null", "hdmi"}, names)
+}
+
+func TestWatchPortAvailability(t *testing.T) {
+	srv := newFakeServer(t)
+	var listed int32
+	srv.mu.Lock()
+	list := srv.handlers[commandGetCardInfoList]
+	srv.mu.Unlock()
+	srv.handle(commandGetCardInfoList, func(req *bytes.Buffer) ([]interface{}, uint32) {
+		atomic.AddInt32(&listed, 1)
+		return list(req)
+	})
+	c := newFakeClient(t, srv)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events, err := c.WatchPortAvailability(ctx)
+	require.NoError(t, err)
+
+	// changes of other facilities don't read the cards
+	srv.broadcast(commandSubscribeEvent, uint32Tag, uint32(0x0010|0x0000), uint32Tag, uint32(0))
+	_, err = c.ServerInfo(ctx) // the event has been dispatched once the reply arrives
+	require.NoError(t, err)
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&listed))
+
+	// plug in the HDMI cable and unplug the speakers
+	srv.mu.Lock()
+	srv.cards[0].Ports[0].Available = PortAvailableNo
+	srv.cards[0].Ports[1].Available = PortAvailableYes
+	srv.mu.Unlock()
+	srv.broadcast(commandSubscribeEvent, uint32Tag, uint32(0x0010|0x0009), uint32Tag, uint32(0))
+
+	var got []PortEvent
+	for len(got) < 2 {
+		select {
+		case ev := <-events:
+			got = append(got, ev)
+		case <-time.After(time.Second):
+			t.Fatalf("missing port events, got %v", got)
+		}
+	}
+	assert.Equal(t, []PortEvent{
+		{Type: PortRemoved, CardName: "fake_card", PortName: "analog-output-speaker"},
+		{Type: PortInserted, CardName: "fake_card", PortName: "hdmi-output-0"},
+	}, got)
+
+	// nothing changed
+	srv.broadcast(commandSubscribeEvent, uint32Tag, uint32(0x0010|0x0009), uint32Tag, uint32(0))
+	select {
+	case ev := <-events:
+		t.Fatalf("unexpected event %v", ev)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	cancel()
+	for range events {
+	}
+}
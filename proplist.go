@@ -0,0 +1,44 @@
+package pulseaudio
+
+import (
+	"context"
+	"fmt"
+)
+
+// proplistUpdateReplace makes the server overwrite the given properties and keep the others.
+const proplistUpdateReplace = 2
+
+// SetProperty sets a property of the client, e.g. media.role, on the server. The value must not
+// be empty; use RemoveProperties to delete a property.
+func (c *Client) SetProperty(ctx context.Context, key, value string) error {
+	if value == "" {
+		return fmt.Errorf("empty value of property %s, use RemoveProperties to delete it: %w", key, ErrInvalidArgument)
+	}
+	return c.UpdateProplist(ctx, map[string]string{key: value})
+}
+
+// UpdateProplist sets several properties of the client at once. Properties which aren't given
+// keep their values. Properties with empty values are skipped; use RemoveProperties to delete them.
+func (c *Client) UpdateProplist(ctx context.Context, props map[string]string) error {
+	_, err := c.request(ctx, commandUpdateClientProplist,
+		uint32Tag, uint32(proplistUpdateReplace),
+		props)
+	return err
+}
+
+// RemoveProperties deletes properties of the client.
+func (c *Client) RemoveProperties(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	args := make([]interface{}, 0, 3*len(keys)+1)
+	for _, key := range keys {
+		if key == "" {
+			return fmt.Errorf("PulseAudio error: empty property name")
+		}
+		args = append(args, stringTag, []byte(key), byte(0))
+	}
+	args = append(args, stringNullTag)
+	_, err := c.request(ctx, commandRemoveClientProplist, args...)
+	return err
+}
@@ -0,0 +1,53 @@
+package pulseaudio
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientProplist(t *testing.T) {
+	srv := newFakeServer(t)
+	props := make(map[string]string)
+	srv.handle(commandUpdateClientProplist, func(req *bytes.Buffer) ([]interface{}, uint32) {
+		var mode uint32
+		var update map[string]string
+		if err := bread(req, uint32Tag, &mode, &update); err != nil || mode != proplistUpdateReplace {
+			return nil, uint32(ErrCodeInvalid)
+		}
+		for k, v := range update {
+			props[k] = v
+		}
+		return nil, 0
+	})
+	srv.handle(commandRemoveClientProplist, func(req *bytes.Buffer) ([]interface{}, uint32) {
+		for {
+			var tag tagType
+			var key string
+			if err := bread(req, &tag); err != nil {
+				return nil, uint32(ErrCodeInvalid)
+			}
+			if tag == stringNullTag {
+				return nil, 0
+			}
+			if err := bread(req, &key); err != nil {
+				return nil, uint32(ErrCodeInvalid)
+			}
+			delete(props, key)
+		}
+	})
+	c := newFakeClient(t, srv)
+	ctx := context.Background()
+
+	require.NoError(t, c.SetProperty(ctx, "media.role", "music"))
+	assert.ErrorIs(t, c.SetProperty(ctx, "media.role", ""), ErrInvalidArgument)
+	require.NoError(t, c.UpdateProplist(ctx, map[string]string{"application.name": "player", "media.role": "event"}))
+	assert.Equal(t, map[string]string{"application.name": "player", "media.role": "event"}, props)
+
+	require.NoError(t, c.RemoveProperties(ctx, "media.role"))
+	assert.Equal(t, map[string]string{"application.name": "player"}, props)
+	assert.Error(t, c.RemoveProperties(ctx, ""))
+}
@@ -0,0 +1,425 @@
+// Package pulseaudiotest provides a fake PulseAudio server for testing code which uses the
+// pulseaudio client without a running PulseAudio daemon.
+package pulseaudiotest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/gophertribe/pulseaudio"
+	"github.com/gophertribe/pulseaudio/internal/tagstruct"
+)
+
+// protocolVersion is the native protocol version announced by the server.
+const protocolVersion = 32
+
+// Commands of the native protocol understood by the server.
+const (
+	commandError           = 0
+	commandReply           = 2
+	commandAuth            = 8
+	commandSetClientName   = 9
+	commandGetServerInfo   = 20
+	commandGetSinkInfo     = 21
+	commandGetSinkInfoList = 22
+	commandSubscribe       = 35
+	commandSetSinkVolume   = 36
+	commandSetSinkMute     = 39
+	commandSubscribeEvent  = 66
+)
+
+// Error codes sent to the client.
+const (
+	errAccessDenied = 1
+	errCommand      = 2
+	errInvalid      = 3
+	errNoEntity     = 5
+	errProtocol     = 7
+)
+
+// sinkChangeEvent is the subscription event type of a changed sink.
+const sinkChangeEvent = 0x0010
+
+const cookieLength = 256
+
+// Server is a fake PulseAudio server listening on a Unix socket. It supports the
+// authentication handshake, server and sink info queries, setting sink volume and mute,
+// and subscription events. Changes made by clients are reported to all subscribed clients.
+type Server struct {
+	tb     testing.TB
+	ln     net.Listener
+	addr   string
+	cookie string
+
+	mu          sync.Mutex
+	writeMu     sync.Mutex
+	conns       map[net.Conn]bool // connections and whether they subscribed to events
+	accepted    int
+	sinks       []pulseaudio.Sink
+	defaultSink string
+}
+
+// NewServer starts a server with a single stereo sink called "fake". The server is stopped
+// when the test ends.
+func NewServer(tb testing.TB) *Server {
+	tb.Helper()
+	dir := tb.TempDir()
+	s := &Server{
+		tb:     tb,
+		addr:   filepath.Join(dir, "native"),
+		cookie: filepath.Join(dir, "cookie"),
+		conns:  make(map[net.Conn]bool),
+		sinks: []pulseaudio.Sink{{
+			Index:       0,
+			Name:        "fake",
+			Description: "Fake Output",
+			SampleSpec:  pulseaudio.SampleSpec{Format: pulseaudio.SampleS16LE, Channels: 2, Rate: 44100},
+			ChannelMap:  pulseaudio.ChannelMap{1, 2},
+			CVolume:     pulseaudio.CVolume{0x8000, 0x8000},
+			BaseVolume:  0x10000,
+		}},
+		defaultSink: "fake",
+	}
+	if err := os.WriteFile(s.cookie, make([]byte, cookieLength), 0600); err != nil {
+		tb.Fatalf("could not write cookie: %v", err)
+	}
+	ln, err := net.Listen("unix", s.addr)
+	if err != nil {
+		tb.Fatalf("could not listen on %s: %v", s.addr, err)
+	}
+	s.ln = ln
+	go s.serve()
+	tb.Cleanup(s.Close)
+	return s
+}
+
+// Addr returns the server address in the format accepted by pulseaudio.Opts.Addr.
+func (s *Server) Addr() string {
+	return "unix://" + s.addr
+}
+
+// CookiePath returns the path of the authentication cookie accepted by the server.
+func (s *Server) CookiePath() string {
+	return s.cookie
+}
+
+// Opts returns client options for connecting to the server.
+func (s *Server) Opts() pulseaudio.Opts {
+	return pulseaudio.Opts{
+		Addr:   s.Addr(),
+		Cookie: s.cookie,
+	}
+}
+
+// SetSinks replaces the sinks of the server. The first sink becomes the default sink.
+func (s *Server) SetSinks(sinks ...pulseaudio.Sink) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sinks = append([]pulseaudio.Sink(nil), sinks...)
+	s.defaultSink = ""
+	if len(sinks) > 0 {
+		s.defaultSink = sinks[0].Name
+	}
+}
+
+// SetDefaultSink changes the default sink reported by the server info.
+func (s *Server) SetDefaultSink(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.defaultSink = name
+}
+
+// Sink returns the current state of the named sink.
+func (s *Server) Sink(name string) (pulseaudio.Sink, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sink := range s.sinks {
+		if sink.Name == name {
+			return sink, true
+		}
+	}
+	return pulseaudio.Sink{}, false
+}
+
+// UpdateSink modifies the named sink and notifies subscribed clients.
+func (s *Server) UpdateSink(name string, modify func(sink *pulseaudio.Sink)) bool {
+	index, ok := s.updateSink(0xffffffff, name, modify)
+	if ok {
+		s.SendEvent(sinkChangeEvent, index)
+	}
+	return ok
+}
+
+// SendEvent sends a subscription event to all subscribed clients.
+func (s *Server) SendEvent(event, index uint32) {
+	s.mu.Lock()
+	var conns []net.Conn
+	for conn, subscribed := range s.conns {
+		if subscribed {
+			conns = append(conns, conn)
+		}
+	}
+	s.mu.Unlock()
+	for _, conn := range conns {
+		if err := s.writeFrame(conn, commandSubscribeEvent, 0xffffffff,
+			tagstruct.Uint32Tag, event,
+			tagstruct.Uint32Tag, index); err != nil {
+			s.tb.Logf("fake server write error: %v", err)
+		}
+	}
+}
+
+// Connections returns the number of connections accepted so far.
+func (s *Server) Connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.accepted
+}
+
+// Drop closes all client connections, e.g. to test reconnecting.
+func (s *Server) Drop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.conns = make(map[net.Conn]bool)
+}
+
+// Close stops the server.
+func (s *Server) Close() {
+	_ = s.ln.Close()
+	s.Drop()
+}
+
+func (s *Server) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns[conn] = false
+		s.accepted++
+		s.mu.Unlock()
+		go s.serveConn(conn)
+	}
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	for {
+		header := make([]byte, 20)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		payload := make([]byte, binary.BigEndian.Uint32(header))
+		if _, err := io.ReadFull(conn, payload); err != nil {
+			return
+		}
+		if binary.BigEndian.Uint32(header[4:]) != 0xffffffff {
+			// stream data is not supported
+			continue
+		}
+		req := bytes.NewReader(payload)
+		var cmd, tag uint32
+		if _, err := tagstruct.Read(req, tagstruct.Uint32Tag, &cmd, tagstruct.Uint32Tag, &tag); err != nil {
+			return
+		}
+		reply, code := s.handle(conn, cmd, req)
+		rsp := uint32(commandReply)
+		if code != 0 {
+			rsp = commandError
+			reply = []interface{}{tagstruct.Uint32Tag, code}
+		}
+		if err := s.writeFrame(conn, rsp, tag, reply...); err != nil {
+			return
+		}
+	}
+}
+
+// handle answers a request with the values of the reply or an error code.
+func (s *Server) handle(conn net.Conn, cmd uint32, req io.Reader) ([]interface{}, uint32) {
+	switch cmd {
+	case commandAuth:
+		var clientVersion, length uint32
+		if _, err := tagstruct.Read(req, tagstruct.Uint32Tag, &clientVersion, tagstruct.ArbitraryTag, &length); err != nil {
+			return nil, errProtocol
+		}
+		cookie := make([]byte, length)
+		if _, err := io.ReadFull(req, cookie); err != nil {
+			return nil, errProtocol
+		}
+		want, err := os.ReadFile(s.cookie)
+		if err != nil || !bytes.Equal(cookie, want) {
+			return nil, errAccessDenied
+		}
+		return []interface{}{tagstruct.Uint32Tag, uint32(protocolVersion)}, 0
+	case commandSetClientName:
+		return []interface{}{tagstruct.Uint32Tag, uint32(1)}, 0 // client index
+	case commandGetServerInfo:
+		s.mu.Lock()
+		defaultSink := s.defaultSink
+		s.mu.Unlock()
+		return []interface{}{
+			tagstruct.StringTag, []byte("pulseaudio"), byte(0),
+			tagstruct.StringTag, []byte("16.1"), byte(0),
+			tagstruct.StringTag, []byte("user"), byte(0),
+			tagstruct.StringTag, []byte("host"), byte(0),
+			tagstruct.SampleSpecTag, byte(pulseaudio.SampleS16LE), byte(2), uint32(44100),
+			tagstruct.StringTag, []byte(defaultSink), byte(0),
+			tagstruct.StringTag, []byte(defaultSink + ".monitor"), byte(0),
+			tagstruct.Uint32Tag, uint32(0), // cookie
+			tagstruct.ChannelMapTag, byte(2), []byte{1, 2},
+		}, 0
+	case commandGetSinkInfoList:
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		var reply []interface{}
+		for _, sink := range s.sinks {
+			reply = append(reply, encodeSink(sink)...)
+		}
+		return reply, 0
+	case commandGetSinkInfo:
+		var index uint32
+		var name string
+		if _, err := tagstruct.Read(req, tagstruct.Uint32Tag, &index, tagstruct.StringTag, &name); err != nil {
+			return nil, errInvalid
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		sink := s.sink(index, name)
+		if sink == nil {
+			return nil, errNoEntity
+		}
+		return encodeSink(*sink), 0
+	case commandSetSinkVolume:
+		var index uint32
+		var name string
+		var cvolume pulseaudio.CVolume
+		if _, err := tagstruct.Read(req, tagstruct.Uint32Tag, &index, tagstruct.StringTag, &name, &cvolume); err != nil {
+			return nil, errInvalid
+		}
+		index, ok := s.updateSink(index, name, func(sink *pulseaudio.Sink) { sink.CVolume = cvolume })
+		if !ok {
+			return nil, errNoEntity
+		}
+		go s.SendEvent(sinkChangeEvent, index)
+	case commandSetSinkMute:
+		var index uint32
+		var name string
+		var mute bool
+		if _, err := tagstruct.Read(req, tagstruct.Uint32Tag, &index, tagstruct.StringTag, &name, &mute); err != nil {
+			return nil, errInvalid
+		}
+		index, ok := s.updateSink(index, name, func(sink *pulseaudio.Sink) { sink.Muted = mute })
+		if !ok {
+			return nil, errNoEntity
+		}
+		go s.SendEvent(sinkChangeEvent, index)
+	case commandSubscribe:
+		var mask uint32
+		if _, err := tagstruct.Read(req, tagstruct.Uint32Tag, &mask); err != nil {
+			return nil, errInvalid
+		}
+		s.mu.Lock()
+		if _, ok := s.conns[conn]; ok {
+			s.conns[conn] = mask != 0
+		}
+		s.mu.Unlock()
+	default:
+		return nil, errCommand
+	}
+	return nil, 0
+}
+
+// sink looks up a sink by index, or by name if the index is invalid. s.mu must be held.
+func (s *Server) sink(index uint32, name string) *pulseaudio.Sink {
+	for i := range s.sinks {
+		if (index != 0xffffffff && s.sinks[i].Index == index) || (index == 0xffffffff && s.sinks[i].Name == name) {
+			return &s.sinks[i]
+		}
+	}
+	return nil
+}
+
+// updateSink modifies a sink and returns its index.
+func (s *Server) updateSink(index uint32, name string, modify func(sink *pulseaudio.Sink)) (uint32, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sink := s.sink(index, name)
+	if sink == nil {
+		return 0, false
+	}
+	modify(sink)
+	return sink.Index, true
+}
+
+func (s *Server) writeFrame(conn net.Conn, cmd, tag uint32, payload ...interface{}) error {
+	var b bytes.Buffer
+	if err := tagstruct.Write(&b, tagstruct.Uint32Tag, cmd, tagstruct.Uint32Tag, tag); err != nil {
+		return err
+	}
+	if err := tagstruct.Write(&b, payload...); err != nil {
+		return err
+	}
+	header := make([]byte, 20)
+	binary.BigEndian.PutUint32(header, uint32(b.Len()))
+	binary.BigEndian.PutUint32(header[4:], 0xffffffff) // control channel
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	_, err := conn.Write(append(header, b.Bytes()...))
+	return err
+}
+
+// encodeSink returns the values of a sink in the format of the sink info replies.
+func encodeSink(sink pulseaudio.Sink) []interface{} {
+	v := []interface{}{
+		tagstruct.Uint32Tag, sink.Index,
+		tagstruct.StringTag, []byte(sink.Name), byte(0),
+		tagstruct.StringTag, []byte(sink.Description), byte(0),
+		tagstruct.SampleSpecTag, byte(sink.SampleSpec.Format), sink.SampleSpec.Channels, sink.SampleSpec.Rate,
+		tagstruct.ChannelMapTag, byte(len(sink.ChannelMap)), []byte(sink.ChannelMap),
+		tagstruct.Uint32Tag, sink.ModuleIndex,
+		tagstruct.CVolumeTag, byte(len(sink.CVolume)), []uint32(sink.CVolume),
+		boolTag(sink.Muted),
+		tagstruct.Uint32Tag, sink.MonitorSourceIndex,
+		tagstruct.StringTag, []byte(sink.MonitorSourceName), byte(0),
+		tagstruct.UsecTag, sink.Latency,
+		tagstruct.StringTag, []byte(sink.Driver), byte(0),
+		tagstruct.Uint32Tag, uint32(sink.Flags),
+		sink.PropList,
+		tagstruct.UsecTag, sink.RequestedLatency,
+		tagstruct.VolumeTag, sink.BaseVolume,
+		tagstruct.Uint32Tag, uint32(sink.SinkState),
+		tagstruct.Uint32Tag, sink.NVolumeSteps,
+		tagstruct.Uint32Tag, sink.CardIndex,
+		tagstruct.Uint32Tag, uint32(len(sink.Ports)),
+	}
+	for _, port := range sink.Ports {
+		v = append(v,
+			tagstruct.StringTag, []byte(port.Name), byte(0),
+			tagstruct.StringTag, []byte(port.Description), byte(0),
+			tagstruct.Uint32Tag, port.Priority,
+			tagstruct.Uint32Tag, port.Available)
+	}
+	if len(sink.Ports) == 0 {
+		v = append(v, tagstruct.StringNullTag)
+	} else {
+		v = append(v, tagstruct.StringTag, []byte(sink.ActivePortName), byte(0))
+	}
+	return append(v, tagstruct.Uint8Tag, uint8(0)) // formats
+}
+
+func boolTag(b bool) tagstruct.Tag {
+	if b {
+		return tagstruct.TrueTag
+	}
+	return tagstruct.FalseTag
+}
@@ -0,0 +1,114 @@
+package pulseaudiotest_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gophertribe/pulseaudio"
+	"github.com/gophertribe/pulseaudio/pulseaudiotest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newClient(t *testing.T, srv *pulseaudiotest.Server) *pulseaudio.Client {
+	t.Helper()
+	opts := srv.Opts()
+	opts.RequestTimeout = time.Second
+	c := pulseaudio.NewClient(opts, pulseaudio.WithReconnectInterval(10*time.Millisecond))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- c.Run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	require.Eventually(t, func() bool {
+		_, err := c.ServerInfo(ctx)
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+	return c
+}
+
+func TestServer(t *testing.T) {
+	srv := pulseaudiotest.NewServer(t)
+	c := newClient(t, srv)
+	ctx := context.Background()
+
+	updates, err := c.SubscribeEvents(ctx)
+	require.NoError(t, err)
+
+	require.NoError(t, c.SetVolume(ctx, 0.25))
+	vol, err := c.Volume(ctx)
+	require.NoError(t, err)
+	assert.InDelta(t, 0.25, vol, 0.001)
+	select {
+	case <-updates:
+	case <-time.After(time.Second):
+		t.Fatal("volume change was not reported")
+	}
+
+	require.NoError(t, c.SetMute(ctx, true))
+	muted, err := c.Mute(ctx)
+	require.NoError(t, err)
+	assert.True(t, muted)
+	sink, ok := srv.Sink("fake")
+	require.True(t, ok)
+	assert.True(t, sink.Muted)
+
+	srv.SetSinks(
+		pulseaudio.Sink{Index: 1, Name: "speakers", ChannelMap: pulseaudio.ChannelMap{1, 2}, CVolume: pulseaudio.CVolume{0x10000, 0x10000}},
+		pulseaudio.Sink{Index: 2, Name: "headphones", ChannelMap: pulseaudio.ChannelMap{1, 2}, CVolume: pulseaudio.CVolume{0, 0}},
+	)
+	srv.SetDefaultSink("headphones")
+	sinks, err := c.Sinks(ctx)
+	require.NoError(t, err)
+	require.Len(t, sinks, 2)
+	assert.Equal(t, "speakers", sinks[0].Name)
+	single, err := c.GetSinkByName(ctx, "headphones")
+	require.NoError(t, err)
+	assert.Equal(t, uint32(2), single.Index)
+	vol, err = c.Volume(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, float32(0), vol)
+
+	assert.True(t, srv.UpdateSink("headphones", func(sink *pulseaudio.Sink) { sink.Muted = true }))
+	select {
+	case <-updates:
+	case <-time.After(time.Second):
+		t.Fatal("sink update was not reported")
+	}
+	_, err = c.GetSinkByName(ctx, "missing")
+	assert.Error(t, err)
+}
+
+func TestServerReconnect(t *testing.T) {
+	srv := pulseaudiotest.NewServer(t)
+	c := newClient(t, srv)
+	ctx := context.Background()
+
+	srv.Drop()
+	require.Eventually(t, func() bool { return srv.Connections() == 2 }, time.Second, time.Millisecond)
+	require.Eventually(t, func() bool {
+		_, err := c.ServerInfo(ctx)
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestServerRejectsWrongCookie(t *testing.T) {
+	srv := pulseaudiotest.NewServer(t)
+	opts := srv.Opts()
+	opts.Cookie = filepath.Join(t.TempDir(), "cookie")
+	require.NoError(t, os.WriteFile(opts.Cookie, bytes.Repeat([]byte{1}, 256), 0600))
+	opts.RequestTimeout = 100 * time.Millisecond
+	c := pulseaudio.NewClient(opts, pulseaudio.WithReconnectInterval(10*time.Millisecond))
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	go func() { _ = c.Run(ctx) }()
+	require.Eventually(t, func() bool { return srv.Connections() >= 2 }, time.Second, time.Millisecond)
+	_, err := c.ServerInfo(ctx)
+	assert.Error(t, err)
+}
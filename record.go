@@ -0,0 +1,60 @@
+package pulseaudio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// record passes a received frame (header included) to the frame recorder.
+func (c *Client) record(frame []byte) {
+	if c.recorder == nil {
+		return
+	}
+	if _, err := c.recorder.Write(frame); err != nil {
+		c.logger.Errorf("could not record frame: %v", err)
+	}
+}
+
+// ReplayReader reads frames recorded with WithFrameRecorder. Replies can be decoded with
+// the ReadFrom methods of the info types, e.g. Sink.ReadFrom for a sink list.
+type ReplayReader struct {
+	r io.Reader
+}
+
+// NewReplayReader returns a reader for the recording in r.
+func NewReplayReader(r io.Reader) *ReplayReader {
+	return &ReplayReader{r: r}
+}
+
+// NextReply skips to the next reply sent by the server and returns its tag and body.
+// It returns io.EOF at the end of the recording.
+func (r *ReplayReader) NextReply() (uint32, *bytes.Buffer, error) {
+	for {
+		header := make([]byte, 20)
+		if _, err := io.ReadFull(r.r, header); err != nil {
+			return 0, nil, err
+		}
+		n := binary.BigEndian.Uint32(header)
+		if n > frameSizeMaxLimit {
+			return 0, nil, fmt.Errorf("recorded frame size %d is too long (only %d allowed)", n, frameSizeMaxLimit)
+		}
+		b := bytes.NewBuffer(make([]byte, 0, n))
+		if _, err := io.CopyN(b, r.r, int64(n)); err != nil {
+			return 0, nil, fmt.Errorf("recording is truncated: %w", err)
+		}
+		if binary.BigEndian.Uint32(header[4:]) != controlChannel {
+			// stream data
+			continue
+		}
+		var cmd command
+		var tag uint32
+		if err := bread(b, uint32Tag, &cmd, uint32Tag, &tag); err != nil {
+			return 0, nil, err
+		}
+		if cmd == commandReply {
+			return tag, b, nil
+		}
+	}
+}
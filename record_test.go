@@ -0,0 +1,144 @@
+package pulseaudio
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// lockedBuffer is a buffer which the receive loop can write to while the test reads it.
+type lockedBuffer struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (l *lockedBuffer) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.b.Write(p)
+}
+
+func (l *lockedBuffer) Bytes() []byte {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]byte(nil), l.b.Bytes()...)
+}
+
+func TestFrameRecorder(t *testing.T) {
+	srv := newFakeServer(t)
+	var rec lockedBuffer
+	c := newFakeClient(t, srv, WithFrameRecorder(&rec))
+
+	sinks, err := c.Sinks(context.Background())
+	require.NoError(t, err)
+
+	// auth, client name and the sink list
+	r := NewReplayReader(bytes.NewReader(rec.Bytes()))
+	var bodies []*bytes.Buffer
+	for {
+		_, body, err := r.NextReply()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		bodies = append(bodies, body)
+	}
+	require.Len(t, bodies, 3)
+	var replayed Sink
+	require.NoError(t, bread(bodies[2], &replayed))
+	assert.Equal(t, sinks[0], replayed)
+}
+
+var recordSinks = flag.Bool("record", false, "capture the sink list of the local PulseAudio server into testdata")
+
+// TestRecordSinks captures the sink list reply of the local PulseAudio server to
+// testdata/sinks-pulseaudio-<version>.bin, which TestReplaySinks decodes from then on. Run it
+// with go test -run TestRecordSinks -record.
+func TestRecordSinks(t *testing.T) {
+	if !*recordSinks {
+		t.Skip("run with -record to capture the sink list of the local server")
+	}
+	var rec lockedBuffer
+	c := NewClient(Opts{RequestTimeout: 5 * time.Second}, WithFrameRecorder(&rec))
+	defer c.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	require.NoError(t, c.Dial(ctx))
+	server, err := c.ServerInfo(ctx)
+	require.NoError(t, err)
+
+	// keep only the reply to the sink list
+	start := len(rec.Bytes())
+	_, err = c.Sinks(ctx)
+	require.NoError(t, err)
+	name := filepath.Join("testdata", "sinks-pulseaudio-"+server.PackageVersion+".bin")
+	require.NoError(t, os.WriteFile(name, rec.Bytes()[start:], 0644))
+	t.Logf("recorded the sinks of %s %s to %s", server.PackageName, server.PackageVersion, name)
+}
+
+// replaySinks decodes the sinks of the first reply recorded in the file.
+func replaySinks(t *testing.T, name string) []Sink {
+	t.Helper()
+	f, err := os.Open(name)
+	require.NoError(t, err)
+	defer func() { _ = f.Close() }()
+
+	_, body, err := NewReplayReader(f).NextReply()
+	require.NoError(t, err)
+	var sinks []Sink
+	for body.Len() > 0 {
+		var sink Sink
+		require.NoError(t, bread(body, &sink))
+		sinks = append(sinks, sink)
+	}
+	return sinks
+}
+
+// TestReplaySinks decodes the sink lists captured from real servers with TestRecordSinks.
+func TestReplaySinks(t *testing.T) {
+	captures, err := filepath.Glob("testdata/sinks-pulseaudio-*.bin")
+	require.NoError(t, err)
+	if len(captures) == 0 {
+		t.Skip("no captures of real servers in testdata, record one with TestRecordSinks")
+	}
+	for _, name := range captures {
+		t.Run(filepath.Base(name), func(t *testing.T) {
+			sinks := replaySinks(t, name)
+			require.NotEmpty(t, sinks)
+			for _, sink := range sinks {
+				assert.NotEmpty(t, sink.Name)
+				assert.Len(t, sink.ChannelMap, int(sink.SampleSpec.Channels), sink.Name)
+				assert.Len(t, sink.CVolume, int(sink.SampleSpec.Channels), sink.Name)
+			}
+		})
+	}
+}
+
+// TestReplaySinksSynthetic decodes a hand-encoded sink list reply. Unlike the captures decoded by
+// TestReplaySinks it was encoded following the sink layout of PulseAudio 16, not captured from a
+// server, so it only guards against decode regressions of that layout.
+func TestReplaySinksSynthetic(t *testing.T) {
+	sinks := replaySinks(t, "testdata/sinks-synthetic.bin")
+	require.Len(t, sinks, 1)
+	sink := sinks[0]
+	assert.Equal(t, "alsa_output.pci-0000_00_1f.3.analog-stereo", sink.Name)
+	assert.Equal(t, "Built-in Audio Analog Stereo", sink.PropList["device.description"])
+	assert.Equal(t, SampleSpec{Format: SampleS16LE, Channels: 2, Rate: 48000}, sink.SampleSpec)
+	assert.Equal(t, CVolume{42597, 42597}, sink.CVolume)
+	assert.True(t, sink.Flags.Has(SinkHardware|SinkDecibelVolume))
+	assert.Equal(t, SinkStateIdle, sink.SinkState)
+	require.Len(t, sink.Ports, 2)
+	assert.Equal(t, "analog-output-headphones", sink.Ports[1].Name)
+	assert.Equal(t, "analog-output-speaker", sink.ActivePortName)
+	require.Len(t, sink.Formats, 1)
+	assert.Equal(t, FormatEncodingPCM, sink.Formats[0].Encoding)
+}
@@ -0,0 +1,276 @@
+package pulseaudio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Subscription event bits sent with commandSubscribeEvent.
+const (
+	subscriptionFacilityMask = 0x000f
+	subscriptionTypeMask     = 0x0030
+)
+
+// WithAutoRescue makes the client move the sink inputs of a removed sink to the default sink.
+// The client subscribes to server events on every connection and remembers which sink every
+// sink input plays on. When a sink is removed, the sink inputs it had are moved to the default
+// sink, both those still attached to it and those the server already moved to its fallback sink.
+func WithAutoRescue() ClientOpt {
+	return func(client *Client) {
+		client.autoRescue = true
+	}
+}
+
+// MoveSinkInput moves a playing stream to the sink with the given index.
+func (c *Client) MoveSinkInput(ctx context.Context, inputIndex, sinkIndex uint32) error {
+	_, err := c.request(ctx, commandMoveSinkInput,
+		uint32Tag, inputIndex,
+		uint32Tag, sinkIndex,
+		stringNullTag)
+	return err
+}
+
+// MoveSinkInputByName moves a playing stream to the named sink. The name is resolved by the
+// server, so the sink can't be replaced by another one between a lookup and the move.
+func (c *Client) MoveSinkInputByName(ctx context.Context, inputIndex uint32, sinkName string) error {
+	_, err := c.request(ctx, commandMoveSinkInput,
+		uint32Tag, inputIndex,
+		uint32Tag, uint32(0xffffffff),
+		stringTag, []byte(sinkName), byte(0))
+	return err
+}
+
+// RescueStreams moves the sink inputs which were playing on the removed sink to the default sink.
+// Sink inputs the server has already moved to another sink are left alone.
+func (c *Client) RescueStreams(ctx context.Context, removedSinkIndex uint32) error {
+	inputs, err := c.SinkInputs(ctx)
+	if err != nil {
+		return err
+	}
+	var orphaned []SinkInput
+	for _, input := range inputs {
+		if input.SinkIndex == removedSinkIndex {
+			orphaned = append(orphaned, input)
+		}
+	}
+	if len(orphaned) == 0 {
+		return nil
+	}
+	server, err := c.ServerInfo(ctx)
+	if err != nil {
+		return err
+	}
+	if server.DefaultSink == "" {
+		return fmt.Errorf("PulseAudio error: no default sink to rescue streams of sink %d", removedSinkIndex)
+	}
+	for _, input := range orphaned {
+		if err := c.MoveSinkInputByName(ctx, input.Index, server.DefaultSink); err != nil {
+			return fmt.Errorf("could not move sink input %d to %s: %w", input.Index, server.DefaultSink, err)
+		}
+	}
+	return nil
+}
+
+// MoveAllSinkInputs moves every stream playing on fromSink to toSink. It carries on if a stream
+// can't be moved and returns the errors of all failed moves.
+func (c *Client) MoveAllSinkInputs(ctx context.Context, fromSink, toSink string) error {
+	from, err := c.GetSinkByName(ctx, fromSink)
+	if err != nil {
+		return err
+	}
+	inputs, err := c.SinkInputs(ctx)
+	if err != nil {
+		return err
+	}
+	var errs multiError
+	for _, input := range inputs {
+		if input.SinkIndex != from.Index {
+			continue
+		}
+		if err := c.MoveSinkInputByName(ctx, input.Index, toSink); err != nil {
+			errs = append(errs, fmt.Errorf("could not move sink input %d to %s: %w", input.Index, toSink, err))
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// multiError joins several errors. Built with Go 1.20 or later, errors.Is and errors.As look at
+// each of them through Unwrap; older versions only see the joined message.
+type multiError []error
+
+func (e multiError) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+func (e multiError) Unwrap() []error {
+	return e
+}
+
+// rescuer implements WithAutoRescue. It records the sink of every sink input from the sink input
+// events, so that it still knows which sink inputs a sink had once the server reports its
+// removal. The events are recorded on the receive goroutine and applied by its own goroutine.
+type rescuer struct {
+	c    *Client
+	wake chan struct{}
+
+	mu sync.Mutex
+	// sinks maps sink input indexes to the index of their sink
+	sinks map[uint32]uint32
+	// the events which have not been applied yet
+	pendingInputs map[uint32]Operation
+	removedSinks  []uint32
+	reload        bool
+}
+
+func (c *Client) startRescuer() {
+	r := &rescuer{
+		c:             c,
+		wake:          make(chan struct{}, 1),
+		sinks:         make(map[uint32]uint32),
+		pendingInputs: make(map[uint32]Operation),
+	}
+	c.rescue = r
+	c.addEventHandler(r.record, r.invalidate)
+	go r.run()
+}
+
+func (r *rescuer) record(facility Facility, operation Operation, index uint32) {
+	r.mu.Lock()
+	switch {
+	case facility == FacilitySinkInput:
+		r.pendingInputs[index] = operation
+	case facility == FacilitySink && operation == OpRemove:
+		r.removedSinks = append(r.removedSinks, index)
+	default:
+		r.mu.Unlock()
+		return
+	}
+	r.mu.Unlock()
+	r.signal()
+}
+
+// invalidate makes the rescuer list the sink inputs again after a reconnection.
+func (r *rescuer) invalidate() {
+	r.mu.Lock()
+	r.reload = true
+	r.mu.Unlock()
+	r.signal()
+}
+
+func (r *rescuer) signal() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+		// the rescuer is already woken up
+	}
+}
+
+func (r *rescuer) run() {
+	for {
+		select {
+		case <-r.c.done:
+			return
+		case <-r.wake:
+		}
+		r.apply(context.Background())
+	}
+}
+
+func (r *rescuer) apply(ctx context.Context) {
+	r.mu.Lock()
+	inputs, removed, reload := r.pendingInputs, r.removedSinks, r.reload
+	r.pendingInputs = make(map[uint32]Operation)
+	r.removedSinks = nil
+	r.reload = false
+	r.mu.Unlock()
+
+	if reload {
+		all, err := r.c.SinkInputs(ctx)
+		if err != nil {
+			r.c.logger.Errorf("could not list sink inputs to rescue: %v", err)
+		} else {
+			sinks := make(map[uint32]uint32, len(all))
+			for _, input := range all {
+				sinks[input.Index] = input.SinkIndex
+			}
+			r.mu.Lock()
+			r.sinks = sinks
+			r.mu.Unlock()
+		}
+	}
+	for index, operation := range inputs {
+		r.update(ctx, index, operation)
+	}
+	for _, sinkIndex := range removed {
+		r.mu.Lock()
+		var orphaned []uint32
+		for input, sink := range r.sinks {
+			if sink == sinkIndex {
+				orphaned = append(orphaned, input)
+			}
+		}
+		r.mu.Unlock()
+		for _, input := range orphaned {
+			r.rescueInput(ctx, input, sinkIndex)
+		}
+	}
+}
+
+// update records the sink of a new or changed sink input. A sink input which left a sink that no
+// longer exists was moved by the server when the sink was removed, and is rescued.
+func (r *rescuer) update(ctx context.Context, index uint32, operation Operation) {
+	var input *SinkInput
+	var err error
+	if operation != OpRemove {
+		input, err = r.c.getSinkInputByIndex(ctx, index)
+	}
+	if err != nil && !errors.Is(err, ErrNoSuchEntity) {
+		r.c.logger.Errorf("could not look up sink input %d to rescue: %v", index, err)
+		return
+	}
+	r.mu.Lock()
+	previous, known := r.sinks[index]
+	if input == nil {
+		delete(r.sinks, index)
+	} else {
+		r.sinks[index] = input.SinkIndex
+	}
+	r.mu.Unlock()
+	if input == nil || !known || previous == input.SinkIndex {
+		return
+	}
+	_, err = r.c.getSinkByIndex(ctx, previous)
+	if errors.Is(err, ErrNoSuchEntity) {
+		r.rescueInput(ctx, index, previous)
+	}
+}
+
+// rescueInput moves a sink input of the removed sink to the default sink.
+func (r *rescuer) rescueInput(ctx context.Context, index, removedSinkIndex uint32) {
+	server, err := r.c.ServerInfo(ctx)
+	if err == nil && server.DefaultSink == "" {
+		err = fmt.Errorf("PulseAudio error: no default sink to rescue streams of sink %d", removedSinkIndex)
+	}
+	if err == nil {
+		err = r.c.MoveSinkInputByName(ctx, index, server.DefaultSink)
+	}
+	switch {
+	case errors.Is(err, ErrNoSuchEntity):
+		// the sink input was killed with its sink
+		r.mu.Lock()
+		delete(r.sinks, index)
+		r.mu.Unlock()
+	case err != nil:
+		r.c.logger.Errorf("could not rescue sink input %d of sink %d: %v", index, removedSinkIndex, err)
+	}
+}
@@ -0,0 +1,179 @@
+package pulseaudio
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sinkMove is a commandMoveSinkInput request seen by the fake server.
+type sinkMove struct {
+	input uint32
+	sink  string
+}
+
+func handleMoves(srv *fakeServer) <-chan sinkMove {
+	moves := make(chan sinkMove, 4)
+	srv.handle(commandMoveSinkInput, func(req *bytes.Buffer) ([]interface{}, uint32) {
+		var m sinkMove
+		var sinkIndex uint32
+		if err := bread(req, uint32Tag, &m.input, uint32Tag, &sinkIndex, stringTag, &m.sink); err != nil {
+			return nil, 3 // invalid argument
+		}
+		moves <- m
+		return nil, 0
+	})
+	return moves
+}
+
+func TestRescueStreams(t *testing.T) {
+	srv := newFakeServer(t)
+	orphan := fakeSinkInput()
+	orphan.SinkIndex = 5
+	other := fakeSinkInput()
+	other.Index = 8
+	srv.inputs = append(srv.inputs, orphan, other)
+	moves := handleMoves(srv)
+	c := newFakeClient(t, srv)
+
+	require.NoError(t, c.RescueStreams(context.Background(), 5))
+	require.Len(t, moves, 1)
+	assert.Equal(t, sinkMove{input: 7, sink: "fake"}, <-moves)
+
+	require.NoError(t, c.RescueStreams(context.Background(), 6))
+	assert.Len(t, moves, 0)
+}
+
+// rescuedSink returns the sink the rescuer of c has recorded for a sink input.
+func rescuedSink(c *Client, input uint32) (uint32, bool) {
+	c.rescue.mu.Lock()
+	defer c.rescue.mu.Unlock()
+	sink, ok := c.rescue.sinks[input]
+	return sink, ok
+}
+
+func TestAutoRescue(t *testing.T) {
+	srv := newFakeServer(t)
+	orphan := fakeSinkInput()
+	orphan.SinkIndex = 5
+	srv.inputs = append(srv.inputs, orphan)
+	moves := handleMoves(srv)
+	c := newFakeClient(t, srv, WithAutoRescue())
+	require.Eventually(t, func() bool {
+		sink, ok := rescuedSink(c, 7)
+		return ok && sink == 5
+	}, time.Second, 10*time.Millisecond, "the sink inputs are loaded on connecting")
+
+	// a sink change is ignored, the removal of sink 5 triggers the rescue of the stream still attached to it
+	srv.broadcast(commandSubscribeEvent, uint32Tag, uint32(0x0010), uint32Tag, uint32(5))
+	srv.broadcast(commandSubscribeEvent, uint32Tag, uint32(0x0020), uint32Tag, uint32(5))
+	select {
+	case m := <-moves:
+		assert.Equal(t, sinkMove{input: 7, sink: "fake"}, m)
+	case <-time.After(time.Second):
+		t.Fatal("sink input was not moved")
+	}
+}
+
+func TestAutoRescueMovedByServer(t *testing.T) {
+	srv := newFakeServer(t)
+	speakers := srv.sinks[0]
+	speakers.Index, speakers.Name = 1, "speakers"
+	hdmi := srv.sinks[0]
+	hdmi.Index, hdmi.Name = 2, "hdmi"
+	srv.sinks = append(srv.sinks, speakers, hdmi)
+	input := fakeSinkInput()
+	input.SinkIndex = 1
+	srv.inputs = append(srv.inputs, input)
+	moves := handleMoves(srv)
+	c := newFakeClient(t, srv, WithAutoRescue())
+	require.Eventually(t, func() bool {
+		sink, ok := rescuedSink(c, 7)
+		return ok && sink == 1
+	}, time.Second, 10*time.Millisecond, "the sink inputs are loaded on connecting")
+
+	// the server removes the speakers and moves the stream to its fallback sink itself
+	srv.mu.Lock()
+	srv.sinks = append(srv.sinks[:1], srv.sinks[2])
+	srv.inputs[0].SinkIndex = 2
+	srv.mu.Unlock()
+	srv.broadcast(commandSubscribeEvent, uint32Tag, uint32(0x0012), uint32Tag, uint32(7))
+	srv.broadcast(commandSubscribeEvent, uint32Tag, uint32(0x0020), uint32Tag, uint32(1))
+	select {
+	case m := <-moves:
+		assert.Equal(t, sinkMove{input: 7, sink: "fake"}, m)
+	case <-time.After(time.Second):
+		t.Fatal("sink input was not moved")
+	}
+	require.Eventually(t, func() bool {
+		sink, _ := rescuedSink(c, 7)
+		return sink == 2
+	}, time.Second, 10*time.Millisecond)
+	assert.Len(t, moves, 0, "the stream must be moved once")
+}
+
+func TestMoveAllSinkInputs(t *testing.T) {
+	srv := newFakeServer(t)
+	for i, sinkIndex := range []uint32{0, 1, 0, 0} {
+		input := fakeSinkInput()
+		input.Index = uint32(10 + i)
+		input.SinkIndex = sinkIndex
+		srv.inputs = append(srv.inputs, input)
+	}
+	moves := make(chan sinkMove, 4)
+	srv.handle(commandMoveSinkInput, func(req *bytes.Buffer) ([]interface{}, uint32) {
+		var m sinkMove
+		var sinkIndex uint32
+		if err := bread(req, uint32Tag, &m.input, uint32Tag, &sinkIndex, stringTag, &m.sink); err != nil {
+			return nil, 3 // invalid argument
+		}
+		if m.input == 12 {
+			return nil, 5 // no such entity, e.g. the stream ended meanwhile
+		}
+		moves <- m
+		return nil, 0
+	})
+	c := newFakeClient(t, srv)
+
+	err := c.MoveAllSinkInputs(context.Background(), "fake", "zone2")
+	assert.ErrorIs(t, err, ErrNoSuchEntity)
+	assert.Contains(t, err.Error(), "sink input 12")
+	require.Len(t, moves, 2)
+	assert.Equal(t, sinkMove{input: 10, sink: "zone2"}, <-moves)
+	assert.Equal(t, sinkMove{input: 13, sink: "zone2"}, <-moves)
+
+	assert.ErrorIs(t, c.MoveAllSinkInputs(context.Background(), "missing", "zone2"), ErrNoSuchEntity)
+}
+
+func TestMoveSinkInputByName(t *testing.T) {
+	srv := newFakeServer(t)
+	sinkIndices := make(chan uint32, 1)
+	srv.handle(commandMoveSinkInput, func(req *bytes.Buffer) ([]interface{}, uint32) {
+		var input, sinkIndex uint32
+		var name string
+		if err := bread(req, uint32Tag, &input, uint32Tag, &sinkIndex, stringTag, &name); err != nil {
+			return nil, 3 // invalid argument
+		}
+		sinkIndices <- sinkIndex
+		if input != 4 || (name != "fake" && sinkIndex != 0) {
+			return nil, 5 // no such entity
+		}
+		return nil, 0
+	})
+	c := newFakeClient(t, srv)
+	ctx := context.Background()
+
+	require.NoError(t, c.MoveSinkInputByName(ctx, 4, "fake"))
+	assert.Equal(t, uint32(0xffffffff), <-sinkIndices, "the name must be resolved by the server")
+	assert.ErrorIs(t, c.MoveSinkInputByName(ctx, 4, "missing"), ErrNoSuchEntity)
+	<-sinkIndices
+
+	require.NoError(t, c.MoveSinkInput(ctx, 4, 0))
+	assert.Equal(t, uint32(0), <-sinkIndices)
+	assert.ErrorIs(t, c.MoveSinkInput(ctx, 4, 9), ErrNoSuchEntity)
+	<-sinkIndices
+}
@@ -13,11 +13,10 @@ import (
 
 func TestExample(t *testing.T) {
 	client := NewClient(Opts{Logger: stdoutLogger{}})
-	var wg sync.WaitGroup
-	client.Connect(context.TODO(), 10*time.Second, &wg)
-	client.Close()
-	wg.Wait()
+	ctx, cancel := context.WithCancel(context.Background())
+	go client.Run(ctx)
 	// Use `client` to interact with PulseAudio
+	cancel()
 }
 
 func TestOutputs(t *testing.T) {
@@ -57,7 +56,7 @@ func TestExampleClient_SetVolume(t *testing.T) {
 
 	vol, err := c.Volume(ctx)
 	assert.NoError(t, err)
-	assert.Equal(t, 1.4999, vol, "wrong volume value")
+	assert.Equal(t, float32(1.5), vol, "wrong volume value")
 
 	c.Close()
 	wg.Wait()
@@ -0,0 +1,58 @@
+package pulseaudio
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// SampleCacheEntry is a sample stored in the sample cache of the server.
+type SampleCacheEntry struct {
+	Index      uint32
+	Name       string
+	CVolume    CVolume
+	Duration   time.Duration
+	SampleSpec SampleSpec
+	ChannelMap ChannelMap
+	// Length of the sample data in bytes
+	Length uint32
+	// Lazy samples are loaded from Filename when they are played
+	Lazy     bool
+	Filename string
+	PropList map[string]string
+}
+
+func (s *SampleCacheEntry) ReadFrom(r io.Reader) (int64, error) {
+	var usec uint64
+	err := breadStruct(r, s,
+		uint32Tag, &s.Index,
+		stringTag, &s.Name,
+		&s.CVolume,
+		usecTag, &usec,
+		&s.SampleSpec,
+		&s.ChannelMap,
+		uint32Tag, &s.Length,
+		&s.Lazy,
+		stringTag, &s.Filename,
+		&s.PropList)
+	s.Duration = time.Duration(usec) * time.Microsecond
+	return 0, err
+}
+
+// Samples returns the entries of the sample cache.
+func (c *Client) Samples(ctx context.Context) ([]SampleCacheEntry, error) {
+	b, err := c.request(ctx, commandGetSampleInfoList)
+	if err != nil {
+		return nil, err
+	}
+	var samples []SampleCacheEntry
+	for b.Len() > 0 {
+		var sample SampleCacheEntry
+		err = decodeReply(commandGetSampleInfoList, b, &sample)
+		if err != nil {
+			return nil, err
+		}
+		samples = append(samples, sample)
+	}
+	return samples, nil
+}
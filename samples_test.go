@@ -0,0 +1,59 @@
+package pulseaudio
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSamples(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.handle(commandGetSampleInfoList, func(*bytes.Buffer) ([]interface{}, uint32) {
+		return []interface{}{
+			uint32Tag, uint32(0),
+			stringTag, []byte("bell"), byte(0),
+			CVolume{0x10000, 0x10000},
+			usecTag, uint64(250000),
+			SampleSpec{Format: SampleS16LE, Channels: 2, Rate: 44100},
+			ChannelMap{1, 2},
+			uint32Tag, uint32(44100),
+			falseTag,
+			stringNullTag,
+			map[string]string{"media.role": "event"},
+
+			uint32Tag, uint32(1),
+			stringTag, []byte("login"), byte(0),
+			CVolume{0x8000},
+			usecTag, uint64(1500000),
+			SampleSpec{Format: SampleS16LE, Channels: 1, Rate: 48000},
+			ChannelMap{0},
+			uint32Tag, uint32(0),
+			trueTag,
+			stringTag, []byte("/usr/share/sounds/login.wav"), byte(0),
+			map[string]string{},
+		}, 0
+	})
+	c := newFakeClient(t, srv)
+
+	samples, err := c.Samples(context.Background())
+	require.NoError(t, err)
+	require.Len(t, samples, 2)
+	assert.Equal(t, SampleCacheEntry{
+		Index:      0,
+		Name:       "bell",
+		CVolume:    CVolume{0x10000, 0x10000},
+		Duration:   250 * time.Millisecond,
+		SampleSpec: SampleSpec{Format: SampleS16LE, Channels: 2, Rate: 44100},
+		ChannelMap: ChannelMap{1, 2},
+		Length:     44100,
+		PropList:   map[string]string{"media.role": "event"},
+	}, samples[0])
+	assert.Equal(t, "login", samples[1].Name)
+	assert.Equal(t, 1500*time.Millisecond, samples[1].Duration)
+	assert.True(t, samples[1].Lazy)
+	assert.Equal(t, "/usr/share/sounds/login.wav", samples[1].Filename)
+}
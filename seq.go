@@ -0,0 +1,54 @@
+//go:build go1.23
+// +build go1.23
+
+package pulseaudio
+
+import (
+	"context"
+	"iter"
+)
+
+// SinksSeq returns an iterator over the sinks which decodes one sink at a time, so a caller
+// looking for a particular sink can stop early without decoding the rest of the list.
+// PulseAudio sends the list as a single reply frame, so the reply itself is still received
+// as a whole. A request or decode error is yielded as the last element.
+func (c *Client) SinksSeq(ctx context.Context) iter.Seq2[Sink, error] {
+	return func(yield func(Sink, error) bool) {
+		b, err := c.request(ctx, commandGetSinkInfoList)
+		if err != nil {
+			yield(Sink{}, err)
+			return
+		}
+		for b.Len() > 0 {
+			var sink Sink
+			if err := decodeReply(commandGetSinkInfoList, b, &sink); err != nil {
+				yield(Sink{}, err)
+				return
+			}
+			if !yield(sink, nil) {
+				return
+			}
+		}
+	}
+}
+
+// CardsSeq is the iterator variant of Cards; see SinksSeq.
+func (c *Client) CardsSeq(ctx context.Context) iter.Seq2[*Card, error] {
+	return func(yield func(*Card, error) bool) {
+		b, err := c.request(ctx, commandGetCardInfoList)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		for b.Len() > 0 {
+			card := new(Card)
+			if err := decodeReply(commandGetCardInfoList, b, card); err != nil {
+				yield(nil, err)
+				return
+			}
+			if !yield(card, nil) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,59 @@
+//go:build go1.23
+// +build go1.23
+
+package pulseaudio
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSinksSeq(t *testing.T) {
+	srv := newFakeServer(t)
+	second := srv.sinks[0]
+	second.Index = 1
+	second.Name = "second"
+	third := second
+	third.Index = 2
+	third.Name = "third"
+	srv.sinks = append(srv.sinks, second, third)
+	c := newFakeClient(t, srv)
+	ctx := context.Background()
+
+	var names []string
+	for sink, err := range c.SinksSeq(ctx) {
+		require.NoError(t, err)
+		names = append(names, sink.Name)
+		if sink.Name == "second" {
+			break
+		}
+	}
+	assert.Equal(t, []string{"fake", "second"}, names)
+
+	var cards []string
+	for card, err := range c.CardsSeq(ctx) {
+		require.NoError(t, err)
+		assert.Same(t, card, card.Ports[0].Card)
+		cards = append(cards, card.Name)
+	}
+	assert.Equal(t, []string{"fake_card"}, cards)
+}
+
+func TestSinksSeqError(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.handle(commandGetSinkInfoList, func(*bytes.Buffer) ([]interface{}, uint32) {
+		return nil, 1 // access denied
+	})
+	c := newFakeClient(t, srv)
+
+	n := 0
+	for _, err := range c.SinksSeq(context.Background()) {
+		assert.Error(t, err)
+		n++
+	}
+	assert.Equal(t, 1, n)
+}
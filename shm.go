@@ -0,0 +1,319 @@
+package pulseaudio
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+)
+
+// Flags in the frame descriptor of memory blocks passed in shared memory.
+const (
+	frameFlagShmMask       = 0xff000000
+	frameFlagShmData       = 0x80000000 // the payload references a block in a shared memory segment
+	frameFlagShmRelease    = 0x40000000 // the receiver is done with an exported block
+	frameFlagShmRevoke     = 0xc0000000 // the sender takes an exported block back
+	frameFlagShmMemfdBlock = 0x20000000 // the segment of the block was registered as a memfd
+)
+
+// Flags in the protocol version exchanged by commandAuth.
+const (
+	protocolFlagShm   = 0x80000000
+	protocolFlagMemfd = 0x40000000
+)
+
+// shmDir holds the POSIX shared memory segments of the server and the export pool of the client.
+const shmDir = "/dev/shm"
+
+// shmRefSize is the size of the payload referencing a block: block id, segment id, offset and length.
+const shmRefSize = 16
+
+// shmPoolSlots is the number of blocks of maxMemblockSize in the pool playback data is exported from.
+const shmPoolSlots = 16
+
+var errSharedMemoryUnsupported = errors.New("shared memory is not supported on this platform")
+
+// WithSharedMemory makes the client negotiate shared memory transfer of stream data with a local
+// server, like libpulse does. Recorded data is then read from the memory pool of the server and
+// playback data is handed over in a pool of the client, instead of being copied through the
+// socket. Commands still go through the socket: the ring buffer channel (srbchannel) the server
+// offers is declined. Shared memory is only used on Unix sockets on Linux; elsewhere the option
+// has no effect.
+func WithSharedMemory(enabled bool) ClientOpt {
+	return func(client *Client) {
+		client.sharedMemory = enabled
+	}
+}
+
+// shmState holds the shared memory of a connection. Apart from memfd, which is set while
+// authenticating, it is only used by the frame handler.
+type shmState struct {
+	conn *net.UnixConn
+	// memfd is set if the server agreed to memfd transfer, which the export pool relies on
+	memfd bool
+	// imports are the mapped segments of the server by shm id
+	imports map[uint32][]byte
+	// export is the pool of the client, installed when its registration is sent
+	export *shmPool
+	// srbPending is set if the next memblock is the ring buffer of a declined srbchannel
+	srbPending bool
+}
+
+// newShmState returns the shared memory state for conn, or nil if shared memory can't be used
+// on it.
+func newShmState(conn net.Conn) *shmState {
+	uc, ok := conn.(*net.UnixConn)
+	if !ok || !shmSupported {
+		return nil
+	}
+	return &shmState{conn: uc, imports: make(map[uint32][]byte)}
+}
+
+// attach maps a memfd segment registered by the server; fd is closed.
+func (s *shmState) attach(shmID uint32, fd int) error {
+	defer closeFDs([]int{fd})
+	mem, err := mapSegment(fd)
+	if err != nil {
+		return err
+	}
+	if old, ok := s.imports[shmID]; ok {
+		unmapSegment(old)
+	}
+	s.imports[shmID] = mem
+	return nil
+}
+
+// importBlock copies the data of the block referenced by the payload of a frame flagged with
+// frameFlagShmData. Segments of the server which aren't memfds are POSIX shared memory and
+// mapped on first use.
+func (s *shmState) importBlock(flags uint32, payload []byte) ([]byte, uint32, error) {
+	if len(payload) != shmRefSize {
+		return nil, 0, fmt.Errorf("invalid shared memory reference of %d bytes", len(payload))
+	}
+	blockID := binary.BigEndian.Uint32(payload)
+	shmID := binary.BigEndian.Uint32(payload[4:])
+	offset := binary.BigEndian.Uint32(payload[8:])
+	length := binary.BigEndian.Uint32(payload[12:])
+	mem, ok := s.imports[shmID]
+	if !ok {
+		if flags&frameFlagShmMemfdBlock != 0 {
+			return nil, blockID, fmt.Errorf("memfd segment %d was not registered", shmID)
+		}
+		var err error
+		mem, err = openPosixSegment(shmID)
+		if err != nil {
+			return nil, blockID, err
+		}
+		s.imports[shmID] = mem
+	}
+	if uint64(offset)+uint64(length) > uint64(len(mem)) {
+		return nil, blockID, fmt.Errorf("block %d exceeds segment %d", blockID, shmID)
+	}
+	return append([]byte(nil), mem[offset:offset+length]...), blockID, nil
+}
+
+// write sends a request, passing stream data through the export pool if one is registered.
+func (s *shmState) write(p request) error {
+	switch {
+	case p.shmPool != nil:
+		if err := writeWithFD(s.conn, p.data, p.shmPool.fd()); err != nil {
+			p.shmPool.close()
+			return err
+		}
+		s.export = p.shmPool
+		return nil
+	case p.memblock && s.export != nil:
+		if b := s.export.put(p.data); b != nil {
+			_, err := s.conn.Write(b)
+			return err
+		}
+		// the pool is exhausted, so the data is copied through the socket
+	}
+	_, err := s.conn.Write(p.data)
+	return err
+}
+
+// release gives an exported block back to the pool.
+func (s *shmState) release(blockID uint32) {
+	if s.export != nil {
+		s.export.release(blockID)
+	}
+}
+
+func (s *shmState) close() {
+	for id, mem := range s.imports {
+		unmapSegment(mem)
+		delete(s.imports, id)
+	}
+	if s.export != nil {
+		s.export.close()
+		s.export = nil
+	}
+}
+
+// shmReleaseFrame tells the server that the client is done with one of its blocks.
+func shmReleaseFrame(blockID uint32) []byte {
+	b := make([]byte, 20)
+	binary.BigEndian.PutUint32(b[4:], controlChannel)
+	binary.BigEndian.PutUint32(b[8:], blockID)
+	binary.BigEndian.PutUint32(b[16:], frameFlagShmRelease)
+	return b
+}
+
+// shmPool is the memory playback data is exported from, divided into slots of maxMemblockSize.
+// The block id of a slot is its number.
+type shmPool struct {
+	id   uint32
+	file *os.File
+	mem  []byte
+	used [shmPoolSlots]bool
+}
+
+func (p *shmPool) fd() int {
+	return int(p.file.Fd())
+}
+
+// put copies the data of a memblock frame into a free slot and returns the frame referencing it,
+// or nil if no slot is free.
+func (p *shmPool) put(frame []byte) []byte {
+	data := frame[20:]
+	if len(data) > maxMemblockSize {
+		return nil
+	}
+	for i := range p.used {
+		if p.used[i] {
+			continue
+		}
+		p.used[i] = true
+		offset := i * maxMemblockSize
+		copy(p.mem[offset:], data)
+		b := make([]byte, 36)
+		copy(b, frame[:20])
+		binary.BigEndian.PutUint32(b, 16)
+		binary.BigEndian.PutUint32(b[16:], binary.BigEndian.Uint32(frame[16:])|frameFlagShmData|frameFlagShmMemfdBlock)
+		binary.BigEndian.PutUint32(b[20:], uint32(i))
+		binary.BigEndian.PutUint32(b[24:], p.id)
+		binary.BigEndian.PutUint32(b[28:], uint32(offset))
+		binary.BigEndian.PutUint32(b[32:], uint32(len(data)))
+		return b
+	}
+	return nil
+}
+
+func (p *shmPool) release(blockID uint32) {
+	if blockID < shmPoolSlots {
+		p.used[blockID] = false
+	}
+}
+
+func (p *shmPool) close() {
+	unmapSegment(p.mem)
+	_ = p.file.Close()
+}
+
+// registerShmPool creates the export pool and registers it with the server.
+func (c *Client) registerShmPool(ctx context.Context, out chan<- request) error {
+	pool, err := newShmPool()
+	if err != nil {
+		return err
+	}
+	var b bytes.Buffer
+	err = bwrite(&b, uint32(0), // length is fixed below
+		uint32(controlChannel), // channel
+		uint32(0), uint32(0),   // offset high & low
+		uint32(0), // flags
+		uint32Tag, uint32(commandRegisterMemfdShmid),
+		uint32Tag, uint32(0xffffffff), // tag: the registration is not answered
+		uint32Tag, pool.id)
+	if err != nil {
+		pool.close()
+		return err
+	}
+	binary.BigEndian.PutUint32(b.Bytes(), uint32(b.Len()-20))
+	resp := make(chan frame, 1)
+	// like stream data the registration is sent as is, without waiting for a reply
+	err = c.sendRequest(ctx, out, request{data: b.Bytes(), response: resp, memblock: true, shmPool: pool})
+	if err != nil {
+		pool.close()
+		return err
+	}
+	select {
+	case response := <-resp:
+		return response.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// handleShmCommand handles the commands setting up shared memory, which carry file descriptors.
+func (c *Client) handleShmCommand(cmd command, f frame, logger Logger) {
+	if c.shm == nil {
+		logger.Errorf("ignoring %s on a connection without shared memory", cmd)
+		closeFDs(f.fds)
+		return
+	}
+	switch cmd {
+	case commandRegisterMemfdShmid:
+		var shmID uint32
+		if err := bread(f.buff, uint32Tag, &shmID); err != nil || len(f.fds) != 1 {
+			logger.Errorf("invalid memfd registration with %d file descriptors: %v", len(f.fds), err)
+			closeFDs(f.fds)
+			return
+		}
+		if err := c.shm.attach(shmID, f.fds[0]); err != nil {
+			logger.Errorf("could not map memfd segment %d: %v", shmID, err)
+		}
+	case commandEnableSrbchannel:
+		// the channel is never acknowledged, so the server keeps using the socket
+		logger.Info("declining srbchannel offered by the server")
+		closeFDs(f.fds)
+		c.shm.srbPending = true
+	}
+}
+
+// handleShmFrame handles a frame flagged for shared memory: the data of a block exported by the
+// server, or the release or revocation of a block.
+func (c *Client) handleShmFrame(f frame, logger Logger) error {
+	defer putFrameBuffer(f.buff)
+	closeFDs(f.fds)
+	if c.shm == nil {
+		logger.Errorf("ignoring shared memory frame on a connection without shared memory")
+		return nil
+	}
+	switch f.flags & frameFlagShmMask {
+	case frameFlagShmRelease:
+		c.shm.release(f.blockID)
+		return nil
+	case frameFlagShmRevoke:
+		// imported blocks are copied as soon as they arrive, so there is nothing to give back
+		return nil
+	}
+	if f.flags&frameFlagShmData == 0 {
+		logger.Errorf("ignoring frame with unknown flags %#x", f.flags)
+		return nil
+	}
+	if f.buff.Len() != shmRefSize {
+		// without the reference the block can't be released, so the connection is broken
+		return fmt.Errorf("invalid shared memory reference of %d bytes", f.buff.Len())
+	}
+	data, blockID, err := c.shm.importBlock(f.flags, f.buff.Bytes())
+	// the data was copied, so the block is handed back right away; a block which couldn't be
+	// imported is released as well, since the server would keep it otherwise
+	if _, err := c.shm.conn.Write(shmReleaseFrame(blockID)); err != nil {
+		return fmt.Errorf("could not write to connection: %w", err)
+	}
+	if err != nil {
+		logger.Errorf("could not import shared memory block: %v", err)
+		return nil
+	}
+	if c.shm.srbPending {
+		// the ring buffer of the declined srbchannel
+		c.shm.srbPending = false
+		return nil
+	}
+	c.streamData(f.channel, data)
+	return nil
+}
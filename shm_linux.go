@@ -0,0 +1,128 @@
+//go:build linux
+// +build linux
+
+package pulseaudio
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"syscall"
+)
+
+const shmSupported = true
+
+// maxFDs is the number of file descriptors a single frame can carry.
+const maxFDs = 4
+
+// readHeaderFDs reads the first 4 bytes of a frame into b, together with the file descriptors
+// sent along with the frame.
+func readHeaderFDs(conn *net.UnixConn, b *bytes.Buffer) ([]int, error) {
+	header := make([]byte, 4)
+	oob := make([]byte, syscall.CmsgSpace(4*maxFDs))
+	var fds []int
+	for read := 0; read < len(header); {
+		n, oobn, _, _, err := conn.ReadMsgUnix(header[read:], oob)
+		if oobn > 0 {
+			fds = append(fds, parseRights(oob[:oobn])...)
+		}
+		if err == nil && n == 0 && oobn == 0 {
+			err = io.EOF
+		}
+		if err != nil {
+			closeFDs(fds)
+			return nil, err
+		}
+		read += n
+	}
+	b.Write(header)
+	return fds, nil
+}
+
+// parseRights returns the file descriptors passed in the control messages.
+func parseRights(oob []byte) []int {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil
+	}
+	var fds []int
+	for i := range msgs {
+		if msgs[i].Header.Level != syscall.SOL_SOCKET || msgs[i].Header.Type != syscall.SCM_RIGHTS {
+			continue
+		}
+		rights, err := syscall.ParseUnixRights(&msgs[i])
+		if err == nil {
+			fds = append(fds, rights...)
+		}
+	}
+	return fds
+}
+
+// writeWithFD sends data together with a file descriptor.
+func writeWithFD(conn *net.UnixConn, data []byte, fd int) error {
+	n, _, err := conn.WriteMsgUnix(data, syscall.UnixRights(fd), nil)
+	if err != nil {
+		return err
+	}
+	if n < len(data) {
+		// the descriptor went with the first part
+		_, err = conn.Write(data[n:])
+	}
+	return err
+}
+
+func closeFDs(fds []int) {
+	for _, fd := range fds {
+		_ = syscall.Close(fd)
+	}
+}
+
+// mapSegment maps a shared memory segment read-only.
+func mapSegment(fd int) ([]byte, error) {
+	var st syscall.Stat_t
+	if err := syscall.Fstat(fd, &st); err != nil {
+		return nil, err
+	}
+	if st.Size <= 0 {
+		return nil, fmt.Errorf("shared memory segment is empty")
+	}
+	return syscall.Mmap(fd, 0, int(st.Size), syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+// openPosixSegment maps the POSIX shared memory segment of the server with the given id.
+func openPosixSegment(shmID uint32) ([]byte, error) {
+	f, err := os.Open(fmt.Sprintf("%s/pulse-shm-%d", shmDir, shmID))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return mapSegment(int(f.Fd()))
+}
+
+func unmapSegment(mem []byte) {
+	_ = syscall.Munmap(mem)
+}
+
+// newShmPool creates the export pool. An unlinked file in /dev/shm serves as the memfd, which the
+// syscall package can't create on every architecture; the server maps both alike.
+func newShmPool() (*shmPool, error) {
+	f, err := os.CreateTemp(shmDir, "pulseaudio-go-")
+	if err != nil {
+		return nil, err
+	}
+	_ = os.Remove(f.Name())
+	size := shmPoolSlots * maxMemblockSize
+	if err = f.Truncate(int64(size)); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	mem, err := syscall.Mmap(int(f.Fd()), 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return &shmPool{id: rand.Uint32(), file: f, mem: mem}, nil
+}
@@ -0,0 +1,35 @@
+//go:build !linux
+// +build !linux
+
+package pulseaudio
+
+import (
+	"bytes"
+	"net"
+)
+
+const shmSupported = false
+
+func readHeaderFDs(*net.UnixConn, *bytes.Buffer) ([]int, error) {
+	return nil, errSharedMemoryUnsupported
+}
+
+func writeWithFD(*net.UnixConn, []byte, int) error {
+	return errSharedMemoryUnsupported
+}
+
+func closeFDs([]int) {}
+
+func mapSegment(int) ([]byte, error) {
+	return nil, errSharedMemoryUnsupported
+}
+
+func openPosixSegment(uint32) ([]byte, error) {
+	return nil, errSharedMemoryUnsupported
+}
+
+func unmapSegment([]byte) {}
+
+func newShmPool() (*shmPool, error) {
+	return nil, errSharedMemoryUnsupported
+}
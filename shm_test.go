@@ -0,0 +1,288 @@
+package pulseaudio
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// enableSharedMemory makes the fake server agree to the shared memory transfer requested by clients.
+func (s *fakeServer) enableSharedMemory(t *testing.T) {
+	t.Helper()
+	if !shmSupported {
+		t.Skip("shared memory is not supported on this platform")
+	}
+	pool, err := newShmPool()
+	require.NoError(t, err)
+	s.mu.Lock()
+	s.shm = true
+	s.shmPool = pool
+	s.shmSegments = make(map[uint32][]byte)
+	s.mu.Unlock()
+	t.Cleanup(func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		pool.close()
+		for _, mem := range s.shmSegments {
+			unmapSegment(mem)
+		}
+	})
+	s.handle(commandAuth, func(req *bytes.Buffer) ([]interface{}, uint32) {
+		var v uint32
+		if err := bread(req, uint32Tag, &v); err != nil {
+			return nil, 3 // invalid argument
+		}
+		return []interface{}{uint32Tag, uint32(version) | v&(protocolFlagShm|protocolFlagMemfd)}, 0
+	})
+}
+
+// readHeader reads a frame header, with the file descriptors passed along if shared memory is enabled.
+func (s *fakeServer) readHeader(conn net.Conn) ([]byte, []int, error) {
+	header := make([]byte, 20)
+	s.mu.Lock()
+	shm := s.shm
+	s.mu.Unlock()
+	uc, ok := conn.(*net.UnixConn)
+	if !shm || !ok {
+		_, err := io.ReadFull(conn, header)
+		return header, nil, err
+	}
+	var b bytes.Buffer
+	fds, err := readHeaderFDs(uc, &b)
+	if err != nil {
+		return nil, nil, err
+	}
+	copy(header, b.Bytes())
+	if _, err := io.ReadFull(conn, header[4:]); err != nil {
+		closeFDs(fds)
+		return nil, nil, err
+	}
+	return header, fds, nil
+}
+
+// registerSegment maps the pool registered by a client.
+func (s *fakeServer) registerSegment(req *bytes.Buffer, fds []int) {
+	defer closeFDs(fds)
+	var shmID uint32
+	if err := bread(req, uint32Tag, &shmID); err != nil || len(fds) != 1 {
+		s.t.Errorf("invalid memfd registration with %d file descriptors: %v", len(fds), err)
+		return
+	}
+	mem, err := mapSegment(fds[0])
+	if err != nil {
+		s.t.Errorf("could not map memfd: %v", err)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.shmSegments[shmID] = mem
+}
+
+// shmFrame handles the release of a block of the server or stream data in the pool of a client.
+func (s *fakeServer) shmFrame(conn net.Conn, header, payload []byte) {
+	if binary.BigEndian.Uint32(header[16:])&frameFlagShmMask == frameFlagShmRelease {
+		blockID := binary.BigEndian.Uint32(header[8:])
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.shmReleased = append(s.shmReleased, blockID)
+		s.shmPool.release(blockID)
+		return
+	}
+	channel := binary.BigEndian.Uint32(header[4:])
+	blockID := binary.BigEndian.Uint32(payload)
+	shmID := binary.BigEndian.Uint32(payload[4:])
+	offset := binary.BigEndian.Uint32(payload[8:])
+	length := binary.BigEndian.Uint32(payload[12:])
+	s.mu.Lock()
+	if s.received == nil {
+		s.received = make(map[uint32][]byte)
+	}
+	mem := s.shmSegments[shmID]
+	if int(offset+length) <= len(mem) {
+		s.received[channel] = append(s.received[channel], mem[offset:offset+length]...)
+	} else {
+		s.t.Errorf("block %d is not in a registered segment", blockID)
+	}
+	s.mu.Unlock()
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	_, _ = conn.Write(shmReleaseFrame(blockID))
+}
+
+// sendFDCommand sends a command with the given tag and a file descriptor to all connected clients.
+func (s *fakeServer) sendFDCommand(cmd command, tag uint32, fd int, args ...interface{}) {
+	var b bytes.Buffer
+	if err := writeFakeFrame(&b, cmd, tag, args...); err != nil {
+		s.t.Errorf("could not encode %s: %v", cmd, err)
+		return
+	}
+	s.each(func(conn net.Conn) error {
+		return writeWithFD(conn.(*net.UnixConn), b.Bytes(), fd)
+	})
+}
+
+// sharePool registers the pool of the server with all connected clients.
+func (s *fakeServer) sharePool() {
+	s.sendFDCommand(commandRegisterMemfdShmid, 0xffffffff, s.shmPool.fd(), uint32Tag, s.shmPool.id)
+}
+
+// sendShmData sends data on the stream channel in a block of the pool of the server.
+func (s *fakeServer) sendShmData(channel uint32, data []byte) {
+	frame := make([]byte, 20+len(data))
+	binary.BigEndian.PutUint32(frame, uint32(len(data)))
+	binary.BigEndian.PutUint32(frame[4:], channel)
+	copy(frame[20:], data)
+	s.mu.Lock()
+	ref := s.shmPool.put(frame)
+	s.mu.Unlock()
+	s.each(func(conn net.Conn) error {
+		_, err := conn.Write(ref)
+		return err
+	})
+}
+
+func (s *fakeServer) released() []uint32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]uint32(nil), s.shmReleased...)
+}
+
+// handleRecordChannel answers record stream requests with the given channel.
+func handleRecordChannel(srv *fakeServer, channel uint32) {
+	srv.handle(commandCreateRecordStream, func(req *bytes.Buffer) ([]interface{}, uint32) {
+		return []interface{}{
+			uint32Tag, channel, uint32Tag, uint32(1), uint32Tag, uint32(0), uint32Tag, uint32(0),
+			SampleSpec{Format: SampleU8, Channels: 1, Rate: 8000}, ChannelMapMono(),
+			uint32Tag, uint32(1), stringTag, []byte("mic"), byte(0), falseTag, usecTag, uint64(0),
+		}, 0
+	})
+}
+
+func TestSharedMemoryRecord(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.enableSharedMemory(t)
+	handleRecordChannel(srv, 0)
+	c := newFakeClient(t, srv, WithSharedMemory(true))
+	ctx := context.Background()
+
+	r, err := c.Record(ctx, "mic", SampleSpec{Format: SampleU8, Channels: 1, Rate: 8000})
+	require.NoError(t, err)
+	srv.sharePool()
+	srv.sendShmData(0, []byte{1, 2, 3, 4})
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(r, buf)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{1, 2, 3, 4}, buf)
+	require.Eventually(t, func() bool { return len(srv.released()) == 1 }, time.Second, time.Millisecond)
+	assert.Equal(t, []uint32{0}, srv.released())
+
+	// a POSIX shared memory segment is mapped by id
+	shmID := uint32(os.Getpid())<<8 | 0x5a
+	path := fmt.Sprintf("%s/pulse-shm-%d", shmDir, shmID)
+	require.NoError(t, os.WriteFile(path, []byte{0, 0, 5, 6, 0}, 0600))
+	t.Cleanup(func() { _ = os.Remove(path) })
+	ref := make([]byte, 36)
+	binary.BigEndian.PutUint32(ref, 16)
+	binary.BigEndian.PutUint32(ref[16:], frameFlagShmData)
+	binary.BigEndian.PutUint32(ref[20:], 9) // block
+	binary.BigEndian.PutUint32(ref[24:], shmID)
+	binary.BigEndian.PutUint32(ref[28:], 2) // offset
+	binary.BigEndian.PutUint32(ref[32:], 2) // length
+	srv.each(func(conn net.Conn) error {
+		_, err := conn.Write(ref)
+		return err
+	})
+	_, err = io.ReadFull(r, buf[:2])
+	require.NoError(t, err)
+	assert.Equal(t, []byte{5, 6}, buf[:2])
+	require.Eventually(t, func() bool { return len(srv.released()) == 2 }, time.Second, time.Millisecond)
+	assert.Equal(t, []uint32{0, 9}, srv.released())
+
+	// a block which can't be imported is released anyway
+	binary.BigEndian.PutUint32(ref[16:], frameFlagShmData|frameFlagShmMemfdBlock)
+	binary.BigEndian.PutUint32(ref[20:], 11) // block
+	binary.BigEndian.PutUint32(ref[24:], 77) // unregistered memfd segment
+	srv.each(func(conn net.Conn) error {
+		_, err := conn.Write(ref)
+		return err
+	})
+	require.Eventually(t, func() bool { return len(srv.released()) == 3 }, time.Second, time.Millisecond)
+	assert.Equal(t, []uint32{0, 9, 11}, srv.released())
+}
+
+func TestSharedMemoryDeclineSrbchannel(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.enableSharedMemory(t)
+	handleRecordChannel(srv, 0)
+	c := newFakeClient(t, srv, WithSharedMemory(true))
+	ctx := context.Background()
+
+	r, err := c.Record(ctx, "mic", SampleSpec{Format: SampleU8, Channels: 1, Rate: 8000})
+	require.NoError(t, err)
+	srv.sharePool()
+	// the offer has a tag chosen by the server and is followed by the ring buffer on channel 0
+	srv.sendFDCommand(commandEnableSrbchannel, 0x1234, srv.shmPool.fd())
+	srv.sendShmData(0, []byte{0xee, 0xee})
+	srv.sendData(0, []byte{7, 8})
+	buf := make([]byte, 2)
+	_, err = io.ReadFull(r, buf)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{7, 8}, buf, "the ring buffer must not reach the stream")
+	require.NoError(t, c.Ping(ctx))
+	assert.Equal(t, []uint32{0}, srv.released())
+}
+
+func TestSharedMemoryPlay(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.enableSharedMemory(t)
+	srv.handle(commandCreatePlaybackStream, func(req *bytes.Buffer) ([]interface{}, uint32) {
+		return []interface{}{
+			uint32Tag, uint32(5), uint32Tag, uint32(12), uint32Tag, uint32(1 << 20),
+			uint32Tag, uint32(0x10000), uint32Tag, uint32(0x8000), uint32Tag, uint32(0x4000), uint32Tag, uint32(0x100),
+			SampleSpec{Format: SampleU8, Channels: 1, Rate: 8000}, ChannelMapMono(),
+			uint32Tag, uint32(0), stringTag, []byte("fake"), byte(0), falseTag, usecTag, uint64(0),
+		}, 0
+	})
+	c := newFakeClient(t, srv, WithSharedMemory(true))
+	ctx := context.Background()
+
+	w, err := c.Play(ctx, "fake", SampleSpec{Format: SampleU8, Channels: 1, Rate: 8000})
+	require.NoError(t, err)
+	// more blocks than the pool has slots, so that released slots are reused
+	var want []byte
+	for i := 0; i < 2*shmPoolSlots; i++ {
+		chunk := bytes.Repeat([]byte{byte(i)}, 100)
+		want = append(want, chunk...)
+		_, err = w.Write(chunk)
+		require.NoError(t, err)
+		require.Eventually(t, func() bool { return len(srv.streamData(5)) == len(want) }, time.Second, time.Millisecond)
+	}
+	assert.Equal(t, want, srv.streamData(5))
+	srv.mu.Lock()
+	assert.Len(t, srv.shmSegments, 1, "the client registers its pool")
+	srv.mu.Unlock()
+}
+
+func TestSharedMemoryNotRequested(t *testing.T) {
+	srv := newFakeServer(t)
+	requested := make(chan uint32, 1)
+	srv.handle(commandAuth, func(req *bytes.Buffer) ([]interface{}, uint32) {
+		var v uint32
+		_ = bread(req, uint32Tag, &v)
+		requested <- v
+		return []interface{}{uint32Tag, uint32(version) | protocolFlagShm | protocolFlagMemfd}, 0
+	})
+	c := newFakeClient(t, srv)
+	require.NoError(t, c.Ping(context.Background()))
+	assert.Equal(t, uint32(version), <-requested)
+	assert.Equal(t, uint32(version), c.ServerProtocolVersion())
+}
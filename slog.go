@@ -0,0 +1,39 @@
+//go:build go1.21
+// +build go1.21
+
+package pulseaudio
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+type slogLogger struct {
+	logger *slog.Logger
+}
+
+// NewSlogLogger returns a logger for Opts.Logger which writes to logger, including the debug
+// output of the client at slog.LevelDebug.
+func NewSlogLogger(logger *slog.Logger) DebugLogger {
+	return slogLogger{logger: logger}
+}
+
+func (l slogLogger) Info(msg string) {
+	l.logger.Info(msg)
+}
+
+func (l slogLogger) Infof(msg string, args ...interface{}) {
+	l.logger.Info(fmt.Sprintf(msg, args...))
+}
+
+func (l slogLogger) Errorf(msg string, args ...interface{}) {
+	l.logger.Error(fmt.Sprintf(msg, args...))
+}
+
+func (l slogLogger) Debugf(msg string, args ...interface{}) {
+	// tracing every frame is costly, so it's only formatted if it's going to be written
+	if l.logger.Enabled(context.Background(), slog.LevelDebug) {
+		l.logger.Debug(fmt.Sprintf(msg, args...))
+	}
+}
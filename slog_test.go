@@ -0,0 +1,40 @@
+//go:build go1.21
+// +build go1.21
+
+package pulseaudio
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// withoutTime drops the time from log records so that the output can be compared.
+func withoutTime(groups []string, a slog.Attr) slog.Attr {
+	if a.Key == slog.TimeKey && len(groups) == 0 {
+		return slog.Attr{}
+	}
+	return a
+}
+
+func TestSlogLogger(t *testing.T) {
+	var out lockedBuffer
+	logger := NewSlogLogger(slog.New(slog.NewTextHandler(&out, &slog.HandlerOptions{Level: slog.LevelDebug, ReplaceAttr: withoutTime})))
+	srv := newFakeServer(t)
+	c := NewClient(Opts{Addr: srv.uri(), Cookie: fakeCookie(t), Logger: logger})
+	require.NoError(t, c.Dial(context.Background()))
+	require.NoError(t, c.Ping(context.Background()))
+	c.Close()
+	assert.Contains(t, string(out.Bytes()), "level=DEBUG msg=\"sent commandStat req #")
+	assert.Contains(t, string(out.Bytes()), "level=DEBUG msg=\"received commandReply to commandStat req #")
+
+	var buf bytes.Buffer
+	logger = NewSlogLogger(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{ReplaceAttr: withoutTime})))
+	logger.Debugf("hidden %d", 1)
+	logger.Errorf("shown %d", 2)
+	assert.Equal(t, "level=ERROR msg=\"shown 2\"\n", buf.String())
+}
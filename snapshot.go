@@ -0,0 +1,115 @@
+package pulseaudio
+
+import (
+	"context"
+	"sync"
+)
+
+// Snapshot is a view of the server state gathered with one request per kind of object. It is not
+// atomic: the server may change between the requests, e.g. a sink input may refer to a sink
+// which isn't in Sinks. It must be treated as read-only since it may be shared between
+// goroutines.
+type Snapshot struct {
+	DefaultSink   string
+	DefaultSource string
+	Sinks         []Sink
+	Sources       []Source
+	SinkInputs    []SinkInput
+}
+
+// Snapshot queries the default devices, sinks, sources and sink inputs one after the other.
+func (c *Client) Snapshot(ctx context.Context) (*Snapshot, error) {
+	if c == nil {
+		return nil, ErrClientDisabled
+	}
+	s, err := c.ServerInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	snap := &Snapshot{
+		DefaultSink:   s.DefaultSink,
+		DefaultSource: s.DefaultSource,
+	}
+	snap.Sinks, err = c.Sinks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	snap.Sources, err = c.Sources(ctx)
+	if err != nil {
+		return nil, err
+	}
+	snap.SinkInputs, err = c.SinkInputs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return snap, nil
+}
+
+// SnapshotWatcher keeps a Snapshot up to date by refreshing it on every server change.
+type SnapshotWatcher struct {
+	mu       sync.RWMutex
+	snapshot *Snapshot
+	err      error
+	changed  chan struct{}
+}
+
+// WatchSnapshot takes a snapshot and refreshes it in the background whenever the server
+// reports a change and after the client reconnected, until ctx is done or the client is closed.
+func (c *Client) WatchSnapshot(ctx context.Context) (*SnapshotWatcher, error) {
+	// subscribe first so that no change made while taking the snapshot is missed
+	updates, err := c.SubscribeEvents(ctx)
+	if err != nil {
+		return nil, err
+	}
+	snap, err := c.Snapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	w := &SnapshotWatcher{
+		snapshot: snap,
+		changed:  make(chan struct{}, 1),
+	}
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case _, ok := <-updates:
+				if !ok {
+					return
+				}
+			}
+			snap, err := c.Snapshot(ctx)
+			w.mu.Lock()
+			if err == nil {
+				w.snapshot = snap
+			}
+			w.err = err
+			w.mu.Unlock()
+			select {
+			case w.changed <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return w, nil
+}
+
+// Snapshot returns the latest snapshot.
+func (w *SnapshotWatcher) Snapshot() *Snapshot {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.snapshot
+}
+
+// Err returns the error of the last refresh, if it failed. The previous snapshot is kept then.
+func (w *SnapshotWatcher) Err() error {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.err
+}
+
+// Changed returns a channel which is notified after every refresh.
+func (w *SnapshotWatcher) Changed() <-chan struct{} {
+	return w.changed
+}
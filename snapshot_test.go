@@ -0,0 +1,93 @@
+package pulseaudio
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func fakeSinkInput() SinkInput {
+	return SinkInput{
+		Index:          7,
+		Name:           "Playback",
+		ClientIndex:    3,
+		SampleSpec:     SampleSpec{Format: 3, Channels: 2, Rate: 44100},
+		ChannelMap:     ChannelMap{1, 2},
+		CVolume:        CVolume{0x10000, 0x10000},
+		ResampleMethod: "speex-float-1",
+		Driver:         "protocol-native.c",
+		PropList:       map[string]string{"application.name": "player"},
+		Corked:         true,
+		HasVolume:      true,
+		VolumeWritable: true,
+		Format:         FormatInfo{Encoding: 1, PropList: map[string]string{}},
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.inputs = append(srv.inputs, fakeSinkInput())
+	c := newFakeClient(t, srv)
+
+	snap, err := c.Snapshot(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "fake", snap.DefaultSink)
+	assert.Equal(t, "fake.monitor", snap.DefaultSource)
+	require.Len(t, snap.Sinks, 1)
+	assert.Equal(t, "fake", snap.Sinks[0].Name)
+	require.Len(t, snap.Sources, 1)
+	assert.Equal(t, "fake", snap.Sources[0].MonitorSinkName)
+	assert.Equal(t, CVolume{0x10000, 0x10000}, snap.Sources[0].CVolume)
+	require.Len(t, snap.SinkInputs, 1)
+	assert.Equal(t, fakeSinkInput(), snap.SinkInputs[0])
+}
+
+func TestWatchSnapshot(t *testing.T) {
+	srv := newFakeServer(t)
+	c := newFakeClient(t, srv)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	w, err := c.WatchSnapshot(ctx)
+	require.NoError(t, err)
+	first := w.Snapshot()
+	assert.Empty(t, first.SinkInputs)
+
+	srv.mu.Lock()
+	srv.inputs = append(srv.inputs, fakeSinkInput())
+	srv.mu.Unlock()
+	srv.broadcast(commandSubscribeEvent, uint32Tag, uint32(0x0002), uint32Tag, uint32(7)) // new sink input
+
+	select {
+	case <-w.Changed():
+	case <-time.After(time.Second):
+		t.Fatal("snapshot was not refreshed")
+	}
+	require.NoError(t, w.Err())
+	assert.Len(t, w.Snapshot().SinkInputs, 1)
+	assert.Empty(t, first.SinkInputs, "previous snapshot was modified")
+}
+
+func TestWatchSnapshotReconnect(t *testing.T) {
+	srv := newFakeServer(t)
+	c := newFakeClient(t, srv)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	w, err := c.WatchSnapshot(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, w.Snapshot().SinkInputs)
+
+	// the server restarts with a stream, no events are sent
+	srv.mu.Lock()
+	srv.inputs = append(srv.inputs, fakeSinkInput())
+	srv.mu.Unlock()
+	srv.drop()
+
+	require.Eventually(t, func() bool { return len(w.Snapshot().SinkInputs) == 1 }, time.Second, time.Millisecond,
+		"snapshot was not refreshed after reconnecting")
+	require.NoError(t, w.Err())
+}
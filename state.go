@@ -0,0 +1,261 @@
+package pulseaudio
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+)
+
+// StateStore keeps the sinks, sources and default devices of the server up to date. Unlike
+// SnapshotWatcher it does not query everything on every change but only re-fetches the sink or
+// source an event is about. All methods are safe for concurrent use.
+type StateStore struct {
+	mu            sync.RWMutex
+	sinks         map[uint32]Sink
+	sources       map[uint32]Source
+	defaultSink   string
+	defaultSource string
+	err           error
+	changed       chan struct{}
+
+	// pendingMu guards the changes reported by the server which have not been applied yet
+	pendingMu      sync.Mutex
+	pendingSinks   map[uint32]Operation
+	pendingSources map[uint32]Operation
+	pendingServer  bool
+	pendingReload  bool
+}
+
+// Watch loads the sinks, sources and default devices and keeps them current in the background
+// by consuming the server events, until ctx is done or the client is closed. Everything is
+// loaded again after a reconnection, since the events in between were lost.
+func (c *Client) Watch(ctx context.Context) (*StateStore, error) {
+	if c == nil {
+		return nil, ErrClientDisabled
+	}
+	s := &StateStore{
+		changed:        make(chan struct{}, 1),
+		pendingSinks:   make(map[uint32]Operation),
+		pendingSources: make(map[uint32]Operation),
+	}
+	// register and subscribe first so that no change made while loading is missed
+	h := c.addEventHandler(s.record, s.invalidate)
+	updates, err := c.SubscribeEvents(ctx)
+	if err != nil {
+		c.removeEventHandler(h)
+		return nil, err
+	}
+	if err := s.reload(ctx, c); err != nil {
+		c.removeEventHandler(h)
+		return nil, err
+	}
+	go func() {
+		defer c.removeEventHandler(h)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case _, ok := <-updates:
+				if !ok {
+					return
+				}
+			}
+			s.apply(ctx, c)
+			select {
+			case s.changed <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return s, nil
+}
+
+// record notes an event to be applied by the watch goroutine. It is called on the receive
+// goroutine of the client and must not block.
+func (s *StateStore) record(facility Facility, operation Operation, index uint32) {
+	s.pendingMu.Lock()
+	defer s.pendingMu.Unlock()
+	switch facility {
+	case FacilitySink:
+		s.pendingSinks[index] = operation
+	case FacilitySource:
+		s.pendingSources[index] = operation
+	case FacilityServer:
+		s.pendingServer = true
+	}
+}
+
+// invalidate makes the watch goroutine load everything again. Like record it must not block.
+func (s *StateStore) invalidate() {
+	s.pendingMu.Lock()
+	defer s.pendingMu.Unlock()
+	s.pendingReload = true
+}
+
+// reload queries the complete state from the server.
+func (s *StateStore) reload(ctx context.Context, c *Client) error {
+	server, err := c.ServerInfo(ctx)
+	if err != nil {
+		return err
+	}
+	sinks, err := c.Sinks(ctx)
+	if err != nil {
+		return err
+	}
+	sources, err := c.Sources(ctx)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.defaultSink = server.DefaultSink
+	s.defaultSource = server.DefaultSource
+	s.sinks = make(map[uint32]Sink, len(sinks))
+	for _, sink := range sinks {
+		s.sinks[sink.Index] = sink
+	}
+	s.sources = make(map[uint32]Source, len(sources))
+	for _, source := range sources {
+		s.sources[source.Index] = source
+	}
+	return nil
+}
+
+// apply re-fetches the sinks and sources changed since the last call and drops removed ones, or
+// everything after a reconnection. A failed query is reported by Err and tried again on the next
+// change.
+func (s *StateStore) apply(ctx context.Context, c *Client) {
+	s.pendingMu.Lock()
+	sinks, sources, server, reload := s.pendingSinks, s.pendingSources, s.pendingServer, s.pendingReload
+	s.pendingSinks = make(map[uint32]Operation)
+	s.pendingSources = make(map[uint32]Operation)
+	s.pendingServer = false
+	s.pendingReload = false
+	s.pendingMu.Unlock()
+
+	if reload {
+		err := s.reload(ctx, c)
+		if err != nil {
+			s.invalidate()
+		}
+		s.mu.Lock()
+		s.err = err
+		s.mu.Unlock()
+		return
+	}
+	var errs error
+	if server {
+		info, err := c.ServerInfo(ctx)
+		if err == nil {
+			s.mu.Lock()
+			s.defaultSink = info.DefaultSink
+			s.defaultSource = info.DefaultSource
+			s.mu.Unlock()
+		} else {
+			errs = err
+			s.record(FacilityServer, OpChange, 0)
+		}
+	}
+	for index, operation := range sinks {
+		var sink *Sink
+		var err error
+		if operation != OpRemove {
+			sink, err = c.getSinkByIndex(ctx, index)
+		}
+		if err != nil && !errors.Is(err, ErrNoSuchEntity) {
+			errs = err
+			s.record(FacilitySink, operation, index)
+			continue
+		}
+		s.mu.Lock()
+		if sink != nil {
+			s.sinks[index] = *sink
+		} else {
+			delete(s.sinks, index)
+		}
+		s.mu.Unlock()
+	}
+	for index, operation := range sources {
+		var source *Source
+		var err error
+		if operation != OpRemove {
+			source, err = c.getSourceByIndex(ctx, index)
+		}
+		if err != nil && !errors.Is(err, ErrNoSuchEntity) {
+			errs = err
+			s.record(FacilitySource, operation, index)
+			continue
+		}
+		s.mu.Lock()
+		if source != nil {
+			s.sources[index] = *source
+		} else {
+			delete(s.sources, index)
+		}
+		s.mu.Unlock()
+	}
+	s.mu.Lock()
+	s.err = errs
+	s.mu.Unlock()
+}
+
+// Sinks returns the current sinks ordered by index.
+func (s *StateStore) Sinks() []Sink {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	sinks := make([]Sink, 0, len(s.sinks))
+	for _, sink := range s.sinks {
+		sinks = append(sinks, sink)
+	}
+	sort.Slice(sinks, func(i, j int) bool { return sinks[i].Index < sinks[j].Index })
+	return sinks
+}
+
+// Sources returns the current sources ordered by index.
+func (s *StateStore) Sources() []Source {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	sources := make([]Source, 0, len(s.sources))
+	for _, source := range s.sources {
+		sources = append(sources, source)
+	}
+	sort.Slice(sources, func(i, j int) bool { return sources[i].Index < sources[j].Index })
+	return sources
+}
+
+// DefaultSink returns the current default sink, or false if it is not known.
+func (s *StateStore) DefaultSink() (Sink, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, sink := range s.sinks {
+		if sink.Name == s.defaultSink {
+			return sink, true
+		}
+	}
+	return Sink{}, false
+}
+
+// DefaultSource returns the current default source, or false if it is not known.
+func (s *StateStore) DefaultSource() (Source, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, source := range s.sources {
+		if source.Name == s.defaultSource {
+			return source, true
+		}
+	}
+	return Source{}, false
+}
+
+// Err returns the error of the last update, if it failed. The previous state is kept then.
+func (s *StateStore) Err() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.err
+}
+
+// Changed returns a channel which is notified after every update.
+func (s *StateStore) Changed() <-chan struct{} {
+	return s.changed
+}
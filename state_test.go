@@ -0,0 +1,98 @@
+package pulseaudio
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatch(t *testing.T) {
+	srv := newFakeServer(t)
+	c := newFakeClient(t, srv)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s, err := c.Watch(ctx)
+	require.NoError(t, err)
+	require.Len(t, s.Sinks(), 1)
+	require.Len(t, s.Sources(), 1)
+	sink, ok := s.DefaultSink()
+	require.True(t, ok)
+	assert.Equal(t, CVolume{0x8000, 0x8000}, sink.CVolume)
+	source, ok := s.DefaultSource()
+	require.True(t, ok)
+	assert.Equal(t, "fake.monitor", source.Name)
+
+	changed := func() {
+		t.Helper()
+		select {
+		case <-s.Changed():
+		case <-time.After(time.Second):
+			t.Fatal("state was not updated")
+		}
+		require.NoError(t, s.Err())
+	}
+
+	srv.setVolume("fake", CVolume{0x4000, 0x4000})
+	srv.broadcast(commandSubscribeEvent, uint32Tag, uint32(0x0010), uint32Tag, uint32(0)) // sink changed
+	changed()
+	sink, ok = s.DefaultSink()
+	require.True(t, ok)
+	assert.Equal(t, CVolume{0x4000, 0x4000}, sink.CVolume)
+
+	srv.mu.Lock()
+	srv.sinks = append(srv.sinks, Sink{Index: 3, Name: "usb", ChannelMap: ChannelMap{1, 2}, CVolume: CVolume{0x10000, 0x10000}})
+	srv.mu.Unlock()
+	srv.broadcast(commandSubscribeEvent, uint32Tag, uint32(0x0000), uint32Tag, uint32(3)) // new sink
+	changed()
+	sinks := s.Sinks()
+	require.Len(t, sinks, 2)
+	assert.Equal(t, "fake", sinks[0].Name)
+	assert.Equal(t, "usb", sinks[1].Name)
+
+	srv.mu.Lock()
+	srv.sinks = srv.sinks[:1]
+	srv.mu.Unlock()
+	srv.broadcast(commandSubscribeEvent, uint32Tag, uint32(0x0020), uint32Tag, uint32(3)) // sink removed
+	changed()
+	assert.Len(t, s.Sinks(), 1)
+
+	srv.mu.Lock()
+	srv.sources = nil
+	srv.mu.Unlock()
+	srv.broadcast(commandSubscribeEvent, uint32Tag, uint32(0x0011), uint32Tag, uint32(0)) // source vanished
+	changed()
+	assert.Empty(t, s.Sources())
+	_, ok = s.DefaultSource()
+	assert.False(t, ok)
+}
+
+func TestWatchReconnect(t *testing.T) {
+	srv := newFakeServer(t)
+	c := newFakeClient(t, srv)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s, err := c.Watch(ctx)
+	require.NoError(t, err)
+	require.Len(t, s.Sinks(), 1)
+
+	// the server restarts with other indexes, no events are sent
+	srv.mu.Lock()
+	srv.sinks[0].Index = 4
+	srv.sinks = append(srv.sinks, Sink{Index: 5, Name: "usb", ChannelMap: ChannelMap{1, 2}, CVolume: CVolume{VolumeNorm, VolumeNorm}})
+	srv.mu.Unlock()
+	srv.drop()
+
+	require.Eventually(t, func() bool {
+		sinks := s.Sinks()
+		return len(sinks) == 2 && sinks[0].Index == 4 && sinks[1].Name == "usb"
+	}, time.Second, time.Millisecond, "state was not loaded again after reconnecting")
+	require.NoError(t, s.Err())
+	sink, ok := s.DefaultSink()
+	require.True(t, ok)
+	assert.Equal(t, uint32(4), sink.Index)
+}
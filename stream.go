@@ -0,0 +1,613 @@
+package pulseaudio
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// ErrStreamKilled is returned by streams which were terminated by the server.
+var ErrStreamKilled = errors.New("stream was killed by the server")
+
+// stream is the client side of a playback or record stream, addressed by its channel.
+// All handlers are called from the frame handler routine and must not block.
+type stream interface {
+	// onData receives a memblock sent on the stream channel.
+	onData(b []byte)
+	// onCommand receives a server command addressed to the stream (the channel is already read).
+	onCommand(cmd command, b *bytes.Buffer)
+	// onClose terminates the stream with err.
+	onClose(err error)
+}
+
+func (c *Client) registerStream(channel uint32, st stream) {
+	c.streamsMu.Lock()
+	defer c.streamsMu.Unlock()
+	if c.streams == nil {
+		c.streams = make(map[uint32]stream)
+	}
+	c.streams[channel] = st
+}
+
+// unregisterStream removes st from the registry and reports whether it was registered.
+func (c *Client) unregisterStream(st stream) bool {
+	c.streamsMu.Lock()
+	defer c.streamsMu.Unlock()
+	for channel, registered := range c.streams {
+		if registered == st {
+			delete(c.streams, channel)
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Client) stream(channel uint32) stream {
+	c.streamsMu.Lock()
+	defer c.streamsMu.Unlock()
+	return c.streams[channel]
+}
+
+// failStreams terminates all streams; they don't outlive the connection they were created on.
+func (c *Client) failStreams(err error) {
+	c.streamsMu.Lock()
+	streams := c.streams
+	c.streams = nil
+	c.streamsMu.Unlock()
+	for _, st := range streams {
+		st.onClose(err)
+	}
+}
+
+func (c *Client) streamData(channel uint32, b []byte) {
+	st := c.stream(channel)
+	if st == nil {
+		// stream was already closed
+		return
+	}
+	st.onData(b)
+}
+
+func (c *Client) handleServerCommand(cmd command, b *bytes.Buffer, logger Logger) {
+	switch cmd {
+	case commandSubscribeEvent:
+		c.invalidateServerInfo()
+		var event, index uint32
+		if err := bread(b, uint32Tag, &event, uint32Tag, &index); err != nil {
+			logger.Errorf("could not read subscription event: %v", err)
+		} else {
+			c.dispatchEvent(event, index)
+		}
+		c.notifySubscribers()
+	case commandRequest, commandOverflow, commandUnderflow, commandStarted,
+		commandPlaybackStreamKilled, commandRecordStreamKilled,
+		commandPlaybackStreamSuspended, commandRecordStreamSuspended,
+		commandPlaybackStreamMoved, commandRecordStreamMoved,
+		commandPlaybackBufferAttrChanged, commandRecordBufferAttrChanged,
+		commandPlaybackStreamEvent, commandRecordStreamEvent:
+		var channel uint32
+		if err := bread(b, uint32Tag, &channel); err != nil {
+			logger.Errorf("could not read stream channel of %s: %v", cmd, err)
+			return
+		}
+		st := c.stream(channel)
+		if st == nil {
+			return
+		}
+		if cmd == commandPlaybackStreamKilled || cmd == commandRecordStreamKilled {
+			c.unregisterStream(st)
+			st.onClose(ErrStreamKilled)
+			return
+		}
+		st.onCommand(cmd, b)
+	default:
+		logger.Infof("ignoring %s sent by the server", cmd)
+	}
+}
+
+// deviceArgs encodes a sink or source name; an empty name selects the default device.
+func deviceArgs(name string) []interface{} {
+	if name == "" {
+		return []interface{}{stringNullTag}
+	}
+	return []interface{}{stringTag, []byte(name), byte(0)}
+}
+
+// BufferAttrDefault leaves a buffer attribute to the server.
+const BufferAttrDefault = 0xffffffff
+
+// BufferAttr holds the buffer attributes of a stream in bytes, which trade latency for robustness
+// against underruns. Fields set to BufferAttrDefault are chosen by the server; note that a zero
+// Prebuf is meaningful and starts playback without pre-buffering.
+type BufferAttr struct {
+	// MaxLength is the maximum length of the buffer.
+	MaxLength uint32
+	// TLength is the target length of the buffer of a playback stream.
+	TLength uint32
+	// Prebuf is the amount of data a playback stream buffers before it starts playing.
+	Prebuf uint32
+	// MinReq is the minimum amount of data a playback stream requests at once.
+	MinReq uint32
+	// FragSize is the amount of data a record stream delivers at once.
+	FragSize uint32
+}
+
+// DefaultBufferAttr returns buffer attributes which are all chosen by the server.
+func DefaultBufferAttr() BufferAttr {
+	return BufferAttr{
+		MaxLength: BufferAttrDefault,
+		TLength:   BufferAttrDefault,
+		Prebuf:    BufferAttrDefault,
+		MinReq:    BufferAttrDefault,
+		FragSize:  BufferAttrDefault,
+	}
+}
+
+// StreamOpt configures a stream created by Play or Record.
+type StreamOpt func(*streamOpts)
+
+type streamOpts struct {
+	bufferAttr BufferAttr
+}
+
+func newStreamOpts(opts []StreamOpt) streamOpts {
+	o := streamOpts{bufferAttr: DefaultBufferAttr()}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithBufferAttr requests the given buffer attributes, e.g. a small TLength for low latency or
+// a large one for gapless playback. The server may adjust them; the attributes it chose are
+// reported in the BufferAttr field of the stream.
+func WithBufferAttr(attr BufferAttr) StreamOpt {
+	return func(o *streamOpts) {
+		o.bufferAttr = attr
+	}
+}
+
+// RecordStream delivers PCM data captured from a source.
+type RecordStream struct {
+	client  *Client
+	channel uint32
+
+	// Index of the source output created for the stream.
+	Index       uint32
+	SampleSpec  SampleSpec
+	ChannelMap  ChannelMap
+	SourceIndex uint32
+	SourceName  string
+	// BufferAttr holds the MaxLength and FragSize chosen by the server.
+	BufferAttr BufferAttr
+
+	data      chan []byte
+	buf       []byte
+	done      chan struct{}
+	closeOnce sync.Once
+	err       error
+}
+
+// Record opens a record stream on the named source ("" selects PULSE_SOURCE or the default source) and
+// returns the captured PCM data in the format described by spec. The returned value is
+// a *RecordStream; closing it deletes the stream on the server.
+//
+// The context only bounds the creation of the stream.
+func (c *Client) Record(ctx context.Context, sourceName string, spec SampleSpec, opts ...StreamOpt) (io.ReadCloser, error) {
+	if c == nil {
+		return nil, ErrClientDisabled
+	}
+	if err := spec.Valid(); err != nil {
+		return nil, err
+	}
+	attr := newStreamOpts(opts).bufferAttr
+	s := &RecordStream{
+		client: c,
+		data:   make(chan []byte, 64),
+		done:   make(chan struct{}),
+	}
+	args := []interface{}{
+		spec,
+		defaultChannelMap(spec.Channels),
+		uint32Tag, uint32(0xffffffff), // source index
+	}
+	if sourceName == "" {
+		sourceName = c.envSource
+	}
+	args = append(args, deviceArgs(sourceName)...)
+	args = append(args,
+		uint32Tag, attr.MaxLength,
+		falseTag, // start corked
+		uint32Tag, attr.FragSize,
+		falseTag, falseTag, falseTag, falseTag, falseTag, falseTag, falseTag, // no remap, no remix, fix format/rate/channels, don't move, variable rate
+		falseTag, // peak detect
+		falseTag, // adjust latency
+		map[string]string{"media.name": "record"},
+		uint32Tag, uint32(0xffffffff), // direct on input
+		falseTag,           // early requests
+		falseTag, falseTag, // don't inhibit auto suspend, fail on suspend
+		uint8Tag, uint8(0), // formats
+		CVolume{},
+		falseTag, falseTag, falseTag, falseTag, falseTag, // muted, volume set, muted set, relative volume, passthrough
+	)
+	b, err := c.roundTrip(ctx, c.requests, s, commandCreateRecordStream, args...)
+	if err != nil {
+		c.unregisterStream(s)
+		return nil, err
+	}
+	s.BufferAttr = DefaultBufferAttr()
+	var suspended bool
+	var latency uint64
+	err = decodeReply(commandCreateRecordStream, b,
+		uint32Tag, &s.channel,
+		uint32Tag, &s.Index,
+		uint32Tag, &s.BufferAttr.MaxLength,
+		uint32Tag, &s.BufferAttr.FragSize,
+		&s.SampleSpec,
+		&s.ChannelMap,
+		uint32Tag, &s.SourceIndex,
+		stringTag, &s.SourceName,
+		&suspended,
+		usecTag, &latency)
+	if err != nil {
+		_ = s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *RecordStream) onData(b []byte) {
+	select {
+	case s.data <- append([]byte(nil), b...):
+	default:
+		// the reader doesn't keep up; drop the data like the server does on overflow
+	}
+}
+
+func (s *RecordStream) onCommand(command, *bytes.Buffer) {}
+
+func (s *RecordStream) onClose(err error) {
+	s.closeOnce.Do(func() {
+		s.err = err
+		close(s.done)
+	})
+}
+
+// Read reads captured PCM data. After the stream is closed it returns io.EOF once all
+// buffered data was read, or the error which terminated the stream.
+func (s *RecordStream) Read(p []byte) (int, error) {
+	if len(s.buf) == 0 {
+		select {
+		case s.buf = <-s.data:
+		case <-s.done:
+			// deliver what was received before the stream was closed
+			select {
+			case s.buf = <-s.data:
+			default:
+				return 0, s.err
+			}
+		}
+	}
+	n := copy(p, s.buf)
+	s.buf = s.buf[n:]
+	return n, nil
+}
+
+// Close deletes the stream on the server.
+func (s *RecordStream) Close() error {
+	if !s.client.unregisterStream(s) {
+		// already terminated
+		s.onClose(io.EOF)
+		return nil
+	}
+	s.onClose(io.EOF)
+	_, err := s.client.request(context.Background(), commandDeleteRecordStream, uint32Tag, s.channel)
+	return err
+}
+
+// maxMemblockSize limits the amount of stream data sent in a single frame.
+const maxMemblockSize = 64 * 1024
+
+// writeData sends a memblock on the stream channel. Unlike requests it waits for a free slot
+// in the request queue instead of failing when the queue is full.
+func (c *Client) writeData(ctx context.Context, channel uint32, data []byte) error {
+	b := make([]byte, 20+len(data))
+	binary.BigEndian.PutUint32(b, uint32(len(data)))
+	binary.BigEndian.PutUint32(b[4:], channel)
+	// offset and flags (relative seek) stay zero
+	copy(b[20:], data)
+	resp := make(chan frame, 1)
+	select {
+	case c.requests <- request{data: b, response: resp, memblock: true}:
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.done:
+		return ErrClientClosed
+	}
+	select {
+	case response := <-resp:
+		return response.err
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.done:
+		return ErrClientClosed
+	}
+}
+
+// PlaybackStream plays PCM data on a sink.
+type PlaybackStream struct {
+	client  *Client
+	channel uint32
+
+	// Index of the sink input created for the stream.
+	Index      uint32
+	SampleSpec SampleSpec
+	ChannelMap ChannelMap
+	SinkIndex  uint32
+	SinkName   string
+	// BufferAttr holds the MaxLength, TLength, Prebuf and MinReq chosen by the server.
+	BufferAttr BufferAttr
+
+	mu        sync.Mutex
+	requested int
+	wake      chan struct{}
+	ctx       context.Context // cancelled when the stream is closed
+	cancel    context.CancelFunc
+	closeOnce sync.Once
+	err       error
+}
+
+// Play opens a playback stream on the named sink ("" selects PULSE_SINK or the default sink) for PCM data
+// in the format described by spec. The returned value is a *PlaybackStream which can also be
+// corked, uncorked and drained; closing it deletes the stream on the server.
+//
+// Writes block until the server requests more data, so the caller is paced by the playback.
+// The context only bounds the creation of the stream.
+func (c *Client) Play(ctx context.Context, sinkName string, spec SampleSpec, opts ...StreamOpt) (io.WriteCloser, error) {
+	if c == nil {
+		return nil, ErrClientDisabled
+	}
+	if err := spec.Valid(); err != nil {
+		return nil, err
+	}
+	attr := newStreamOpts(opts).bufferAttr
+	s := &PlaybackStream{
+		client: c,
+		wake:   make(chan struct{}, 1),
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	cvolume := make(CVolume, spec.Channels)
+	for i := range cvolume {
+		cvolume[i] = VolumeNorm
+	}
+	args := []interface{}{
+		spec,
+		defaultChannelMap(spec.Channels),
+		uint32Tag, uint32(0xffffffff), // sink index
+	}
+	if sinkName == "" {
+		sinkName = c.envSink
+	}
+	args = append(args, deviceArgs(sinkName)...)
+	args = append(args,
+		uint32Tag, attr.MaxLength,
+		falseTag, // start corked
+		uint32Tag, attr.TLength,
+		uint32Tag, attr.Prebuf,
+		uint32Tag, attr.MinReq,
+		uint32Tag, uint32(0), // sync id
+		cvolume,
+		falseTag, falseTag, falseTag, falseTag, falseTag, falseTag, falseTag, // no remap, no remix, fix format/rate/channels, don't move, variable rate
+		falseTag, // start muted
+		falseTag, // adjust latency
+		map[string]string{"media.name": "playback"},
+		falseTag,           // volume set
+		falseTag,           // early requests
+		falseTag,           // muted set
+		falseTag, falseTag, // don't inhibit auto suspend, fail on suspend
+		falseTag,           // relative volume
+		falseTag,           // passthrough
+		uint8Tag, uint8(0), // formats
+	)
+	b, err := c.roundTrip(ctx, c.requests, s, commandCreatePlaybackStream, args...)
+	if err != nil {
+		c.unregisterStream(s)
+		return nil, err
+	}
+	var missing uint32
+	s.BufferAttr = DefaultBufferAttr()
+	var suspended bool
+	var latency uint64
+	err = decodeReply(commandCreatePlaybackStream, b,
+		uint32Tag, &s.channel,
+		uint32Tag, &s.Index,
+		uint32Tag, &missing,
+		uint32Tag, &s.BufferAttr.MaxLength,
+		uint32Tag, &s.BufferAttr.TLength,
+		uint32Tag, &s.BufferAttr.Prebuf,
+		uint32Tag, &s.BufferAttr.MinReq,
+		&s.SampleSpec,
+		&s.ChannelMap,
+		uint32Tag, &s.SinkIndex,
+		stringTag, &s.SinkName,
+		&suspended,
+		usecTag, &latency)
+	if err != nil {
+		_ = s.Close()
+		return nil, err
+	}
+	s.addRequested(int(missing))
+	return s, nil
+}
+
+func (s *PlaybackStream) addRequested(n int) {
+	s.mu.Lock()
+	s.requested += n
+	s.mu.Unlock()
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// reserve waits until the server requests data and takes up to max bytes of the request.
+func (s *PlaybackStream) reserve(max int) (int, error) {
+	for {
+		s.mu.Lock()
+		if s.requested > 0 {
+			n := s.requested
+			if n > max {
+				n = max
+			}
+			s.requested -= n
+			s.mu.Unlock()
+			return n, nil
+		}
+		s.mu.Unlock()
+		select {
+		case <-s.wake:
+		case <-s.ctx.Done():
+			return 0, s.err
+		}
+	}
+}
+
+func (s *PlaybackStream) onData([]byte) {}
+
+func (s *PlaybackStream) onCommand(cmd command, b *bytes.Buffer) {
+	if cmd != commandRequest {
+		return
+	}
+	var n uint32
+	if err := bread(b, uint32Tag, &n); err != nil {
+		return
+	}
+	s.addRequested(int(n))
+}
+
+func (s *PlaybackStream) onClose(err error) {
+	s.closeOnce.Do(func() {
+		s.err = err
+		s.cancel()
+	})
+}
+
+// Write sends PCM data to the server. It blocks until the server requested all of it.
+func (s *PlaybackStream) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		max := len(p)
+		if max > maxMemblockSize {
+			max = maxMemblockSize
+		}
+		n, err := s.reserve(max)
+		if err != nil {
+			return written, err
+		}
+		err = s.client.writeData(s.ctx, s.channel, p[:n])
+		if err != nil {
+			if s.ctx.Err() != nil {
+				return written, s.err
+			}
+			return written, err
+		}
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+// Cork pauses the playback.
+func (s *PlaybackStream) Cork(ctx context.Context) error {
+	return s.cork(ctx, true)
+}
+
+// Uncork resumes the playback.
+func (s *PlaybackStream) Uncork(ctx context.Context) error {
+	return s.cork(ctx, false)
+}
+
+func (s *PlaybackStream) cork(ctx context.Context, cork bool) error {
+	corked := falseTag
+	if cork {
+		corked = trueTag
+	}
+	_, err := s.client.request(ctx, commandCorkPlaybackStream, uint32Tag, s.channel, corked)
+	return err
+}
+
+// Drain blocks until all data written to the stream has been played. As this takes as long as
+// the buffered data plays, Opts.RequestTimeout doesn't apply; only ctx bounds the wait.
+func (s *PlaybackStream) Drain(ctx context.Context) error {
+	_, err := s.client.request(withoutRequestTimeout(ctx), commandDrainPlaybackStream, uint32Tag, s.channel)
+	return err
+}
+
+// Close deletes the stream on the server. Data which was not played yet is discarded;
+// call Drain first to play it out.
+func (s *PlaybackStream) Close() error {
+	if !s.client.unregisterStream(s) {
+		// already terminated
+		s.onClose(io.ErrClosedPipe)
+		return nil
+	}
+	s.onClose(io.ErrClosedPipe)
+	_, err := s.client.request(context.Background(), commandDeletePlaybackStream, uint32Tag, s.channel)
+	return err
+}
+
+// ErrForeignStream is returned for operations which PulseAudio only allows on streams
+// created by the same client.
+var ErrForeignStream = errors.New("stream was not created by this client")
+
+// CorkSinkInput pauses (cork) or resumes the sink input with the given index. The native
+// protocol has no command to cork the streams of other clients, so this only works for
+// sink inputs created with Play; ErrForeignStream is returned for any other index.
+func (c *Client) CorkSinkInput(ctx context.Context, index uint32, cork bool) error {
+	if c == nil {
+		return ErrClientDisabled
+	}
+	for _, st := range c.ownStreams() {
+		if s, ok := st.(*PlaybackStream); ok && s.Index == index {
+			return s.cork(ctx, cork)
+		}
+	}
+	return fmt.Errorf("could not cork sink input %d: %w", index, ErrForeignStream)
+}
+
+// CorkSourceOutput pauses (cork) or resumes the source output with the given index.
+// Like CorkSinkInput it only works for source outputs created with Record.
+func (c *Client) CorkSourceOutput(ctx context.Context, index uint32, cork bool) error {
+	if c == nil {
+		return ErrClientDisabled
+	}
+	for _, st := range c.ownStreams() {
+		if s, ok := st.(*RecordStream); ok && s.Index == index {
+			return s.cork(ctx, cork)
+		}
+	}
+	return fmt.Errorf("could not cork source output %d: %w", index, ErrForeignStream)
+}
+
+func (c *Client) ownStreams() []stream {
+	c.streamsMu.Lock()
+	defer c.streamsMu.Unlock()
+	streams := make([]stream, 0, len(c.streams))
+	for _, st := range c.streams {
+		streams = append(streams, st)
+	}
+	return streams
+}
+
+func (s *RecordStream) cork(ctx context.Context, cork bool) error {
+	corked := falseTag
+	if cork {
+		corked = trueTag
+	}
+	_, err := s.client.request(ctx, commandCorkRecordStream, uint32Tag, s.channel, corked)
+	return err
+}
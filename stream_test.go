@@ -0,0 +1,296 @@
+package pulseaudio
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecord(t *testing.T) {
+	srv := newFakeServer(t)
+	var source string
+	var spec SampleSpec
+	deleted := make(chan uint32, 1)
+	srv.handle(commandCreateRecordStream, func(req *bytes.Buffer) ([]interface{}, uint32) {
+		var channelMap ChannelMap
+		var sourceIndex uint32
+		if err := bread(req, &spec, &channelMap, uint32Tag, &sourceIndex, stringTag, &source); err != nil {
+			return nil, 3 // invalid argument
+		}
+		return []interface{}{
+			uint32Tag, uint32(7), // channel
+			uint32Tag, uint32(42), // source output index
+			uint32Tag, uint32(0x10000), // max length
+			uint32Tag, uint32(0x1000), // fragment size
+			spec, channelMap,
+			uint32Tag, uint32(1),
+			stringTag, []byte(source), byte(0),
+			falseTag,
+			usecTag, uint64(2000),
+			formatInfoTag, uint8Tag, uint8(1), map[string]string{},
+		}, 0
+	})
+	srv.handle(commandDeleteRecordStream, func(req *bytes.Buffer) ([]interface{}, uint32) {
+		var channel uint32
+		_ = bread(req, uint32Tag, &channel)
+		deleted <- channel
+		return nil, 0
+	})
+	c := newFakeClient(t, srv)
+	ctx := context.Background()
+
+	r, err := c.Record(ctx, "mic", SampleSpec{Format: SampleS16LE, Channels: 2, Rate: 48000})
+	require.NoError(t, err)
+	assert.Equal(t, "mic", source)
+	assert.Equal(t, SampleSpec{Format: SampleS16LE, Channels: 2, Rate: 48000}, spec)
+	s := r.(*RecordStream)
+	assert.Equal(t, uint32(42), s.Index)
+	assert.Equal(t, "mic", s.SourceName)
+
+	srv.sendData(7, []byte{1, 2, 3, 4})
+	srv.sendData(8, []byte{9, 9}) // another stream
+	srv.sendData(7, []byte{5, 6, 7, 8})
+	buf := make([]byte, 8)
+	_, err = io.ReadFull(r, buf)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{1, 2, 3, 4, 5, 6, 7, 8}, buf)
+
+	require.NoError(t, r.Close())
+	assert.Equal(t, uint32(7), <-deleted)
+	_, err = r.Read(buf)
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestRecordStreamKilled(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.handle(commandCreateRecordStream, func(req *bytes.Buffer) ([]interface{}, uint32) {
+		return []interface{}{
+			uint32Tag, uint32(3), uint32Tag, uint32(1), uint32Tag, uint32(0), uint32Tag, uint32(0),
+			SampleSpec{Format: SampleU8, Channels: 1, Rate: 8000}, ChannelMapMono(),
+			uint32Tag, uint32(1), stringTag, []byte("mic"), byte(0), falseTag, usecTag, uint64(0),
+		}, 0
+	})
+	c := newFakeClient(t, srv)
+
+	r, err := c.Record(context.Background(), "", SampleSpec{Format: SampleU8, Channels: 1, Rate: 8000})
+	require.NoError(t, err)
+	srv.broadcast(commandRecordStreamKilled, uint32Tag, uint32(3))
+	_, err = r.Read(make([]byte, 1))
+	assert.Equal(t, ErrStreamKilled, err)
+	assert.NoError(t, r.Close())
+}
+
+func TestPlay(t *testing.T) {
+	srv := newFakeServer(t)
+	var sink string
+	srv.handle(commandCreatePlaybackStream, func(req *bytes.Buffer) ([]interface{}, uint32) {
+		var spec SampleSpec
+		var channelMap ChannelMap
+		var sinkIndex uint32
+		if err := bread(req, &spec, &channelMap, uint32Tag, &sinkIndex, stringTag, &sink); err != nil {
+			return nil, 3 // invalid argument
+		}
+		return []interface{}{
+			uint32Tag, uint32(5), // channel
+			uint32Tag, uint32(12), // sink input index
+			uint32Tag, uint32(8), // missing
+			uint32Tag, uint32(0x10000), uint32Tag, uint32(0x8000), uint32Tag, uint32(0x4000), uint32Tag, uint32(0x100),
+			spec, channelMap,
+			uint32Tag, uint32(0),
+			stringTag, []byte(sink), byte(0),
+			falseTag,
+			usecTag, uint64(2000),
+			formatInfoTag, uint8Tag, uint8(1), map[string]string{},
+		}, 0
+	})
+	corked := make(chan bool, 2)
+	srv.handle(commandCorkPlaybackStream, func(req *bytes.Buffer) ([]interface{}, uint32) {
+		var channel uint32
+		var cork bool
+		_ = bread(req, uint32Tag, &channel, &cork)
+		corked <- cork
+		return nil, 0
+	})
+	srv.handle(commandDrainPlaybackStream, func(req *bytes.Buffer) ([]interface{}, uint32) {
+		return nil, 0
+	})
+	srv.handle(commandDeletePlaybackStream, func(req *bytes.Buffer) ([]interface{}, uint32) {
+		return nil, 0
+	})
+	c := newFakeClient(t, srv)
+	ctx := context.Background()
+
+	w, err := c.Play(ctx, "speakers", SampleSpec{Format: SampleS16LE, Channels: 2, Rate: 44100})
+	require.NoError(t, err)
+	s := w.(*PlaybackStream)
+	assert.Equal(t, uint32(12), s.Index)
+	assert.Equal(t, "speakers", sink)
+
+	written := make(chan int)
+	go func() {
+		n, err := w.Write([]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12})
+		assert.NoError(t, err)
+		written <- n
+	}()
+	// only the initially requested amount is sent
+	require.Eventually(t, func() bool { return len(srv.streamData(5)) == 8 }, time.Second, time.Millisecond)
+	select {
+	case <-written:
+		t.Fatal("write finished before the server requested more data")
+	case <-time.After(10 * time.Millisecond):
+	}
+	srv.broadcast(commandRequest, uint32Tag, uint32(5), uint32Tag, uint32(16))
+	assert.Equal(t, 12, <-written)
+	require.Eventually(t, func() bool { return len(srv.streamData(5)) == 12 }, time.Second, time.Millisecond)
+	assert.Equal(t, []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}, srv.streamData(5))
+
+	require.NoError(t, s.Cork(ctx))
+	require.NoError(t, s.Uncork(ctx))
+	assert.Equal(t, true, <-corked)
+	assert.Equal(t, false, <-corked)
+	require.NoError(t, s.Drain(ctx))
+	require.NoError(t, w.Close())
+	_, err = w.Write([]byte{1})
+	assert.Equal(t, io.ErrClosedPipe, err)
+}
+
+func TestPlayDrain(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.handle(commandCreatePlaybackStream, func(req *bytes.Buffer) ([]interface{}, uint32) {
+		return []interface{}{
+			uint32Tag, uint32(5), uint32Tag, uint32(12), uint32Tag, uint32(0),
+			uint32Tag, uint32(0x10000), uint32Tag, uint32(0x8000), uint32Tag, uint32(0x4000), uint32Tag, uint32(0x100),
+			SampleSpec{Format: SampleU8, Channels: 1, Rate: 8000}, ChannelMapMono(),
+			uint32Tag, uint32(0), stringTag, []byte("fake"), byte(0), falseTag, usecTag, uint64(0),
+		}, 0
+	})
+	srv.handle(commandDrainPlaybackStream, func(req *bytes.Buffer) ([]interface{}, uint32) {
+		// the buffered data takes longer to play than the request timeout
+		time.Sleep(100 * time.Millisecond)
+		return nil, 0
+	})
+	c := NewClient(Opts{Addr: srv.uri(), Cookie: fakeCookie(t), RequestTimeout: 30 * time.Millisecond})
+	var wg sync.WaitGroup
+	c.Connect(context.Background(), 10*time.Millisecond, &wg)
+	defer func() {
+		c.Close()
+		wg.Wait()
+	}()
+	ctx := context.Background()
+
+	var w io.WriteCloser
+	require.Eventually(t, func() bool {
+		var err error
+		w, err = c.Play(ctx, "fake", SampleSpec{Format: SampleU8, Channels: 1, Rate: 8000})
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+	s := w.(*PlaybackStream)
+	require.NoError(t, s.Drain(ctx))
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, s.Drain(ctx), context.DeadlineExceeded)
+}
+
+func TestBufferAttr(t *testing.T) {
+	srv := newFakeServer(t)
+	requested := make(chan BufferAttr, 2)
+	srv.handle(commandCreatePlaybackStream, func(req *bytes.Buffer) ([]interface{}, uint32) {
+		var spec SampleSpec
+		var channelMap ChannelMap
+		var sinkIndex uint32
+		attr := DefaultBufferAttr()
+		var corked bool
+		err := bread(req, &spec, &channelMap, uint32Tag, &sinkIndex, stringNullTag,
+			uint32Tag, &attr.MaxLength, &corked, uint32Tag, &attr.TLength, uint32Tag, &attr.Prebuf, uint32Tag, &attr.MinReq)
+		if err != nil {
+			return nil, 3 // invalid argument
+		}
+		requested <- attr
+		if attr.TLength == BufferAttrDefault {
+			attr.TLength = 0x10000
+		}
+		return []interface{}{
+			uint32Tag, uint32(5), uint32Tag, uint32(12), uint32Tag, uint32(0),
+			uint32Tag, uint32(0x400000), uint32Tag, attr.TLength, uint32Tag, uint32(0), uint32Tag, uint32(0x100),
+			spec, channelMap,
+			uint32Tag, uint32(0), stringTag, []byte("fake"), byte(0), falseTag, usecTag, uint64(0),
+		}, 0
+	})
+	srv.handle(commandCreateRecordStream, func(req *bytes.Buffer) ([]interface{}, uint32) {
+		var spec SampleSpec
+		var channelMap ChannelMap
+		var sourceIndex uint32
+		attr := DefaultBufferAttr()
+		var corked bool
+		err := bread(req, &spec, &channelMap, uint32Tag, &sourceIndex, stringNullTag,
+			uint32Tag, &attr.MaxLength, &corked, uint32Tag, &attr.FragSize)
+		if err != nil {
+			return nil, 3 // invalid argument
+		}
+		return []interface{}{
+			uint32Tag, uint32(0), uint32Tag, uint32(1), uint32Tag, uint32(0x400000), uint32Tag, attr.FragSize,
+			spec, channelMap,
+			uint32Tag, uint32(1), stringTag, []byte("mic"), byte(0), falseTag, usecTag, uint64(0),
+		}, 0
+	})
+	c := newFakeClient(t, srv)
+	ctx := context.Background()
+	spec := SampleSpec{Format: SampleS16LE, Channels: 2, Rate: 48000}
+
+	w, err := c.Play(ctx, "", spec)
+	require.NoError(t, err)
+	assert.Equal(t, DefaultBufferAttr(), <-requested)
+	assert.Equal(t, BufferAttr{MaxLength: 0x400000, TLength: 0x10000, Prebuf: 0, MinReq: 0x100, FragSize: BufferAttrDefault},
+		w.(*PlaybackStream).BufferAttr)
+
+	lowLatency := DefaultBufferAttr()
+	lowLatency.TLength = 1920 // 10ms
+	lowLatency.Prebuf = 0
+	w, err = c.Play(ctx, "", spec, WithBufferAttr(lowLatency))
+	require.NoError(t, err)
+	assert.Equal(t, lowLatency, <-requested)
+	assert.Equal(t, uint32(1920), w.(*PlaybackStream).BufferAttr.TLength)
+
+	recordAttr := DefaultBufferAttr()
+	recordAttr.FragSize = 960
+	r, err := c.Record(ctx, "", spec, WithBufferAttr(recordAttr))
+	require.NoError(t, err)
+	assert.Equal(t, BufferAttr{MaxLength: 0x400000, TLength: BufferAttrDefault, Prebuf: BufferAttrDefault, MinReq: BufferAttrDefault, FragSize: 960},
+		r.(*RecordStream).BufferAttr)
+}
+
+func TestCorkSinkInput(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.handle(commandCreatePlaybackStream, func(req *bytes.Buffer) ([]interface{}, uint32) {
+		return []interface{}{
+			uint32Tag, uint32(5), uint32Tag, uint32(12), uint32Tag, uint32(0),
+			uint32Tag, uint32(0), uint32Tag, uint32(0), uint32Tag, uint32(0), uint32Tag, uint32(0),
+			SampleSpec{Format: SampleU8, Channels: 1, Rate: 8000}, ChannelMapMono(),
+			uint32Tag, uint32(0), stringTag, []byte("speakers"), byte(0), falseTag, usecTag, uint64(0),
+		}, 0
+	})
+	corked := make(chan uint32, 1)
+	srv.handle(commandCorkPlaybackStream, func(req *bytes.Buffer) ([]interface{}, uint32) {
+		var channel uint32
+		_ = bread(req, uint32Tag, &channel)
+		corked <- channel
+		return nil, 0
+	})
+	c := newFakeClient(t, srv)
+	ctx := context.Background()
+
+	_, err := c.Play(ctx, "", SampleSpec{Format: SampleU8, Channels: 1, Rate: 8000})
+	require.NoError(t, err)
+	require.NoError(t, c.CorkSinkInput(ctx, 12, true))
+	assert.Equal(t, uint32(5), <-corked)
+	assert.True(t, errors.Is(c.CorkSinkInput(ctx, 13, true), ErrForeignStream))
+	assert.True(t, errors.Is(c.CorkSourceOutput(ctx, 12, true), ErrForeignStream))
+}
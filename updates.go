@@ -1,13 +1,191 @@
 package pulseaudio
 
-import "context"
+import (
+	"context"
+	"time"
+)
 
-// Updates returns a channel with PulseAudio updates.
-func (c *Client) Updates(ctx context.Context) (updates <-chan struct{}, err error) {
-	const subscriptionMaskAll = 0x02ff
-	_, err = c.request(ctx, commandSubscribe, uint32Tag, uint32(subscriptionMaskAll))
+const subscriptionMaskAll = 0x02ff
+
+// SubscribeEvents returns a new channel which receives a notification whenever the PulseAudio
+// server state changes. Every caller gets its own channel, so several consumers can observe
+// changes without stealing each other's notifications. Notifications arriving while one is
+// still pending are merged into it. A notification is also sent after the client reconnected,
+// since changes made in between are not reported. The channel is closed when ctx is done or by
+// Close, so ranging over it ends on cancellation.
+func (c *Client) SubscribeEvents(ctx context.Context) (<-chan struct{}, error) {
+	if c == nil {
+		return nil, ErrClientDisabled
+	}
+	_, err := c.request(ctx, commandSubscribe, uint32Tag, uint32(subscriptionMaskAll))
 	if err != nil {
 		return nil, err
 	}
-	return c.updates, nil
+	updates := make(chan struct{}, 1)
+	c.subscribersMu.Lock()
+	defer c.subscribersMu.Unlock()
+	if c.closed {
+		return nil, ErrClientClosed
+	}
+	c.subscribers = append(c.subscribers, updates)
+	if ctx.Done() != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+				c.unsubscribe(updates)
+			case <-c.done:
+				// closed by closeSubscribers
+			}
+		}()
+	}
+	return updates, nil
+}
+
+// unsubscribe removes the channel of a subscriber and closes it, unless closeSubscribers
+// already did.
+func (c *Client) unsubscribe(updates chan struct{}) {
+	c.subscribersMu.Lock()
+	defer c.subscribersMu.Unlock()
+	for i, ch := range c.subscribers {
+		if ch == updates {
+			c.subscribers = append(c.subscribers[:i], c.subscribers[i+1:]...)
+			close(updates)
+			return
+		}
+	}
+}
+
+// SubscribeEventsDebounced is like SubscribeEvents but collapses bursts of changes into at most
+// one notification per window. The notification is sent at the end of the window, so the last
+// change of a burst is always reported. The channel is closed when ctx is done or the client
+// is closed.
+func (c *Client) SubscribeEventsDebounced(ctx context.Context, window time.Duration) (<-chan struct{}, error) {
+	updates, err := c.SubscribeEvents(ctx)
+	if err != nil {
+		return nil, err
+	}
+	debounced := make(chan struct{}, 1)
+	go func() {
+		defer close(debounced)
+		var timer *time.Timer
+		var fire <-chan time.Time
+		defer func() {
+			if timer != nil {
+				timer.Stop()
+			}
+		}()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case _, ok := <-updates:
+				if !ok {
+					return
+				}
+				if fire == nil {
+					timer = time.NewTimer(window)
+					fire = timer.C
+				}
+			case <-fire:
+				fire = nil
+				select {
+				case debounced <- struct{}{}:
+				default:
+					// a notification is already pending
+				}
+			}
+		}
+	}()
+	return debounced, nil
+}
+
+// Updates returns a channel with PulseAudio updates, like SubscribeEvents.
+func (c *Client) Updates(ctx context.Context) (updates <-chan struct{}, err error) {
+	return c.SubscribeEvents(ctx)
+}
+
+// notifySubscribers passes a change notification to every subscriber without blocking.
+func (c *Client) notifySubscribers() {
+	c.subscribersMu.Lock()
+	defer c.subscribersMu.Unlock()
+	for _, updates := range c.subscribers {
+		select {
+		case updates <- struct{}{}:
+		default:
+			// a notification is already pending
+		}
+	}
+}
+
+// eventHandler is called with every subscription event, on the receive goroutine, so it must
+// not block. reconnected, if set, is called when the client connected again; events of the time
+// in between were lost then and indexes may refer to other objects after a server restart.
+type eventHandler struct {
+	fn          func(facility Facility, operation Operation, index uint32)
+	reconnected func()
+}
+
+// addEventHandler registers fn for all subscription events and reconnected, which may be nil, for
+// reconnections. The events must have been subscribed to, e.g. with SubscribeEvents.
+func (c *Client) addEventHandler(fn func(facility Facility, operation Operation, index uint32), reconnected func()) *eventHandler {
+	h := &eventHandler{fn: fn, reconnected: reconnected}
+	c.subscribersMu.Lock()
+	defer c.subscribersMu.Unlock()
+	c.eventHandlers = append(c.eventHandlers, h)
+	return h
+}
+
+func (c *Client) removeEventHandler(h *eventHandler) {
+	c.subscribersMu.Lock()
+	defer c.subscribersMu.Unlock()
+	for i, other := range c.eventHandlers {
+		if other == h {
+			c.eventHandlers = append(c.eventHandlers[:i], c.eventHandlers[i+1:]...)
+			return
+		}
+	}
+}
+
+// dispatchEvent passes a subscription event to the event handlers.
+func (c *Client) dispatchEvent(event, index uint32) {
+	facility, operation, ok := ParseEventType(event)
+	if !ok {
+		return
+	}
+	c.subscribersMu.Lock()
+	defer c.subscribersMu.Unlock()
+	for _, h := range c.eventHandlers {
+		h.fn(facility, operation, index)
+	}
+}
+
+// notifyConnected tells the event handlers and subscribers that the connection was established.
+// Subscriptions made earlier were renewed by init, but changes made while disconnected were not
+// reported, so subscribers have to look at the server state again.
+func (c *Client) notifyConnected() {
+	c.subscribersMu.Lock()
+	for _, h := range c.eventHandlers {
+		if h.reconnected != nil {
+			h.reconnected()
+		}
+	}
+	c.subscribersMu.Unlock()
+	c.notifySubscribers()
+}
+
+func (c *Client) hasSubscribers() bool {
+	c.subscribersMu.Lock()
+	defer c.subscribersMu.Unlock()
+	return len(c.subscribers) > 0
+}
+
+// closeSubscribers closes the channels of all subscribers.
+func (c *Client) closeSubscribers() {
+	c.subscribersMu.Lock()
+	defer c.subscribersMu.Unlock()
+	c.closed = true
+	for _, updates := range c.subscribers {
+		close(updates)
+	}
+	c.subscribers = nil
 }
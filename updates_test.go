@@ -0,0 +1,113 @@
+package pulseaudio
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sinkChangeEvent is the subscription event sent when sink 0 changes.
+var sinkChangeEvent = []interface{}{uint32Tag, uint32(0x0010), uint32Tag, uint32(0)}
+
+func TestSubscribeEventsFanOut(t *testing.T) {
+	srv := newFakeServer(t)
+	c := NewClient(Opts{
+		Addr:           srv.uri(),
+		Cookie:         fakeCookie(t),
+		RequestTimeout: time.Second,
+	})
+	var wg sync.WaitGroup
+	c.Connect(context.Background(), 10*time.Millisecond, &wg)
+	ctx := context.Background()
+
+	mixer, err := c.SubscribeEvents(ctx)
+	require.NoError(t, err)
+	logger, err := c.Updates(ctx)
+	require.NoError(t, err)
+
+	srv.broadcast(commandSubscribeEvent, sinkChangeEvent...)
+	for _, updates := range []<-chan struct{}{mixer, logger} {
+		select {
+		case <-updates:
+		case <-time.After(time.Second):
+			t.Fatal("subscriber was not notified")
+		}
+	}
+
+	// a burst of events is merged into a single pending notification
+	for i := 0; i < 3; i++ {
+		srv.broadcast(commandSubscribeEvent, sinkChangeEvent...)
+	}
+	require.Eventually(t, func() bool { return len(mixer) == 1 && len(logger) == 1 }, time.Second, time.Millisecond)
+
+	c.Close()
+	wg.Wait()
+	<-mixer
+	_, ok := <-mixer
+	assert.False(t, ok, "channel was not closed")
+}
+
+func TestSubscribeEventsDebounced(t *testing.T) {
+	srv := newFakeServer(t)
+	c := newFakeClient(t, srv)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	updates, err := c.SubscribeEventsDebounced(ctx, 100*time.Millisecond)
+	require.NoError(t, err)
+
+	// a burst of 50 events well within the window
+	for i := 0; i < 50; i++ {
+		srv.broadcast(commandSubscribeEvent, sinkChangeEvent...)
+	}
+
+	select {
+	case <-updates:
+	case <-time.After(time.Second):
+		t.Fatal("no notification was sent")
+	}
+	select {
+	case <-updates:
+		t.Fatal("burst caused more than one notification")
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	cancel()
+	_, ok := <-updates
+	assert.False(t, ok, "channel was not closed")
+}
+
+func TestSubscribeEventsCancel(t *testing.T) {
+	srv := newFakeServer(t)
+	c := newFakeClient(t, srv)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	cancelled, err := c.SubscribeEvents(ctx)
+	require.NoError(t, err)
+	remaining, err := c.Updates(context.Background())
+	require.NoError(t, err)
+
+	ended := make(chan struct{})
+	go func() {
+		defer close(ended)
+		for range cancelled {
+		}
+	}()
+	cancel()
+	select {
+	case <-ended:
+	case <-time.After(time.Second):
+		t.Fatal("channel was not closed when ctx was done")
+	}
+
+	srv.broadcast(commandSubscribeEvent, sinkChangeEvent...)
+	select {
+	case _, ok := <-remaining:
+		assert.True(t, ok)
+	case <-time.After(time.Second):
+		t.Fatal("other subscriber was not notified")
+	}
+}
@@ -1,53 +1,438 @@
 package pulseaudio
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"math"
+	"time"
 )
 
-const pulseVolumeMax = 0xffff
+// Channel volumes as used in CVolume, matching PA_VOLUME_MUTED, PA_VOLUME_NORM and PA_VOLUME_MAX.
+const (
+	// VolumeMuted is silence.
+	VolumeMuted = 0
+	// VolumeNorm is the volume at 100%, i.e. without attenuation or boost.
+	VolumeNorm = 0x10000
+	// VolumeMax is the largest volume the server accepts.
+	VolumeMax = math.MaxUint32 / 2
+)
+
+// toVolume converts a volume where 1 is 100% to a channel volume, clamped to the range from
+// VolumeMuted to VolumeMax.
+func toVolume(volume float32) uint32 {
+	v := math.Round(float64(volume) * VolumeNorm)
+	switch {
+	case v < VolumeMuted || math.IsNaN(v):
+		return VolumeMuted
+	case v > VolumeMax:
+		return VolumeMax
+	}
+	return uint32(v)
+}
+
+// capVolume caps a volume where 1 is 100% at 100% unless boosting is allowed.
+func (c *Client) capVolume(volume float32) float32 {
+	if !c.allowBoost && volume > 1 {
+		return 1
+	}
+	return volume
+}
+
+// channelVolume converts a volume where 1 is 100% to a channel volume, capped at 100% unless
+// boosting is allowed.
+func (c *Client) channelVolume(volume float32) uint32 {
+	return toVolume(c.capVolume(volume))
+}
+
+// NewCVolume returns a CVolume which sets each of channels to volume, where 1 is 100%. The
+// channel volume is clamped to the range from VolumeMuted to VolumeMax. A CVolume with a single
+// channel sets all channels of a sink alike.
+func NewCVolume(channels int, volume float32) CVolume {
+	if channels < 0 {
+		channels = 0
+	}
+	cvolume := make(CVolume, channels)
+	for i := range cvolume {
+		cvolume[i] = toVolume(volume)
+	}
+	return cvolume
+}
+
+// NewCVolumeDB is like NewCVolume but takes the volume in decibels, 0 being 100%. Like
+// pa_sw_volume_from_dB the linear amplitude is mapped onto the cubic volume scale of PulseAudio,
+// so -6 dB is about 79%. math.Inf(-1) is muted.
+func NewCVolumeDB(channels int, db float64) CVolume {
+	linear := math.Pow(10, db/20)
+	return NewCVolume(channels, float32(math.Cbrt(linear)))
+}
+
+// fromVolume converts a channel volume to a volume where 1 is 100%.
+func fromVolume(volume uint32) float32 {
+	return float32(float64(volume) / VolumeNorm)
+}
+
+// Avg returns the average volume of all channels, or 0 if there are none.
+func (v CVolume) Avg() uint32 {
+	if len(v) == 0 {
+		return 0
+	}
+	var sum uint64
+	for _, vol := range v {
+		sum += uint64(vol)
+	}
+	return uint32(sum / uint64(len(v)))
+}
+
+// Max returns the volume of the loudest channel, or 0 if there are none.
+func (v CVolume) Max() uint32 {
+	var max uint32
+	for _, vol := range v {
+		if vol > max {
+			max = vol
+		}
+	}
+	return max
+}
+
+// Scale returns a copy with every channel multiplied by factor, keeping the balance between
+// the channels. Results are clamped to the range the server accepts.
+func (v CVolume) Scale(factor float64) CVolume {
+	scaled := make(CVolume, len(v))
+	for i, vol := range v {
+		f := math.Round(float64(vol) * factor)
+		switch {
+		case f < 0:
+			f = 0
+		case f > VolumeMax:
+			f = VolumeMax
+		}
+		scaled[i] = uint32(f)
+	}
+	return scaled
+}
+
+// Percent returns the average volume in percent of the normal volume, e.g. 100 for 0x10000.
+func (v CVolume) Percent() float32 {
+	return float32(float64(v.Avg()) * 100 / VolumeNorm)
+}
+
+// VolumeController controls the volume of the default sink. It is implemented by Client,
+// which speaks the native protocol, and CliClient, which runs pactl.
+type VolumeController interface {
+	Volume(ctx context.Context) (float32, error)
+	SetVolume(ctx context.Context, volume float32) error
+	Mute(ctx context.Context) (bool, error)
+	SetMute(ctx context.Context, mute bool) error
+	ToggleMute(ctx context.Context) (bool, error)
+}
+
+var _ VolumeController = (*Client)(nil)
+
+// rampStep is the interval between volume changes made by RampVolume.
+const rampStep = 20 * time.Millisecond
 
 // Volume returns current audio volume as a number from 0 to 1 (or more than 1 - if volume is boosted).
+// Like the other volume and mute operations it acts on the sink named by PULSE_SINK if set,
+// on the default sink otherwise.
 func (c *Client) Volume(ctx context.Context) (float32, error) {
 	if c == nil {
 		return 0.0, ErrClientDisabled
 	}
-	s, err := c.ServerInfo(ctx)
+	var volume float32
+	err := c.retryTargetSink(ctx, func(name string) error {
+		sinks, err := c.Sinks(ctx)
+		for _, sink := range sinks {
+			if sink.Name != name {
+				continue
+			}
+			volume = fromVolume(sink.CVolume.Avg())
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("PulseAudio error: couldn't query volume - Sink %s not found: %w", name, ErrNoSuchEntity)
+	})
+	return volume, err
+}
+
+// SetVolume changes the current volume to a specified value from 0 to 1 (or more than 1 - if volume should be boosted).
+func (c *Client) SetVolume(ctx context.Context, volume float32) error {
+	if c == nil {
+		return ErrClientDisabled
+	}
+	return c.retryTargetSink(ctx, func(name string) error {
+		return c.setSinkVolume(ctx, name, NewCVolume(1, c.capVolume(volume)))
+	})
+}
+
+// VolumePercent is like Volume but returns the volume in integer percent of VolumeNorm, rounded
+// to the nearest percent.
+func (c *Client) VolumePercent(ctx context.Context) (uint32, error) {
+	if c == nil {
+		return 0, ErrClientDisabled
+	}
+	var percent uint32
+	err := c.retryTargetSink(ctx, func(name string) error {
+		sink, err := c.findSink(ctx, name)
+		if err != nil {
+			return err
+		}
+		percent = uint32((uint64(sink.CVolume.Avg())*100 + VolumeNorm/2) / VolumeNorm)
+		return nil
+	})
+	return percent, err
+}
+
+// SetVolumePercent is like SetVolume but takes the volume in integer percent of VolumeNorm, e.g.
+// 100 for the normal volume. The conversion is exact, so VolumePercent returns the same value.
+func (c *Client) SetVolumePercent(ctx context.Context, percent uint32) error {
+	if c == nil {
+		return ErrClientDisabled
+	}
+	if !c.allowBoost && percent > 100 {
+		percent = 100
+	}
+	volume := (uint64(percent)*VolumeNorm + 50) / 100
+	if volume > VolumeMax {
+		volume = VolumeMax
+	}
+	return c.retryTargetSink(ctx, func(name string) error {
+		return c.setSinkVolume(ctx, name, CVolume{uint32(volume)})
+	})
+}
+
+// SetVolumeAndGet is like SetVolume but returns the volume the server reports afterwards, after
+// clamping and quantization, e.g. for a slider which snaps to the actual value.
+func (c *Client) SetVolumeAndGet(ctx context.Context, volume float32) (float32, error) {
+	if c == nil {
+		return 0, ErrClientDisabled
+	}
+	var name string
+	err := c.retryTargetSink(ctx, func(target string) error {
+		name = target
+		return c.setSinkVolume(ctx, name, NewCVolume(1, c.capVolume(volume)))
+	})
 	if err != nil {
 		return 0, err
 	}
-	sinks, err := c.Sinks(ctx)
-	for _, sink := range sinks {
-		if sink.Name != s.DefaultSink {
-			continue
-		}
-		return float32(sink.CVolume[0]) / pulseVolumeMax, nil
+	sink, err := c.GetSinkByName(ctx, name)
+	if err != nil {
+		return 0, err
 	}
-	return 0, fmt.Errorf("PulseAudio error: couldn't query volume - Sink %s not found", s.DefaultSink)
+	return fromVolume(sink.CVolume.Avg()), nil
 }
 
-// SetVolume changes the current volume to a specified value from 0 to 1 (or more than 1 - if volume should be boosted).
-func (c *Client) SetVolume(ctx context.Context, volume float32) error {
+// SetVolumeStep sets every channel of the named sink to one of its discrete volume steps, from 0
+// (muted) to VolumeSteps()-1 (VolumeNorm), so that the volume matches the readout of the
+// hardware. The step is checked against the steps of the sink.
+func (c *Client) SetVolumeStep(ctx context.Context, sinkName string, step uint32) error {
 	if c == nil {
 		return ErrClientDisabled
 	}
-	s, err := c.ServerInfo(ctx)
+	sink, err := c.GetSinkByName(ctx, sinkName)
 	if err != nil {
 		return err
 	}
-	return c.setSinkVolume(ctx, s.DefaultSink, CVolume{uint32(volume * 0xffff)})
+	steps := sink.VolumeSteps()
+	if steps < 2 || step >= steps {
+		return fmt.Errorf("invalid volume step %d of sink %s with %d steps: %w", step, sinkName, steps, ErrInvalidArgument)
+	}
+	last := uint64(steps - 1)
+	volume := (uint64(step)*VolumeNorm + last/2) / last
+	return c.setSinkVolume(ctx, sinkName, CVolume{uint32(volume)})
 }
 
 func (c *Client) SetSinkVolume(ctx context.Context, sinkName string, volume float32) error {
 	if c == nil {
 		return ErrClientDisabled
 	}
-	return c.setSinkVolume(ctx, sinkName, CVolume{uint32(volume * 0xffff)})
+	return c.setSinkVolume(ctx, sinkName, NewCVolume(1, c.capVolume(volume)))
+}
+
+// SetSinkVolumeByIndex is like SetSinkVolume but addresses the sink by index, which avoids a
+// lookup by name after the sinks were enumerated.
+func (c *Client) SetSinkVolumeByIndex(ctx context.Context, index uint32, volume float32) error {
+	if c == nil {
+		return ErrClientDisabled
+	}
+	_, err := c.request(ctx, commandSetSinkVolume, uint32Tag, index, stringNullTag, NewCVolume(1, c.capVolume(volume)))
+	return err
+}
+
+// SetSinkVolumeMapped sets the volume of the channels of the named sink by position, e.g.
+// {ChannelRearLeft: 0.8, ChannelRearRight: 0.8}, looking up the index of each position in the
+// channel map of the sink. Channels at other positions keep their volume. Positions the sink
+// doesn't have are rejected with ErrInvalidArgument.
+func (c *Client) SetSinkVolumeMapped(ctx context.Context, sinkName string, m map[ChannelPosition]float32) error {
+	if c == nil {
+		return ErrClientDisabled
+	}
+	sink, err := c.GetSinkByName(ctx, sinkName)
+	if err != nil {
+		return err
+	}
+	if len(sink.CVolume) != len(sink.ChannelMap) {
+		return fmt.Errorf("PulseAudio error: sink %s has %d channel volumes for %d channels", sinkName, len(sink.CVolume), len(sink.ChannelMap))
+	}
+	for pos := range m {
+		if bytes.IndexByte(sink.ChannelMap, byte(pos)) < 0 {
+			return fmt.Errorf("sink %s has no channel at position %s: %w", sinkName, pos, ErrInvalidArgument)
+		}
+	}
+	cvolume := append(CVolume(nil), sink.CVolume...)
+	for i, pos := range sink.ChannelMap {
+		if volume, ok := m[ChannelPosition(pos)]; ok {
+			cvolume[i] = c.channelVolume(volume)
+		}
+	}
+	return c.setSinkVolume(ctx, sinkName, cvolume)
+}
+
+// AdjustVolume changes the volume of the default sink by delta (e.g. 0.05 for "volume up 5%").
+// Every channel is adjusted by the same amount and clamped to the range from 0 to the maximum
+// volume set with WithMaxVolume.
+func (c *Client) AdjustVolume(ctx context.Context, delta float32) error {
+	if c == nil {
+		return ErrClientDisabled
+	}
+	// serialize read-modify-write cycles so that repeated key presses don't overwrite each other
+	c.adjustMu.Lock()
+	defer c.adjustMu.Unlock()
+	return c.retryTargetSink(ctx, func(name string) error {
+		sink, err := c.findSink(ctx, name)
+		if err != nil {
+			return err
+		}
+		cvolume := make(CVolume, len(sink.CVolume))
+		for i, v := range sink.CVolume {
+			vol := fromVolume(v) + delta
+			if vol < 0 {
+				vol = 0
+			}
+			if vol > c.maxVolume {
+				vol = c.maxVolume
+			}
+			cvolume[i] = c.channelVolume(vol)
+		}
+		return c.setSinkVolume(ctx, sink.Name, cvolume)
+	})
+}
+
+// RampVolume gradually changes the volume of every channel of the named sink from its current
+// value to target over the given duration. If ctx is cancelled the volume is left where the
+// ramp stopped.
+func (c *Client) RampVolume(ctx context.Context, sinkName string, target float32, duration time.Duration) error {
+	if c == nil {
+		return ErrClientDisabled
+	}
+	if target < 0 {
+		return fmt.Errorf("PulseAudio error: invalid volume %f", target)
+	}
+	sink, err := c.GetSinkByName(ctx, sinkName)
+	if err != nil {
+		return err
+	}
+	steps := int(duration / rampStep)
+	interval := rampStep
+	if steps < 1 {
+		steps = 1
+	} else {
+		interval = duration / time.Duration(steps)
+	}
+	start := sink.CVolume
+	end := float32(c.channelVolume(target))
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for step := 1; step <= steps; step++ {
+		if step > 1 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-ticker.C:
+			}
+		}
+		cvolume := make(CVolume, len(start))
+		for i, v := range start {
+			cvolume[i] = uint32(float32(v) + (end-float32(v))*float32(step)/float32(steps))
+		}
+		err = c.setSinkVolume(ctx, sinkName, cvolume)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// targetSink returns the name of the sink volume and mute operations act on: the sink named by
+// PULSE_SINK if set, the default sink of the server otherwise.
+func (c *Client) targetSink(ctx context.Context) (string, error) {
+	if c.envSink != "" {
+		return c.envSink, nil
+	}
+	s, err := c.ServerInfo(ctx)
+	if err != nil {
+		return "", err
+	}
+	return s.DefaultSink, nil
+}
+
+// targetSource is like targetSink for PULSE_SOURCE and the default source.
+func (c *Client) targetSource(ctx context.Context) (string, error) {
+	if c.envSource != "" {
+		return c.envSource, nil
+	}
+	s, err := c.ServerInfo(ctx)
+	if err != nil {
+		return "", err
+	}
+	return s.DefaultSource, nil
+}
+
+// retryTargetSink runs op with the name of the sink returned by targetSink. With
+// WithRetryOnTransientEntity op is run again with the name read from a fresh server info if it
+// fails with ErrNoSuchEntity, since the default sink may name a sink which was just removed
+// while the server switches devices.
+func (c *Client) retryTargetSink(ctx context.Context, op func(name string) error) error {
+	for attempt := 1; ; attempt++ {
+		name, err := c.targetSink(ctx)
+		if err != nil {
+			return err
+		}
+		err = op(name)
+		if err == nil || !errors.Is(err, ErrNoSuchEntity) || attempt >= c.retryAttempts {
+			return err
+		}
+		c.logger.Infof("sink %s not found, retrying: %v", name, err)
+		c.invalidateServerInfo()
+		timer := time.NewTimer(c.retryBackoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// findSink returns the named sink from the list of all sinks.
+func (c *Client) findSink(ctx context.Context, name string) (*Sink, error) {
+	sinks, err := c.Sinks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := range sinks {
+		if sinks[i].Name == name {
+			return &sinks[i], nil
+		}
+	}
+	return nil, fmt.Errorf("PulseAudio error: sink %s not found: %w", name, ErrNoSuchEntity)
 }
 
 func (c *Client) setSinkVolume(ctx context.Context, sinkName string, cvolume CVolume) error {
-	res, err := c.request(ctx, commandSetSinkVolume, uint32Tag, uint32(0xffffffff), stringTag, []byte(sinkName), byte(0), cvolume)
-	fmt.Println(res.String())
+	_, err := c.request(ctx, commandSetSinkVolume, uint32Tag, uint32(0xffffffff), stringTag, []byte(sinkName), byte(0), cvolume)
 	return err
 }
 
@@ -56,11 +441,6 @@ func (c *Client) ToggleMute(ctx context.Context) (bool, error) {
 	if c == nil {
 		return false, ErrClientDisabled
 	}
-	s, err := c.ServerInfo(ctx)
-	if err != nil || s == nil {
-		return true, err
-	}
-
 	muted, err := c.Mute(ctx)
 	if err != nil {
 		return true, err
@@ -70,16 +450,27 @@ func (c *Client) ToggleMute(ctx context.Context) (bool, error) {
 	return !muted, err
 }
 
+// ToggleSinkMute reverses the mute status of the named sink and returns the new status.
+func (c *Client) ToggleSinkMute(ctx context.Context, sinkName string) (bool, error) {
+	if c == nil {
+		return false, ErrClientDisabled
+	}
+	sink, err := c.GetSinkByName(ctx, sinkName)
+	if err != nil {
+		return false, err
+	}
+	err = c.SetSinkMute(ctx, sinkName, !sink.Muted)
+	return !sink.Muted, err
+}
+
 // SetMute reverse mute status
 func (c *Client) SetMute(ctx context.Context, mute bool) error {
 	if c == nil {
 		return ErrClientDisabled
 	}
-	s, err := c.ServerInfo(ctx)
-	if err != nil || s == nil {
-		return err
-	}
-	return c.SetSinkMute(ctx, s.DefaultSink, mute)
+	return c.retryTargetSink(ctx, func(name string) error {
+		return c.SetSinkMute(ctx, name, mute)
+	})
 }
 
 // SetSinkMute reverse mute status
@@ -95,24 +486,79 @@ func (c *Client) SetSinkMute(ctx context.Context, sinkName string, mute bool) er
 	return err
 }
 
-func (c *Client) Mute(ctx context.Context) (bool, error) {
+// SetSinkMuteByIndex is like SetSinkMute but addresses the sink by index.
+func (c *Client) SetSinkMuteByIndex(ctx context.Context, index uint32, mute bool) error {
+	if c == nil {
+		return ErrClientDisabled
+	}
+	muteCmd := '0'
+	if mute {
+		muteCmd = '1'
+	}
+	_, err := c.request(ctx, commandSetSinkMute, uint32Tag, index, stringNullTag, uint8(muteCmd))
+	return err
+}
+
+// SetSourceMute mutes or unmutes the named source, e.g. a microphone.
+func (c *Client) SetSourceMute(ctx context.Context, sourceName string, mute bool) error {
+	if c == nil {
+		return ErrClientDisabled
+	}
+	muteCmd := '0'
+	if mute {
+		muteCmd = '1'
+	}
+	_, err := c.request(ctx, commandSetSourceMute, uint32Tag, uint32(0xffffffff), stringTag, []byte(sourceName), byte(0), uint8(muteCmd))
+	return err
+}
+
+// ToggleSourceMute reverses the mute status of the named source and returns the new status,
+// e.g. for a microphone mute hotkey.
+func (c *Client) ToggleSourceMute(ctx context.Context, sourceName string) (bool, error) {
 	if c == nil {
 		return false, ErrClientDisabled
 	}
-	s, err := c.ServerInfo(ctx)
-	if err != nil || s == nil {
+	source, err := c.GetSourceByName(ctx, sourceName)
+	if err != nil {
 		return false, err
 	}
+	err = c.SetSourceMute(ctx, sourceName, !source.Muted)
+	return !source.Muted, err
+}
 
-	sinks, err := c.Sinks(ctx)
+// ToggleDefaultSourceMute is like ToggleSourceMute for the source named by PULSE_SOURCE if set,
+// the default source of the server otherwise.
+func (c *Client) ToggleDefaultSourceMute(ctx context.Context) (bool, error) {
+	if c == nil {
+		return false, ErrClientDisabled
+	}
+	name, err := c.targetSource(ctx)
 	if err != nil {
 		return false, err
 	}
-	for _, sink := range sinks {
-		if sink.Name != s.DefaultSink {
-			continue
-		}
-		return sink.Muted, nil
+	return c.ToggleSourceMute(ctx, name)
+}
+
+func (c *Client) Mute(ctx context.Context) (bool, error) {
+	if c == nil {
+		return false, ErrClientDisabled
 	}
-	return true, fmt.Errorf("couldn't find Sink")
+	var muted bool
+	err := c.retryTargetSink(ctx, func(name string) error {
+		sinks, err := c.Sinks(ctx)
+		if err != nil {
+			muted = false
+			return err
+		}
+		for _, sink := range sinks {
+			if sink.Name != name {
+				continue
+			}
+			muted = sink.Muted
+			return nil
+		}
+		muted = true
+		return fmt.Errorf("couldn't find Sink: %w", ErrNoSuchEntity)
+	})
+	return muted, err
 }
@@ -0,0 +1,411 @@
+package pulseaudio
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// assertVolume checks that every channel is within rounding distance of the expected volumes.
+func assertVolume(t *testing.T, expected []float32, actual CVolume) {
+	t.Helper()
+	if assert.Len(t, actual, len(expected)) {
+		for i, v := range expected {
+			assert.InDelta(t, v*VolumeNorm, actual[i], 2, "channel %d", i)
+		}
+	}
+}
+
+func TestCVolumeHelpers(t *testing.T) {
+	v := CVolume{0x10000, 0x8000}
+	assert.Equal(t, uint32(0xc000), v.Avg())
+	assert.Equal(t, uint32(0x10000), v.Max())
+	assert.Equal(t, float32(75), v.Percent())
+	assert.Equal(t, CVolume{0x8000, 0x4000}, v.Scale(0.5))
+	assert.Equal(t, CVolume{0x10000, 0x8000}, v, "Scale must not modify the receiver")
+	assert.Equal(t, CVolume{VolumeMax, 0}, CVolume{0x10000, 0}.Scale(1e9))
+	assert.Equal(t, CVolume{0, 0}, v.Scale(-1))
+
+	assert.Equal(t, uint32(0), CVolume{}.Avg())
+	assert.Equal(t, uint32(0), CVolume{}.Max())
+	assert.Equal(t, float32(0), CVolume(nil).Percent())
+	assert.Equal(t, uint32(0xffffffff), CVolume{0xffffffff, 0xffffffff}.Avg())
+}
+
+func TestNewCVolume(t *testing.T) {
+	assert.Equal(t, CVolume{VolumeNorm, VolumeNorm}, NewCVolume(2, 1))
+	assert.Equal(t, CVolume{0x8000}, NewCVolume(1, 0.5))
+	assert.Equal(t, CVolume{VolumeMuted, VolumeMuted, VolumeMuted}, NewCVolume(3, -1))
+	assert.Equal(t, CVolume{VolumeMax}, NewCVolume(1, 1e12))
+	assert.Empty(t, NewCVolume(-1, 1))
+
+	assert.Equal(t, CVolume{VolumeNorm, VolumeNorm}, NewCVolumeDB(2, 0))
+	// -6 dB halves the amplitude: 0.5^(1/3) on the cubic scale, like pa_sw_volume_from_dB
+	assertVolume(t, []float32{0.7937, 0.7937}, NewCVolumeDB(2, -6.0206))
+	assertVolume(t, []float32{1.2599}, NewCVolumeDB(1, 6.0206))
+	assert.Equal(t, CVolume{VolumeMuted}, NewCVolumeDB(1, math.Inf(-1)))
+	assert.Equal(t, CVolume{VolumeMax}, NewCVolumeDB(1, math.Inf(1)))
+}
+
+func TestVolumeNorm(t *testing.T) {
+	srv := newFakeServer(t)
+	c := newFakeClient(t, srv)
+	ctx := context.Background()
+
+	require.NoError(t, c.SetVolume(ctx, 1))
+	assert.Equal(t, CVolume{VolumeNorm}, srv.volume("fake"))
+	require.NoError(t, c.SetVolume(ctx, 1.5))
+	assert.Equal(t, CVolume{0x18000}, srv.volume("fake"))
+	vol, err := c.Volume(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, float32(1.5), vol)
+}
+
+func TestAdjustVolume(t *testing.T) {
+	srv := newFakeServer(t)
+	c := newFakeClient(t, srv, WithMaxVolume(1.2))
+	ctx := context.Background()
+
+	srv.setVolume("fake", CVolume{VolumeNorm / 2, VolumeNorm / 4})
+	require.NoError(t, c.AdjustVolume(ctx, 0.25))
+	assertVolume(t, []float32{0.75, 0.5}, srv.volume("fake"))
+}
+
+func TestAdjustVolumeClampAtZero(t *testing.T) {
+	srv := newFakeServer(t)
+	c := newFakeClient(t, srv)
+	ctx := context.Background()
+
+	srv.setVolume("fake", CVolume{0x1000, 0x2000})
+	require.NoError(t, c.AdjustVolume(ctx, -0.5))
+	assert.Equal(t, CVolume{0, 0}, srv.volume("fake"))
+}
+
+func TestAdjustVolumeClampAtMax(t *testing.T) {
+	srv := newFakeServer(t)
+	ctx := context.Background()
+
+	c := newFakeClient(t, srv)
+	srv.setVolume("fake", CVolume{VolumeNorm, VolumeNorm})
+	require.NoError(t, c.AdjustVolume(ctx, 1))
+	assertVolume(t, []float32{defaultMaxVolume, defaultMaxVolume}, srv.volume("fake"))
+
+	capped := newFakeClient(t, srv, WithMaxVolume(1))
+	require.NoError(t, capped.AdjustVolume(ctx, 0.05))
+	assertVolume(t, []float32{1, 1}, srv.volume("fake"))
+}
+
+func TestRampVolume(t *testing.T) {
+	srv := newFakeServer(t)
+	c := newFakeClient(t, srv)
+	ctx := context.Background()
+
+	srv.setVolume("fake", CVolume{0, VolumeNorm / 2})
+	require.NoError(t, c.RampVolume(ctx, "fake", 1, 100*time.Millisecond))
+	writes := srv.volumeWrites()
+	require.Len(t, writes, 5)
+	for i := 1; i < len(writes); i++ {
+		assert.Greater(t, writes[i][0], writes[i-1][0])
+		assert.Greater(t, writes[i][1], writes[i-1][1])
+	}
+	assertVolume(t, []float32{0.2, 0.6}, writes[0])
+	assertVolume(t, []float32{1, 1}, srv.volume("fake"))
+
+	// a ramp shorter than one step sets the target at once
+	require.NoError(t, c.RampVolume(ctx, "fake", 0.5, 0))
+	assert.Len(t, srv.volumeWrites(), 6)
+	assertVolume(t, []float32{0.5, 0.5}, srv.volume("fake"))
+
+	assert.Error(t, c.RampVolume(ctx, "missing", 1, time.Second))
+	assert.Error(t, c.RampVolume(ctx, "fake", -1, time.Second))
+}
+
+func TestRampVolumeCancel(t *testing.T) {
+	srv := newFakeServer(t)
+	c := newFakeClient(t, srv)
+
+	srv.setVolume("fake", CVolume{VolumeNorm, VolumeNorm})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := c.RampVolume(ctx, "fake", 0, time.Second)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "unexpected error: %v", err)
+	writes := srv.volumeWrites()
+	assert.NotEmpty(t, writes)
+	assert.Less(t, len(writes), 50)
+	assert.Greater(t, srv.volume("fake")[0], uint32(0))
+}
+
+func TestVolumeController(t *testing.T) {
+	srv := newFakeServer(t)
+	var vc VolumeController = newFakeClient(t, srv)
+	ctx := context.Background()
+
+	require.NoError(t, vc.SetVolume(ctx, 0.5))
+	vol, err := vc.Volume(ctx)
+	require.NoError(t, err)
+	assert.InDelta(t, 0.5, vol, 0.001)
+
+	requests := countServerInfo(srv)
+	muted, err := vc.ToggleMute(ctx)
+	require.NoError(t, err)
+	assert.True(t, muted)
+	assert.Equal(t, int32(2), atomic.LoadInt32(requests), "Mute and SetMute look up the default sink once each")
+	muted, err = vc.Mute(ctx)
+	require.NoError(t, err)
+	assert.True(t, muted)
+	require.NoError(t, vc.SetMute(ctx, false))
+	assert.False(t, srv.sink(0, "").Muted)
+}
+
+func TestSetVolumeClamp(t *testing.T) {
+	srv := newFakeServer(t)
+	c := newFakeClient(t, srv)
+	ctx := context.Background()
+
+	require.NoError(t, c.SetVolume(ctx, 2.0))
+	assert.Equal(t, CVolume{2 * VolumeNorm}, srv.volume("fake"))
+	require.NoError(t, c.SetVolume(ctx, 1e6))
+	assert.Equal(t, CVolume{VolumeMax}, srv.volume("fake"), "the volume must be clamped, not wrapped")
+	require.NoError(t, c.SetVolume(ctx, -1))
+	assert.Equal(t, CVolume{VolumeMuted}, srv.volume("fake"))
+}
+
+func TestDisallowBoost(t *testing.T) {
+	srv := newFakeServer(t)
+	c := newFakeClient(t, srv, WithAllowBoost(false))
+	ctx := context.Background()
+
+	require.NoError(t, c.SetVolume(ctx, 2.0))
+	assert.Equal(t, CVolume{VolumeNorm}, srv.volume("fake"))
+	require.NoError(t, c.SetSinkVolume(ctx, "fake", 1.2))
+	assert.Equal(t, CVolume{VolumeNorm}, srv.volume("fake"))
+	require.NoError(t, c.AdjustVolume(ctx, 0.5))
+	assert.Equal(t, CVolume{VolumeNorm}, srv.volume("fake"))
+	require.NoError(t, c.RampVolume(ctx, "fake", 1.5, 0))
+	assert.Equal(t, CVolume{VolumeNorm}, srv.volume("fake"))
+	require.NoError(t, c.SetVolume(ctx, 0.5))
+	assert.Equal(t, CVolume{VolumeNorm / 2}, srv.volume("fake"))
+}
+
+func TestPulseSinkEnv(t *testing.T) {
+	t.Setenv("PULSE_SINK", "zone2")
+	srv := newFakeServer(t)
+	zone2 := srv.sinks[0]
+	zone2.Index, zone2.Name = 1, "zone2"
+	srv.sinks = append(srv.sinks, zone2)
+	c := newFakeClient(t, srv)
+	ctx := context.Background()
+
+	require.NoError(t, c.SetVolume(ctx, 0.25))
+	assert.Equal(t, CVolume{VolumeNorm / 4}, srv.volume("zone2"))
+	assert.Equal(t, CVolume{0x8000, 0x8000}, srv.volume("fake"))
+	vol, err := c.Volume(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, float32(0.25), vol)
+
+	require.NoError(t, c.SetMute(ctx, true))
+	muted, err := c.Mute(ctx)
+	require.NoError(t, err)
+	assert.True(t, muted)
+	assert.False(t, srv.sink(0, "fake").Muted)
+}
+
+func TestSinkByIndex(t *testing.T) {
+	srv := newFakeServer(t)
+	c := newFakeClient(t, srv)
+	ctx := context.Background()
+
+	require.NoError(t, c.SetSinkVolumeByIndex(ctx, 0, 0.25))
+	assert.Equal(t, CVolume{VolumeNorm / 4}, srv.volume("fake"))
+	require.NoError(t, c.SetSinkMuteByIndex(ctx, 0, true))
+	assert.True(t, srv.sink(0, "").Muted)
+	require.NoError(t, c.SetSinkMuteByIndex(ctx, 0, false))
+	assert.False(t, srv.sink(0, "").Muted)
+
+	assert.ErrorIs(t, c.SetSinkVolumeByIndex(ctx, 7, 1), ErrNoSuchEntity)
+	assert.ErrorIs(t, c.SetSinkMuteByIndex(ctx, 7, true), ErrNoSuchEntity)
+}
+
+func TestToggleSinkMute(t *testing.T) {
+	srv := newFakeServer(t)
+	zone2 := srv.sinks[0]
+	zone2.Index, zone2.Name = 1, "zone2"
+	srv.sinks = append(srv.sinks, zone2)
+	c := newFakeClient(t, srv)
+	ctx := context.Background()
+
+	muted, err := c.ToggleSinkMute(ctx, "zone2")
+	require.NoError(t, err)
+	assert.True(t, muted)
+	assert.True(t, srv.sink(1, "").Muted)
+	assert.False(t, srv.sink(0, "fake").Muted)
+
+	muted, err = c.ToggleSinkMute(ctx, "zone2")
+	require.NoError(t, err)
+	assert.False(t, muted)
+	assert.False(t, srv.sink(1, "").Muted)
+
+	_, err = c.ToggleSinkMute(ctx, "missing")
+	assert.Error(t, err)
+}
+
+func TestSetVolumeAndGet(t *testing.T) {
+	srv := newFakeServer(t)
+	c := newFakeClient(t, srv, WithAllowBoost(false))
+	ctx := context.Background()
+
+	vol, err := c.SetVolumeAndGet(ctx, 0.5)
+	require.NoError(t, err)
+	assert.Equal(t, float32(0.5), vol)
+	vol, err = c.SetVolumeAndGet(ctx, 3)
+	require.NoError(t, err)
+	assert.Equal(t, float32(1), vol, "the clamped volume is returned")
+}
+
+func TestToggleSourceMute(t *testing.T) {
+	srv := newFakeServer(t)
+	mic := srv.sources[0]
+	mic.Index, mic.Name, mic.MonitorSinkName = 1, "mic", ""
+	srv.sources = append(srv.sources, mic)
+	c := newFakeClient(t, srv)
+	ctx := context.Background()
+
+	muted, err := c.ToggleSourceMute(ctx, "mic")
+	require.NoError(t, err)
+	assert.True(t, muted)
+	srv.mu.Lock()
+	assert.True(t, srv.sources[1].Muted)
+	assert.False(t, srv.sources[0].Muted)
+	srv.mu.Unlock()
+
+	// the default source of the fake server is fake.monitor
+	muted, err = c.ToggleDefaultSourceMute(ctx)
+	require.NoError(t, err)
+	assert.True(t, muted)
+	muted, err = c.ToggleDefaultSourceMute(ctx)
+	require.NoError(t, err)
+	assert.False(t, muted)
+	srv.mu.Lock()
+	assert.False(t, srv.sources[0].Muted)
+	srv.mu.Unlock()
+
+	_, err = c.ToggleSourceMute(ctx, "missing")
+	assert.ErrorIs(t, err, ErrNoSuchEntity)
+}
+
+func TestSetVolumeStep(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.sinks[0].NVolumeSteps = 101
+	c := newFakeClient(t, srv)
+	ctx := context.Background()
+
+	require.NoError(t, c.SetVolumeStep(ctx, "fake", 50))
+	assert.Equal(t, CVolume{VolumeNorm / 2}, srv.volume("fake"))
+	require.NoError(t, c.SetVolumeStep(ctx, "fake", 33))
+	assert.Equal(t, CVolume{21627}, srv.volume("fake")) // 0.33 * VolumeNorm, rounded
+	sink, err := c.GetSinkByName(ctx, "fake")
+	require.NoError(t, err)
+	assert.Equal(t, uint32(101), sink.VolumeSteps())
+	assert.Equal(t, uint32(33), sink.VolumeStep())
+	require.NoError(t, c.SetVolumeStep(ctx, "fake", 100))
+	assert.Equal(t, CVolume{VolumeNorm}, srv.volume("fake"))
+
+	assert.ErrorIs(t, c.SetVolumeStep(ctx, "fake", 101), ErrInvalidArgument)
+	srv.mu.Lock()
+	srv.sinks[0].NVolumeSteps = 0
+	srv.mu.Unlock()
+	assert.ErrorIs(t, c.SetVolumeStep(ctx, "fake", 0), ErrInvalidArgument)
+	assert.ErrorIs(t, c.SetVolumeStep(ctx, "missing", 0), ErrNoSuchEntity)
+
+	boosted := Sink{NVolumeSteps: 11, CVolume: CVolume{2 * VolumeNorm}}
+	assert.Equal(t, uint32(10), boosted.VolumeStep())
+	assert.Equal(t, uint32(0), (&Sink{}).VolumeStep())
+}
+
+func TestVolumePercent(t *testing.T) {
+	srv := newFakeServer(t)
+	c := newFakeClient(t, srv)
+	ctx := context.Background()
+
+	percent, err := c.VolumePercent(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(50), percent)
+
+	for p := uint32(0); p <= 150; p++ {
+		require.NoError(t, c.SetVolumePercent(ctx, p))
+		percent, err = c.VolumePercent(ctx)
+		require.NoError(t, err)
+		require.Equal(t, p, percent)
+	}
+	assert.Equal(t, CVolume{0x18000}, srv.volume("fake"))
+	require.NoError(t, c.SetVolumePercent(ctx, 1<<31))
+	assert.Equal(t, CVolume{VolumeMax}, srv.volume("fake"))
+
+	c = newFakeClient(t, srv, WithAllowBoost(false))
+	require.NoError(t, c.SetVolumePercent(ctx, 120))
+	assert.Equal(t, CVolume{VolumeNorm}, srv.volume("fake"))
+}
+
+func TestSetSinkVolumeMapped(t *testing.T) {
+	srv := newFakeServer(t)
+	// a remapped sink with the rear channels first
+	surround := srv.sinks[0]
+	surround.Index, surround.Name = 1, "remapped"
+	surround.ChannelMap = ChannelMap{byte(ChannelRearLeft), byte(ChannelRearRight), byte(ChannelFrontLeft), byte(ChannelFrontRight)}
+	surround.CVolume = CVolume{VolumeNorm, VolumeNorm, VolumeNorm, VolumeNorm}
+	srv.sinks = append(srv.sinks, surround)
+	c := newFakeClient(t, srv)
+	ctx := context.Background()
+
+	require.NoError(t, c.SetSinkVolumeMapped(ctx, "remapped", map[ChannelPosition]float32{
+		ChannelFrontLeft: 0.5,
+		ChannelRearRight: 0.25,
+	}))
+	assert.Equal(t, CVolume{VolumeNorm, VolumeNorm / 4, VolumeNorm / 2, VolumeNorm}, srv.volume("remapped"))
+
+	err := c.SetSinkVolumeMapped(ctx, "remapped", map[ChannelPosition]float32{ChannelLFE: 1})
+	assert.ErrorIs(t, err, ErrInvalidArgument)
+	assert.Equal(t, CVolume{VolumeNorm, VolumeNorm / 4, VolumeNorm / 2, VolumeNorm}, srv.volume("remapped"))
+}
+
+func TestRetryOnTransientEntity(t *testing.T) {
+	srv := newFakeServer(t)
+	// the default sink is gone until the server info is read again, as if it was replugged
+	srv.update("fake", func(sink *Sink) { sink.Name = "unplugged" })
+	requests := countServerInfo(srv)
+	srv.mu.Lock()
+	h := srv.handlers[commandGetServerInfo]
+	srv.mu.Unlock()
+	srv.handle(commandGetServerInfo, func(req *bytes.Buffer) ([]interface{}, uint32) {
+		if atomic.LoadInt32(requests) > 1 {
+			srv.update("unplugged", func(sink *Sink) { sink.Name = "fake" })
+		}
+		return h(req)
+	})
+	ctx := context.Background()
+
+	c := newFakeClient(t, srv)
+	err := c.SetVolume(ctx, 0.25)
+	assert.ErrorIs(t, err, ErrNoSuchEntity)
+
+	c = newFakeClient(t, srv, WithRetryOnTransientEntity(3, time.Millisecond), WithServerInfoCacheTTL(time.Minute))
+	require.NoError(t, c.SetVolume(ctx, 0.25))
+	assert.Equal(t, CVolume{VolumeNorm / 4}, srv.volume("fake"))
+	assert.Equal(t, int32(3), atomic.LoadInt32(requests), "server info was not read again")
+	volume, err := c.Volume(ctx)
+	require.NoError(t, err)
+	assert.InDelta(t, 0.25, volume, 0.001)
+
+	// give up after the last attempt
+	srv.update("fake", func(sink *Sink) { sink.Name = "unplugged" })
+	srv.handle(commandGetServerInfo, h)
+	_, err = c.Mute(ctx)
+	assert.ErrorIs(t, err, ErrNoSuchEntity)
+}
@@ -0,0 +1,44 @@
+package pulseaudio
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os/exec"
+)
+
+// xpropPath is the binary used to read the X11 root window properties.
+var xpropPath = "xprop"
+
+// errNoX11Cookie is returned if the root window has no PULSE_COOKIE property.
+var errNoX11Cookie = errors.New("no PULSE_COOKIE property on the X11 root window")
+
+// WithX11Cookie makes the client fall back to the cookie the server publishes in the PULSE_COOKIE
+// property of the X11 root window if the cookie file can't be read, like libpulse does. The
+// property is read with xprop from the display named by DISPLAY.
+func WithX11Cookie(enabled bool) ClientOpt {
+	return func(client *Client) {
+		client.x11Cookie = enabled
+	}
+}
+
+// runX11Cookie reads the hex encoded cookie from the PULSE_COOKIE root window property.
+func runX11Cookie(ctx context.Context) ([]byte, error) {
+	out, err := exec.CommandContext(ctx, xpropPath, "-root", "PULSE_COOKIE").Output()
+	if err != nil {
+		return nil, fmt.Errorf("error executing command: %w", err)
+	}
+	// the output looks like: PULSE_COOKIE(STRING) = "0123abcd..."
+	parts := bytes.SplitN(out, []byte("="), 2)
+	if len(parts) != 2 {
+		return nil, errNoX11Cookie
+	}
+	value := bytes.Trim(bytes.TrimSpace(parts[1]), `"`)
+	cookie := make([]byte, hex.DecodedLen(len(value)))
+	if _, err := hex.Decode(cookie, value); err != nil {
+		return nil, fmt.Errorf("invalid PULSE_COOKIE property: %w", err)
+	}
+	return cookie, nil
+}
@@ -0,0 +1,51 @@
+package pulseaudio
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeXprop replaces xprop with a script printing output.
+func fakeXprop(t *testing.T, output string) {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "xprop")
+	require.NoError(t, os.WriteFile(p, []byte("#!/bin/sh\ncat <<'EOF'\n"+output+"\nEOF\n"), 0755))
+	old := xpropPath
+	xpropPath = p
+	t.Cleanup(func() { xpropPath = old })
+}
+
+func TestX11Cookie(t *testing.T) {
+	fakeXprop(t, `PULSE_COOKIE(STRING) = "`+strings.Repeat("00", 256)+`"`)
+	srv := newFakeServer(t)
+	missing := filepath.Join(t.TempDir(), "cookie")
+
+	c := NewClient(Opts{Addr: srv.uri(), Cookie: missing, RequestTimeout: time.Second}, WithX11Cookie(true))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go func() { _ = c.Run(ctx) }()
+	defer c.Close()
+	require.NoError(t, c.Ping(ctx))
+}
+
+func TestRunX11Cookie(t *testing.T) {
+	fakeXprop(t, `PULSE_COOKIE(STRING) = "0102ff"`)
+	cookie, err := runX11Cookie(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []byte{1, 2, 0xff}, cookie)
+
+	fakeXprop(t, "PULSE_COOKIE:  not found.")
+	_, err = runX11Cookie(context.Background())
+	assert.ErrorIs(t, err, errNoX11Cookie)
+
+	fakeXprop(t, `PULSE_COOKIE(STRING) = "xyz"`)
+	_, err = runX11Cookie(context.Background())
+	assert.Error(t, err)
+}